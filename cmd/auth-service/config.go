@@ -0,0 +1,665 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/steve-kaufman/go-auth-service/implementations/notify"
+	"github.com/steve-kaufman/go-auth-service/implementations/security"
+	"github.com/steve-kaufman/go-auth-service/implementations/security/jwtgen"
+	"github.com/steve-kaufman/go-auth-service/implementations/ui"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+	"github.com/steve-kaufman/go-auth-service/usecases"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config is read from an optional JSON file first, then overridden by any
+// AUTH_* environment variables that are set.
+type Config struct {
+	ListenAddr   string `json:"listen_addr"`
+	DatabasePath string `json:"database_path"`
+	// SigningAlgorithm is HS256, which signs with AccessSecret and
+	// RefreshSecret, or one of RS256, ES256 and EdDSA, which sign both token
+	// types with the key in PrivateKeyFile. HS256 secrets must be at least 32
+	// bytes.
+	SigningAlgorithm string `json:"signing_algorithm"`
+	AccessSecret     string `json:"access_secret"`
+	RefreshSecret    string `json:"refresh_secret"`
+	PrivateKeyFile   string `json:"private_key_file"`
+	// KeyDir replaces the secrets or PrivateKeyFile with a rotatable keyring.
+	// It's reread on SIGHUP and, if KeyReloadInterval is set, on that timer.
+	// A new key is published for as long as the JWKS may be cached before it
+	// signs anything.
+	KeyDir            string   `json:"key_dir"`
+	KeyReloadInterval Duration `json:"key_reload_interval"`
+	// Issuer and Audience, when set, become the iss and aud claims and tokens
+	// that don't match them are rejected. AUTH_AUDIENCE is comma-separated.
+	Issuer          string   `json:"issuer"`
+	Audience        []string `json:"audience"`
+	AccessTokenTTL  Duration `json:"access_token_ttl"`
+	RefreshTokenTTL Duration `json:"refresh_token_ttl"`
+	ResetTokenTTL   Duration `json:"reset_token_ttl"`
+	// NotifyFile is where password reset and email verification tokens are
+	// written when there's no mail server, one JSON object per line, for
+	// delivery by another process. Tokens are secrets, so they're never
+	// logged: with neither, password reset is disabled.
+	NotifyFile string `json:"notify_file"`
+	// RequireVerifiedEmail makes an email required at signup, and refuses
+	// logins until it's verified. There's no way to add an email after
+	// signup, so the service won't start with it set while any user has
+	// none. Verification and reset tokens are emailed from MailFrom through
+	// the SMTP server at SMTPAddr, or written as .eml files to MailDropDir,
+	// or failing both, to NotifyFile. VerifyEmailURL, if set, is linked to
+	// from the email with the token in its query string.
+	RequireVerifiedEmail bool     `json:"require_verified_email"`
+	EmailVerificationTTL Duration `json:"email_verification_ttl"`
+	MailFrom             string   `json:"mail_from"`
+	SMTPAddr             string   `json:"smtp_addr"`
+	SMTPUsername         string   `json:"smtp_username"`
+	SMTPPassword         string   `json:"smtp_password"`
+	MailDropDir          string   `json:"mail_drop_dir"`
+	VerifyEmailURL       string   `json:"verify_email_url"`
+	// PasswordHash is the algorithm new hashes are made with, bcrypt or
+	// argon2id. Hashes from either, or imported scrypt and PBKDF2 hashes,
+	// still match and are rehashed when their user next logs in.
+	PasswordHash string `json:"password_hash"`
+	// BcryptCost is used for new hashes, and hashes below BcryptMinCost,
+	// which defaults to BcryptCost, are rehashed on login. Setting
+	// BcryptTargetLatency overrides BcryptCost, which is then ignored: the
+	// cost is calibrated at startup to the highest that hashes within the
+	// target, starting from BcryptMinCost or bcrypt's default cost, and
+	// BcryptMinCost defaults to the calibrated cost instead.
+	BcryptCost          int      `json:"bcrypt_cost"`
+	BcryptMinCost       int      `json:"bcrypt_min_cost"`
+	BcryptTargetLatency Duration `json:"bcrypt_target_latency"`
+	Argon2MemoryKiB     int      `json:"argon2_memory_kib"`
+	Argon2Iterations    int      `json:"argon2_iterations"`
+	Argon2Parallelism   int      `json:"argon2_parallelism"`
+	// Passwords set at signup must have PasswordMinLength to
+	// PasswordMaxLength characters, at most PasswordMaxBytes bytes, a
+	// character from each PasswordRequire class, and mustn't contain the
+	// username or be in PasswordDenylistFile. Zero maximums aren't enforced,
+	// except that bcrypt caps PasswordMaxBytes at the 72 it uses.
+	// AUTH_PASSWORD_REQUIRE is comma-separated.
+	PasswordMinLength    int      `json:"password_min_length"`
+	PasswordMaxLength    int      `json:"password_max_length"`
+	PasswordMaxBytes     int      `json:"password_max_bytes"`
+	PasswordRequire      []string `json:"password_require"`
+	PasswordDenylistFile string   `json:"password_denylist_file"`
+	// BreachIndexFile, built by cmd/breach-index, lists breached passwords
+	// that can't be chosen.
+	BreachIndexFile string `json:"breach_index_file"`
+	// After LockoutThreshold failed logins in a row, by username or email,
+	// an account is locked for LockoutDuration, doubling with each further
+	// failure up to LockoutMaxDuration. Unknown identifiers are locked the
+	// same way. Failures are forgotten LockoutResetAfter after the last one.
+	// A zero LockoutThreshold disables lockout.
+	LockoutThreshold   int      `json:"lockout_threshold"`
+	LockoutDuration    Duration `json:"lockout_duration"`
+	LockoutMaxDuration Duration `json:"lockout_max_duration"`
+	LockoutResetAfter  Duration `json:"lockout_reset_after"`
+}
+
+// Duration is written as a Go duration string such as "15m" or "720h".
+type Duration time.Duration
+
+func (duration *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+	err := json.Unmarshal(data, &str)
+	if err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	*duration = Duration(parsed)
+	return nil
+}
+
+type LookupEnv func(key string) (string, bool)
+
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:           ":8080",
+		DatabasePath:         "auth.db",
+		SigningAlgorithm:     "HS256",
+		AccessTokenTTL:       Duration(jwtgen.DefaultLifetimes.Access),
+		RefreshTokenTTL:      Duration(jwtgen.DefaultLifetimes.Refresh),
+		ResetTokenTTL:        Duration(usecases.DefaultResetTokenTTL),
+		EmailVerificationTTL: Duration(usecases.DefaultEmailVerificationTTL),
+		PasswordHash:         "bcrypt",
+		BcryptCost:           security.DefaultBcryptCost,
+		Argon2MemoryKiB:      int(security.DefaultArgon2idParams.Memory),
+		Argon2Iterations:     int(security.DefaultArgon2idParams.Iterations),
+		Argon2Parallelism:    int(security.DefaultArgon2idParams.Parallelism),
+		PasswordMinLength:    8,
+		LockoutThreshold:     5,
+		LockoutDuration:      Duration(usecases.DefaultLockout),
+		LockoutMaxDuration:   Duration(usecases.DefaultMaxLockout),
+		LockoutResetAfter:    Duration(usecases.DefaultLockoutResetAfter),
+	}
+}
+
+// LoadConfig builds a Config from the defaults, the file at path (skipped if
+// path is empty) and the environment, then validates it.
+func LoadConfig(path string, lookupEnv LookupEnv) (Config, error) {
+	config := DefaultConfig()
+	if path == "" {
+		path, _ = lookupEnv("AUTH_CONFIG_FILE")
+	}
+	if path != "" {
+		err := config.loadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+	err := config.loadEnv(lookupEnv)
+	if err != nil {
+		return Config{}, err
+	}
+	return config, config.Validate()
+}
+
+func (config *Config) loadFile(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	err = json.Unmarshal(contents, config)
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (config *Config) loadEnv(lookupEnv LookupEnv) error {
+	stringVars := map[string]*string{
+		"AUTH_LISTEN_ADDR":            &config.ListenAddr,
+		"AUTH_DATABASE_PATH":          &config.DatabasePath,
+		"AUTH_SIGNING_ALGORITHM":      &config.SigningAlgorithm,
+		"AUTH_ACCESS_SECRET":          &config.AccessSecret,
+		"AUTH_REFRESH_SECRET":         &config.RefreshSecret,
+		"AUTH_PRIVATE_KEY_FILE":       &config.PrivateKeyFile,
+		"AUTH_KEY_DIR":                &config.KeyDir,
+		"AUTH_ISSUER":                 &config.Issuer,
+		"AUTH_NOTIFY_FILE":            &config.NotifyFile,
+		"AUTH_MAIL_FROM":              &config.MailFrom,
+		"AUTH_SMTP_ADDR":              &config.SMTPAddr,
+		"AUTH_SMTP_USERNAME":          &config.SMTPUsername,
+		"AUTH_SMTP_PASSWORD":          &config.SMTPPassword,
+		"AUTH_MAIL_DROP_DIR":          &config.MailDropDir,
+		"AUTH_VERIFY_EMAIL_URL":       &config.VerifyEmailURL,
+		"AUTH_PASSWORD_HASH":          &config.PasswordHash,
+		"AUTH_PASSWORD_DENYLIST_FILE": &config.PasswordDenylistFile,
+		"AUTH_BREACH_INDEX_FILE":      &config.BreachIndexFile,
+	}
+	for key, field := range stringVars {
+		if value, ok := lookupEnv(key); ok {
+			*field = value
+		}
+	}
+	listVars := map[string]*[]string{
+		"AUTH_AUDIENCE":         &config.Audience,
+		"AUTH_PASSWORD_REQUIRE": &config.PasswordRequire,
+	}
+	for key, field := range listVars {
+		if value, ok := lookupEnv(key); ok {
+			*field = splitList(value)
+		}
+	}
+	boolVars := map[string]*bool{
+		"AUTH_REQUIRE_VERIFIED_EMAIL": &config.RequireVerifiedEmail,
+	}
+	for key, field := range boolVars {
+		value, ok := lookupEnv(key)
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false, got %q", key, value)
+		}
+		*field = parsed
+	}
+	intVars := map[string]*int{
+		"AUTH_BCRYPT_COST":         &config.BcryptCost,
+		"AUTH_BCRYPT_MIN_COST":     &config.BcryptMinCost,
+		"AUTH_ARGON2_MEMORY_KIB":   &config.Argon2MemoryKiB,
+		"AUTH_ARGON2_ITERATIONS":   &config.Argon2Iterations,
+		"AUTH_ARGON2_PARALLELISM":  &config.Argon2Parallelism,
+		"AUTH_PASSWORD_MIN_LENGTH": &config.PasswordMinLength,
+		"AUTH_PASSWORD_MAX_LENGTH": &config.PasswordMaxLength,
+		"AUTH_PASSWORD_MAX_BYTES":  &config.PasswordMaxBytes,
+		"AUTH_LOCKOUT_THRESHOLD":   &config.LockoutThreshold,
+	}
+	for key, field := range intVars {
+		value, ok := lookupEnv(key)
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer, got %q", key, value)
+		}
+		*field = parsed
+	}
+	durationVars := map[string]*Duration{
+		"AUTH_KEY_RELOAD_INTERVAL":    &config.KeyReloadInterval,
+		"AUTH_ACCESS_TOKEN_TTL":       &config.AccessTokenTTL,
+		"AUTH_REFRESH_TOKEN_TTL":      &config.RefreshTokenTTL,
+		"AUTH_RESET_TOKEN_TTL":        &config.ResetTokenTTL,
+		"AUTH_EMAIL_VERIFICATION_TTL": &config.EmailVerificationTTL,
+		"AUTH_BCRYPT_TARGET_LATENCY":  &config.BcryptTargetLatency,
+		"AUTH_LOCKOUT_DURATION":       &config.LockoutDuration,
+		"AUTH_LOCKOUT_MAX_DURATION":   &config.LockoutMaxDuration,
+		"AUTH_LOCKOUT_RESET_AFTER":    &config.LockoutResetAfter,
+	}
+	for key, field := range durationVars {
+		value, ok := lookupEnv(key)
+		if !ok {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a duration, got %q", key, value)
+		}
+		*field = Duration(parsed)
+	}
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Validate reports every problem with the config at once so that a bad
+// deployment can be fixed in one pass.
+func (config Config) Validate() error {
+	var problems []string
+	if config.ListenAddr == "" {
+		problems = append(problems, "listen address is required")
+	}
+	if config.DatabasePath == "" {
+		problems = append(problems, "database path is required")
+	}
+	problems = append(problems, config.validateSigning()...)
+	if config.KeyReloadInterval < 0 {
+		problems = append(problems, "key reload interval can't be negative")
+	}
+	if config.AccessTokenTTL <= 0 {
+		problems = append(problems, "access token TTL must be positive")
+	}
+	if config.RefreshTokenTTL <= 0 {
+		problems = append(problems, "refresh token TTL must be positive")
+	}
+	if config.ResetTokenTTL <= 0 {
+		problems = append(problems, "reset token TTL must be positive")
+	}
+	problems = append(problems, config.validatePasswordHash()...)
+	problems = append(problems, config.validatePasswordPolicy()...)
+	problems = append(problems, config.validateMail()...)
+	problems = append(problems, config.validateLockout()...)
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (config Config) validateSigning() []string {
+	switch config.SigningAlgorithm {
+	case "HS256", "RS256", "ES256", "EdDSA":
+	default:
+		return []string{fmt.Sprintf(
+			"signing algorithm must be HS256, RS256, ES256 or EdDSA, got %q",
+			config.SigningAlgorithm,
+		)}
+	}
+	if config.KeyDir != "" {
+		if config.PrivateKeyFile != "" {
+			return []string{"key directory and private key file can't both be set"}
+		}
+		return nil
+	}
+	if config.SigningAlgorithm == "HS256" {
+		return config.validateSecrets()
+	}
+	if config.PrivateKeyFile == "" {
+		return []string{config.SigningAlgorithm + " needs a private key file"}
+	}
+	return nil
+}
+
+func (config Config) validatePasswordHash() []string {
+	switch config.PasswordHash {
+	case "bcrypt":
+		return config.validateBcrypt()
+	case "argon2id":
+		if config.Argon2MemoryKiB < 1 || config.Argon2Iterations < 1 ||
+			config.Argon2Parallelism < 1 || config.Argon2Parallelism > math.MaxUint8 {
+			return []string{"argon2 memory, iterations and parallelism must be " +
+				"positive, and parallelism at most 255"}
+		}
+		err := config.argon2idHasher().Validate()
+		if err != nil {
+			return []string{err.Error()}
+		}
+		return nil
+	}
+	return []string{fmt.Sprintf(
+		"password hash must be bcrypt or argon2id, got %q", config.PasswordHash,
+	)}
+}
+
+func (config Config) validateBcrypt() []string {
+	var problems []string
+	if config.BcryptTargetLatency == 0 && !isBcryptCost(config.BcryptCost) {
+		problems = append(problems, fmt.Sprintf(
+			"bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost,
+		))
+	}
+	if config.BcryptMinCost != 0 && !isBcryptCost(config.BcryptMinCost) {
+		problems = append(problems, fmt.Sprintf(
+			"bcrypt min cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost,
+		))
+	}
+	if config.BcryptTargetLatency < 0 {
+		problems = append(problems, "bcrypt target latency can't be negative")
+	}
+	if config.BcryptTargetLatency == 0 && config.BcryptMinCost > config.BcryptCost {
+		problems = append(problems, "bcrypt min cost can't be above bcrypt cost")
+	}
+	return problems
+}
+
+func isBcryptCost(cost int) bool {
+	return cost >= bcrypt.MinCost && cost <= bcrypt.MaxCost
+}
+
+// passwordHasher makes new hashes, calibrating the bcrypt cost first if
+// asked to. Only call it on a valid Config.
+func (config Config) passwordHasher() (interfaces.PasswordRehasher, error) {
+	if config.PasswordHash == "argon2id" {
+		return config.argon2idHasher(), nil
+	}
+	// A zero MinCost makes the hasher rehash anything below its cost.
+	hasher := security.BcryptHasher{
+		Cost:    config.BcryptCost,
+		MinCost: config.BcryptMinCost,
+	}
+	if config.BcryptTargetLatency == 0 {
+		return hasher, nil
+	}
+	minCost := config.BcryptMinCost
+	if minCost == 0 {
+		minCost = bcrypt.DefaultCost
+	}
+	cost, err := security.CalibrateBcryptCost(
+		time.Duration(config.BcryptTargetLatency), minCost, bcrypt.MaxCost,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("calibrating bcrypt cost: %w", err)
+	}
+	log.Printf("calibrated bcrypt cost to %d", cost)
+	hasher.Cost = cost
+	return hasher, nil
+}
+
+func (config Config) validatePasswordPolicy() []string {
+	var problems []string
+	if config.PasswordMinLength < 1 {
+		problems = append(problems, "password min length must be positive")
+	}
+	if config.PasswordMaxLength < 0 || config.PasswordMaxBytes < 0 {
+		problems = append(problems, "password max length and bytes can't be negative")
+	}
+	if config.PasswordMaxLength > 0 && config.PasswordMaxLength < config.PasswordMinLength {
+		problems = append(problems, "password max length can't be below min length")
+	}
+	if config.PasswordHash == "bcrypt" &&
+		config.PasswordMaxBytes > security.BcryptMaxPasswordBytes {
+		problems = append(problems, fmt.Sprintf(
+			"password max bytes can't be above %d with bcrypt",
+			security.BcryptMaxPasswordBytes,
+		))
+	}
+	for _, class := range config.PasswordRequire {
+		if !security.IsCharClass(security.CharClass(class)) {
+			problems = append(problems, fmt.Sprintf(
+				"password require must list lower, upper, digit or symbol, got %q",
+				class,
+			))
+		}
+	}
+	return problems
+}
+
+// passwordPolicy loads the denylist, so it can still fail on a valid Config.
+func (config Config) passwordPolicy() (security.PasswordPolicy, error) {
+	policy := security.PasswordPolicy{
+		MinLength:      config.PasswordMinLength,
+		MaxLength:      config.PasswordMaxLength,
+		MaxBytes:       config.PasswordMaxBytes,
+		RejectUsername: true,
+	}
+	if policy.MaxBytes == 0 && config.PasswordHash == "bcrypt" {
+		policy.MaxBytes = security.BcryptMaxPasswordBytes
+	}
+	for _, class := range config.PasswordRequire {
+		policy.RequiredClasses = append(policy.RequiredClasses, security.CharClass(class))
+	}
+	if config.PasswordDenylistFile == "" {
+		return policy, nil
+	}
+	denylist, err := security.LoadDenylist(config.PasswordDenylistFile)
+	if err != nil {
+		return security.PasswordPolicy{}, fmt.Errorf("loading password denylist: %w", err)
+	}
+	policy.Denylist = denylist
+	return policy, nil
+}
+
+func (config Config) validateMail() []string {
+	var problems []string
+	if config.EmailVerificationTTL <= 0 {
+		problems = append(problems, "email verification TTL must be positive")
+	}
+	if config.SMTPAddr != "" && config.MailDropDir != "" {
+		problems = append(problems, "SMTP address and mail drop directory can't both be set")
+	}
+	if config.RequireVerifiedEmail && config.SMTPAddr == "" &&
+		config.MailDropDir == "" && config.NotifyFile == "" {
+		problems = append(problems, "requiring verified emails needs an SMTP "+
+			"address, mail drop directory or notify file to send tokens to")
+	}
+	if (config.SMTPAddr != "" || config.MailDropDir != "") && config.MailFrom == "" {
+		problems = append(problems, "mail from address is required to send mail")
+	}
+	if config.MailFrom != "" {
+		if _, err := mail.ParseAddress(config.MailFrom); err != nil {
+			problems = append(problems, fmt.Sprintf(
+				"mail from must be an email address, got %q", config.MailFrom,
+			))
+		}
+	}
+	if config.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(config.SMTPAddr); err != nil {
+			problems = append(problems, fmt.Sprintf(
+				"SMTP address must be host:port, got %q", config.SMTPAddr,
+			))
+		}
+	}
+	if config.VerifyEmailURL != "" {
+		parsed, err := url.Parse(config.VerifyEmailURL)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			problems = append(problems, fmt.Sprintf(
+				"verify email URL must be absolute, got %q", config.VerifyEmailURL,
+			))
+		}
+	}
+	return problems
+}
+
+// mailer sends through the mail server or drop directory, if one is set.
+func (config Config) mailer() (notify.Mailer, bool) {
+	var sender notify.MailSender
+	switch {
+	case config.SMTPAddr != "":
+		sender = notify.SMTPSender{
+			Addr:     config.SMTPAddr,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+		}
+	case config.MailDropDir != "":
+		sender = notify.FileDropSender{Dir: config.MailDropDir}
+	default:
+		return notify.Mailer{}, false
+	}
+	return notify.Mailer{
+		Sender:    sender,
+		From:      config.MailFrom,
+		VerifyURL: config.VerifyEmailURL,
+	}, true
+}
+
+// verificationNotifier emails verification tokens if a mail server or drop
+// directory is set, or else logs them to notifyLog, if there is one.
+func (config Config) verificationNotifier(
+	notifyLog *notify.Log,
+) interfaces.EmailVerificationNotifier {
+	if mailer, ok := config.mailer(); ok {
+		return mailer
+	}
+	if notifyLog == nil {
+		return nil
+	}
+	return notifyLog
+}
+
+// resetNotifier sends reset tokens the same way. With neither it's nil,
+// which disables password reset, since there's nowhere safe to send them.
+func (config Config) resetNotifier(notifyLog *notify.Log) interfaces.ResetNotifier {
+	if mailer, ok := config.mailer(); ok {
+		return mailer
+	}
+	if notifyLog == nil {
+		return nil
+	}
+	return notifyLog
+}
+
+func (config Config) validateLockout() []string {
+	var problems []string
+	if config.LockoutThreshold < 0 {
+		problems = append(problems, "lockout threshold can't be negative")
+	}
+	if config.LockoutDuration <= 0 || config.LockoutMaxDuration <= 0 ||
+		config.LockoutResetAfter <= 0 {
+		problems = append(problems, "lockout durations must be positive")
+	}
+	if config.LockoutMaxDuration < config.LockoutDuration {
+		problems = append(problems, "lockout max duration can't be below lockout duration")
+	}
+	return problems
+}
+
+func (config Config) lockoutPolicy() usecases.LockoutPolicy {
+	return usecases.LockoutPolicy{
+		MaxFailures: config.LockoutThreshold,
+		Lockout:     time.Duration(config.LockoutDuration),
+		MaxLockout:  time.Duration(config.LockoutMaxDuration),
+		ResetAfter:  time.Duration(config.LockoutResetAfter),
+	}
+}
+
+func (config Config) argon2idHasher() security.Argon2idHasher {
+	return security.Argon2idHasher{Params: security.Argon2idParams{
+		Memory:      uint32(config.Argon2MemoryKiB),
+		Iterations:  uint32(config.Argon2Iterations),
+		Parallelism: uint8(config.Argon2Parallelism),
+	}}
+}
+
+func (config Config) validateSecrets() []string {
+	var problems []string
+	if config.AccessSecret == "" {
+		problems = append(problems, "access token secret is required")
+	}
+	if config.RefreshSecret == "" {
+		problems = append(problems, "refresh token secret is required")
+	}
+	if config.AccessSecret != "" && len(config.AccessSecret) < jwtgen.MinHMACSecretLength {
+		problems = append(problems, fmt.Sprintf(
+			"access token secret must be at least %d bytes", jwtgen.MinHMACSecretLength,
+		))
+	}
+	if config.RefreshSecret != "" && len(config.RefreshSecret) < jwtgen.MinHMACSecretLength {
+		problems = append(problems, fmt.Sprintf(
+			"refresh token secret must be at least %d bytes", jwtgen.MinHMACSecretLength,
+		))
+	}
+	if config.AccessSecret != "" && config.AccessSecret == config.RefreshSecret {
+		problems = append(problems, "access and refresh secrets must differ")
+	}
+	return problems
+}
+
+// jwtConfig loads the signing keys, so it can still fail on a valid Config.
+func (config Config) jwtConfig(timeGetter jwtgen.TimeGetter) (jwtgen.Config, error) {
+	jwtConfig := jwtgen.Config{
+		Secrets: jwtgen.Secrets{
+			Access:  config.AccessSecret,
+			Refresh: config.RefreshSecret,
+		},
+		Lifetimes: jwtgen.Lifetimes{
+			Access:  time.Duration(config.AccessTokenTTL),
+			Refresh: time.Duration(config.RefreshTokenTTL),
+		},
+		Issuer:   config.Issuer,
+		Audience: config.Audience,
+	}
+	if config.KeyDir != "" {
+		keyring, err := jwtgen.NewKeyring(
+			config.KeyDir, config.SigningAlgorithm,
+			config.maxTokenLifetime(), ui.JWKSMaxAge, timeGetter,
+		)
+		if err != nil {
+			return jwtgen.Config{}, fmt.Errorf("loading key directory: %w", err)
+		}
+		jwtConfig.Keyring = keyring
+		return jwtConfig, nil
+	}
+	if config.SigningAlgorithm == "HS256" {
+		return jwtConfig, nil
+	}
+	key, err := jwtgen.LoadPrivateKeyFile(config.SigningAlgorithm, config.PrivateKeyFile)
+	if err != nil {
+		return jwtgen.Config{}, fmt.Errorf("loading signing key: %w", err)
+	}
+	jwtConfig.Keys = jwtgen.Keys{Access: key, Refresh: key}
+	return jwtConfig, nil
+}
+
+func (config Config) maxTokenLifetime() time.Duration {
+	if config.AccessTokenTTL > config.RefreshTokenTTL {
+		return time.Duration(config.AccessTokenTTL)
+	}
+	return time.Duration(config.RefreshTokenTTL)
+}
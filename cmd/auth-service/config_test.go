@@ -0,0 +1,792 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/steve-kaufman/go-auth-service/implementations/notify"
+	"github.com/steve-kaufman/go-auth-service/implementations/security"
+	"github.com/steve-kaufman/go-auth-service/implementations/security/jwtgen"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func mockEnv(vars map[string]string) LookupEnv {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := ioutil.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// withDefaults is DefaultConfig with the fields a test sets.
+func withDefaults(set func(config *Config)) Config {
+	config := DefaultConfig()
+	set(&config)
+	return config
+}
+
+type ConfigTest struct {
+	name string
+
+	fileContents string
+	env          map[string]string
+
+	expectedConfig Config
+	expectedErr    string
+}
+
+var configTests = []ConfigTest{
+	{
+		name: "Uses defaults with only secrets set",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":  "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET": "refresh secret of at least 32 bytes",
+		},
+
+		expectedConfig: Config{
+			ListenAddr:           ":8080",
+			DatabasePath:         "auth.db",
+			AccessSecret:         "access secret of at least 32 bytes",
+			RefreshSecret:        "refresh secret of at least 32 bytes",
+			SigningAlgorithm:     "HS256",
+			AccessTokenTTL:       Duration(15 * time.Minute),
+			RefreshTokenTTL:      Duration(30 * 24 * time.Hour),
+			ResetTokenTTL:        Duration(30 * time.Minute),
+			EmailVerificationTTL: Duration(24 * time.Hour),
+			PasswordHash:         "bcrypt",
+			BcryptCost:           12,
+			Argon2MemoryKiB:      65536,
+			Argon2Iterations:     3,
+			Argon2Parallelism:    2,
+			PasswordMinLength:    8,
+			LockoutThreshold:     5,
+			LockoutDuration:      Duration(time.Minute),
+			LockoutMaxDuration:   Duration(time.Hour),
+			LockoutResetAfter:    Duration(24 * time.Hour),
+		},
+	},
+	{
+		name: "Reads config file",
+
+		fileContents: `{
+			"listen_addr": ":9000",
+			"database_path": "/var/lib/auth.db",
+			"access_secret": "access secret of at least 32 bytes",
+			"refresh_secret": "refresh secret of at least 32 bytes",
+			"access_token_ttl": "5m",
+			"refresh_token_ttl": "168h",
+			"bcrypt_cost": 10
+		}`,
+
+		expectedConfig: withDefaults(func(config *Config) {
+			config.ListenAddr = ":9000"
+			config.DatabasePath = "/var/lib/auth.db"
+			config.AccessSecret = "access secret of at least 32 bytes"
+			config.RefreshSecret = "refresh secret of at least 32 bytes"
+			config.AccessTokenTTL = Duration(5 * time.Minute)
+			config.RefreshTokenTTL = Duration(168 * time.Hour)
+			config.BcryptCost = 10
+		}),
+	},
+	{
+		name: "Environment overrides config file",
+
+		fileContents: `{
+			"listen_addr": ":9000",
+			"access_secret": "access secret of at least 32 bytes",
+			"refresh_secret": "refresh secret of at least 32 bytes"
+		}`,
+		env: map[string]string{
+			"AUTH_LISTEN_ADDR":      ":9001",
+			"AUTH_BCRYPT_COST":      "4",
+			"AUTH_ACCESS_TOKEN_TTL": "1m",
+		},
+
+		expectedConfig: withDefaults(func(config *Config) {
+			config.ListenAddr = ":9001"
+			config.AccessSecret = "access secret of at least 32 bytes"
+			config.RefreshSecret = "refresh secret of at least 32 bytes"
+			config.AccessTokenTTL = Duration(time.Minute)
+			config.BcryptCost = 4
+		}),
+	},
+	{
+		name: "Rejects non-integer bcrypt cost",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":  "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET": "refresh secret of at least 32 bytes",
+			"AUTH_BCRYPT_COST":    "twelve",
+		},
+
+		expectedErr: "AUTH_BCRYPT_COST must be an integer",
+	},
+	{
+		name: "Rejects missing secrets",
+
+		expectedErr: "access token secret is required; refresh token secret is required",
+	},
+	{
+		name: "Rejects identical secrets",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":  "same secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET": "same secret of at least 32 bytes",
+		},
+
+		expectedErr: "access and refresh secrets must differ",
+	},
+	{
+		name: "Rejects short secrets",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":  "access",
+			"AUTH_REFRESH_SECRET": "refresh",
+		},
+
+		expectedErr: "access token secret must be at least 32 bytes; " +
+			"refresh token secret must be at least 32 bytes",
+	},
+	{
+		name: "Asymmetric signing needs a key file instead of secrets",
+
+		env: map[string]string{
+			"AUTH_SIGNING_ALGORITHM": "ES256",
+			"AUTH_PRIVATE_KEY_FILE":  "/etc/auth/signing.pem",
+		},
+
+		expectedConfig: withDefaults(func(config *Config) {
+			config.SigningAlgorithm = "ES256"
+			config.PrivateKeyFile = "/etc/auth/signing.pem"
+		}),
+	},
+	{
+		name: "Rejects asymmetric signing without key file",
+
+		env: map[string]string{
+			"AUTH_SIGNING_ALGORITHM": "RS256",
+		},
+
+		expectedErr: "RS256 needs a private key file",
+	},
+	{
+		name: "Rejects unknown signing algorithm",
+
+		env: map[string]string{
+			"AUTH_SIGNING_ALGORITHM": "none",
+		},
+
+		expectedErr: `signing algorithm must be HS256, RS256, ES256 or EdDSA, got "none"`,
+	},
+	{
+		name: "Key directory replaces secrets",
+
+		env: map[string]string{
+			"AUTH_KEY_DIR":             "/etc/auth/keys",
+			"AUTH_KEY_RELOAD_INTERVAL": "1m",
+		},
+
+		expectedConfig: withDefaults(func(config *Config) {
+			config.KeyDir = "/etc/auth/keys"
+			config.KeyReloadInterval = Duration(time.Minute)
+		}),
+	},
+	{
+		name: "Rejects key directory with private key file",
+
+		env: map[string]string{
+			"AUTH_SIGNING_ALGORITHM": "EdDSA",
+			"AUTH_KEY_DIR":           "/etc/auth/keys",
+			"AUTH_PRIVATE_KEY_FILE":  "/etc/auth/signing.pem",
+		},
+
+		expectedErr: "key directory and private key file can't both be set",
+	},
+	{
+		name: "Rejects negative key reload interval",
+
+		env: map[string]string{
+			"AUTH_KEY_DIR":             "/etc/auth/keys",
+			"AUTH_KEY_RELOAD_INTERVAL": "-1m",
+		},
+
+		expectedErr: "key reload interval can't be negative",
+	},
+	{
+		name: "Reads issuer and audience",
+
+		fileContents: `{
+			"access_secret": "access secret of at least 32 bytes",
+			"refresh_secret": "refresh secret of at least 32 bytes",
+			"issuer": "https://auth.example.com",
+			"audience": ["api"]
+		}`,
+		env: map[string]string{
+			"AUTH_AUDIENCE": "api, admin,",
+		},
+
+		expectedConfig: withDefaults(func(config *Config) {
+			config.AccessSecret = "access secret of at least 32 bytes"
+			config.RefreshSecret = "refresh secret of at least 32 bytes"
+			config.Issuer = "https://auth.example.com"
+			config.Audience = []string{"api", "admin"}
+		}),
+	},
+	{
+		name: "Rejects malformed duration",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":     "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":    "refresh secret of at least 32 bytes",
+			"AUTH_REFRESH_TOKEN_TTL": "forever",
+		},
+
+		expectedErr: "AUTH_REFRESH_TOKEN_TTL must be a duration",
+	},
+	{
+		name: "Rejects non-positive TTL",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":    "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":   "refresh secret of at least 32 bytes",
+			"AUTH_ACCESS_TOKEN_TTL": "0s",
+		},
+
+		expectedErr: "access token TTL must be positive",
+	},
+	{
+		name: "Reads password reset settings",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":   "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":  "refresh secret of at least 32 bytes",
+			"AUTH_RESET_TOKEN_TTL": "1h",
+			"AUTH_NOTIFY_FILE":     "/var/spool/auth/notify.jsonl",
+		},
+
+		expectedConfig: withDefaults(func(config *Config) {
+			config.AccessSecret = "access secret of at least 32 bytes"
+			config.RefreshSecret = "refresh secret of at least 32 bytes"
+			config.ResetTokenTTL = Duration(time.Hour)
+			config.NotifyFile = "/var/spool/auth/notify.jsonl"
+		}),
+	},
+	{
+		name: "Rejects non-positive reset token TTL",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":   "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":  "refresh secret of at least 32 bytes",
+			"AUTH_RESET_TOKEN_TTL": "-1m",
+		},
+
+		expectedErr: "reset token TTL must be positive",
+	},
+	{
+		name: "Reads email verification settings",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":          "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":         "refresh secret of at least 32 bytes",
+			"AUTH_REQUIRE_VERIFIED_EMAIL": "true",
+			"AUTH_EMAIL_VERIFICATION_TTL": "48h",
+			"AUTH_MAIL_FROM":              "Auth <auth@example.com>",
+			"AUTH_SMTP_ADDR":              "smtp.example.com:587",
+			"AUTH_SMTP_USERNAME":          "auth",
+			"AUTH_SMTP_PASSWORD":          "smtppass",
+			"AUTH_VERIFY_EMAIL_URL":       "https://example.com/verify",
+		},
+
+		expectedConfig: withDefaults(func(config *Config) {
+			config.AccessSecret = "access secret of at least 32 bytes"
+			config.RefreshSecret = "refresh secret of at least 32 bytes"
+			config.RequireVerifiedEmail = true
+			config.EmailVerificationTTL = Duration(48 * time.Hour)
+			config.MailFrom = "Auth <auth@example.com>"
+			config.SMTPAddr = "smtp.example.com:587"
+			config.SMTPUsername = "auth"
+			config.SMTPPassword = "smtppass"
+			config.VerifyEmailURL = "https://example.com/verify"
+		}),
+	},
+	{
+		name: "Rejects non-boolean require verified email",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":          "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":         "refresh secret of at least 32 bytes",
+			"AUTH_REQUIRE_VERIFIED_EMAIL": "sometimes",
+		},
+
+		expectedErr: `AUTH_REQUIRE_VERIFIED_EMAIL must be true or false, got "sometimes"`,
+	},
+	{
+		name: "Rejects SMTP address and mail drop directory together",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":  "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET": "refresh secret of at least 32 bytes",
+			"AUTH_MAIL_FROM":      "auth@example.com",
+			"AUTH_SMTP_ADDR":      "smtp.example.com:587",
+			"AUTH_MAIL_DROP_DIR":  "/var/spool/auth/mail",
+		},
+
+		expectedErr: "SMTP address and mail drop directory can't both be set",
+	},
+	{
+		name: "Rejects requiring verified emails with nowhere to send tokens",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":          "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":         "refresh secret of at least 32 bytes",
+			"AUTH_REQUIRE_VERIFIED_EMAIL": "true",
+		},
+
+		expectedErr: "requiring verified emails needs an SMTP address, mail " +
+			"drop directory or notify file to send tokens to",
+	},
+	{
+		name: "Rejects mail server without from address",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":  "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET": "refresh secret of at least 32 bytes",
+			"AUTH_SMTP_ADDR":      "smtp.example.com:587",
+		},
+
+		expectedErr: "mail from address is required to send mail",
+	},
+	{
+		name: "Rejects SMTP address without port",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":  "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET": "refresh secret of at least 32 bytes",
+			"AUTH_MAIL_FROM":      "auth@example.com",
+			"AUTH_SMTP_ADDR":      "smtp.example.com",
+		},
+
+		expectedErr: `SMTP address must be host:port, got "smtp.example.com"`,
+	},
+	{
+		name: "Rejects relative verify email URL",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":    "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":   "refresh secret of at least 32 bytes",
+			"AUTH_VERIFY_EMAIL_URL": "/verify",
+		},
+
+		expectedErr: `verify email URL must be absolute, got "/verify"`,
+	},
+	{
+		name: "Rejects out of range bcrypt cost",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":  "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET": "refresh secret of at least 32 bytes",
+			"AUTH_BCRYPT_COST":    "40",
+		},
+
+		expectedErr: "bcrypt cost must be between 4 and 31",
+	},
+	{
+		name: "Reads bcrypt calibration settings",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":         "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":        "refresh secret of at least 32 bytes",
+			"AUTH_BCRYPT_MIN_COST":       "11",
+			"AUTH_BCRYPT_TARGET_LATENCY": "250ms",
+		},
+
+		expectedConfig: withDefaults(func(config *Config) {
+			config.AccessSecret = "access secret of at least 32 bytes"
+			config.RefreshSecret = "refresh secret of at least 32 bytes"
+			config.BcryptMinCost = 11
+			config.BcryptTargetLatency = Duration(250 * time.Millisecond)
+		}),
+	},
+	{
+		name: "Rejects bcrypt min cost above cost",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":   "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":  "refresh secret of at least 32 bytes",
+			"AUTH_BCRYPT_COST":     "10",
+			"AUTH_BCRYPT_MIN_COST": "11",
+		},
+
+		expectedErr: "bcrypt min cost can't be above bcrypt cost",
+	},
+	{
+		name: "Rejects negative bcrypt target latency",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":         "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":        "refresh secret of at least 32 bytes",
+			"AUTH_BCRYPT_TARGET_LATENCY": "-1s",
+		},
+
+		expectedErr: "bcrypt target latency can't be negative",
+	},
+	{
+		name: "Reads argon2id settings",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":      "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":     "refresh secret of at least 32 bytes",
+			"AUTH_PASSWORD_HASH":      "argon2id",
+			"AUTH_ARGON2_MEMORY_KIB":  "19456",
+			"AUTH_ARGON2_ITERATIONS":  "2",
+			"AUTH_ARGON2_PARALLELISM": "1",
+		},
+
+		expectedConfig: withDefaults(func(config *Config) {
+			config.AccessSecret = "access secret of at least 32 bytes"
+			config.RefreshSecret = "refresh secret of at least 32 bytes"
+			config.PasswordHash = "argon2id"
+			config.Argon2MemoryKiB = 19456
+			config.Argon2Iterations = 2
+			config.Argon2Parallelism = 1
+		}),
+	},
+	{
+		name: "Rejects unknown password hash",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":  "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET": "refresh secret of at least 32 bytes",
+			"AUTH_PASSWORD_HASH":  "md5",
+		},
+
+		expectedErr: `password hash must be bcrypt or argon2id, got "md5"`,
+	},
+	{
+		name: "Rejects argon2id memory that couldn't be matched later",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":     "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":    "refresh secret of at least 32 bytes",
+			"AUTH_PASSWORD_HASH":     "argon2id",
+			"AUTH_ARGON2_MEMORY_KIB": "4194304",
+		},
+
+		expectedErr: "argon2id needs at most 64 iterations",
+	},
+	{
+		name: "Rejects out of range argon2id parallelism",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":      "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":     "refresh secret of at least 32 bytes",
+			"AUTH_PASSWORD_HASH":      "argon2id",
+			"AUTH_ARGON2_PARALLELISM": "256",
+		},
+
+		expectedErr: "parallelism at most 255",
+	},
+	{
+		name: "Reads password policy settings",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":          "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":         "refresh secret of at least 32 bytes",
+			"AUTH_PASSWORD_MIN_LENGTH":    "12",
+			"AUTH_PASSWORD_MAX_LENGTH":    "64",
+			"AUTH_PASSWORD_MAX_BYTES":     "72",
+			"AUTH_PASSWORD_REQUIRE":       "upper, digit",
+			"AUTH_PASSWORD_DENYLIST_FILE": "/etc/auth/denylist.txt",
+			"AUTH_BREACH_INDEX_FILE":      "/etc/auth/breach.idx",
+		},
+
+		expectedConfig: withDefaults(func(config *Config) {
+			config.AccessSecret = "access secret of at least 32 bytes"
+			config.RefreshSecret = "refresh secret of at least 32 bytes"
+			config.PasswordMinLength = 12
+			config.PasswordMaxLength = 64
+			config.PasswordMaxBytes = 72
+			config.PasswordRequire = []string{"upper", "digit"}
+			config.PasswordDenylistFile = "/etc/auth/denylist.txt"
+			config.BreachIndexFile = "/etc/auth/breach.idx"
+		}),
+	},
+	{
+		name: "Rejects zero password min length",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":       "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":      "refresh secret of at least 32 bytes",
+			"AUTH_PASSWORD_MIN_LENGTH": "0",
+		},
+
+		expectedErr: "password min length must be positive",
+	},
+	{
+		name: "Rejects password max length below min length",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":       "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":      "refresh secret of at least 32 bytes",
+			"AUTH_PASSWORD_MAX_LENGTH": "6",
+		},
+
+		expectedErr: "password max length can't be below min length",
+	},
+	{
+		name: "Rejects password max bytes that bcrypt would truncate",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":      "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":     "refresh secret of at least 32 bytes",
+			"AUTH_PASSWORD_MAX_BYTES": "100",
+		},
+
+		expectedErr: "password max bytes can't be above 72 with bcrypt",
+	},
+	{
+		name: "Rejects unknown password character class",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":    "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":   "refresh secret of at least 32 bytes",
+			"AUTH_PASSWORD_REQUIRE": "digit,emoji",
+		},
+
+		expectedErr: `password require must list lower, upper, digit or symbol, got "emoji"`,
+	},
+	{
+		name: "Reads lockout settings",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":        "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":       "refresh secret of at least 32 bytes",
+			"AUTH_LOCKOUT_THRESHOLD":    "10",
+			"AUTH_LOCKOUT_DURATION":     "30s",
+			"AUTH_LOCKOUT_MAX_DURATION": "15m",
+			"AUTH_LOCKOUT_RESET_AFTER":  "1h",
+		},
+
+		expectedConfig: withDefaults(func(config *Config) {
+			config.AccessSecret = "access secret of at least 32 bytes"
+			config.RefreshSecret = "refresh secret of at least 32 bytes"
+			config.LockoutThreshold = 10
+			config.LockoutDuration = Duration(30 * time.Second)
+			config.LockoutMaxDuration = Duration(15 * time.Minute)
+			config.LockoutResetAfter = Duration(time.Hour)
+		}),
+	},
+	{
+		name: "Rejects negative lockout threshold",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":     "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":    "refresh secret of at least 32 bytes",
+			"AUTH_LOCKOUT_THRESHOLD": "-1",
+		},
+
+		expectedErr: "lockout threshold can't be negative",
+	},
+	{
+		name: "Rejects lockout max duration below lockout duration",
+
+		env: map[string]string{
+			"AUTH_ACCESS_SECRET":        "access secret of at least 32 bytes",
+			"AUTH_REFRESH_SECRET":       "refresh secret of at least 32 bytes",
+			"AUTH_LOCKOUT_MAX_DURATION": "30s",
+		},
+
+		expectedErr: "lockout max duration can't be below lockout duration",
+	},
+	{
+		name: "Rejects malformed config file",
+
+		fileContents: "{not json",
+
+		expectedErr: "parsing config file",
+	},
+}
+
+func TestLoadConfig(t *testing.T) {
+	for _, tc := range configTests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := ""
+			if tc.fileContents != "" {
+				path = writeConfigFile(t, tc.fileContents)
+			}
+
+			config, err := LoadConfig(path, mockEnv(tc.env))
+
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("Expected err containing: '%s'; Got: '%v'",
+						tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error; Got: '%v'", err)
+			}
+			if diff := cmp.Diff(tc.expectedConfig, config); diff != "" {
+				t.Fatalf("Expected config to match: \n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_ReadsPathFromEnvironment(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"access_secret": "access secret of at least 32 bytes",
+		"refresh_secret": "refresh secret of at least 32 bytes"
+	}`)
+
+	config, err := LoadConfig("", mockEnv(map[string]string{
+		"AUTH_CONFIG_FILE": path,
+	}))
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	if config.AccessSecret != "access secret of at least 32 bytes" {
+		t.Fatalf("Expected the access secret from the file; Got: '%s'", config.AccessSecret)
+	}
+}
+
+func TestConfig_LoadsKeyDirectory(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "2021-06-01"), []byte("only secret of at least 32 bytes"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig()
+	config.KeyDir = dir
+
+	jwtConfig, err := config.jwtConfig(new(jwtgen.StdTimeGetter))
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	if keys := jwtConfig.Keyring.AccessKeys().VerificationKeys(); len(keys) != 1 {
+		t.Fatalf("Expected 1 key; Got: %d", len(keys))
+	}
+}
+
+func TestConfig_CalibratesBcryptCost(t *testing.T) {
+	config := DefaultConfig()
+	config.BcryptMinCost = bcrypt.MinCost + 1
+	// Too short for any cost, so calibration settles on the minimum.
+	config.BcryptTargetLatency = Duration(time.Nanosecond)
+
+	hasher, err := config.passwordHasher()
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	hash, err := hasher.HashPassword("pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cost, _ := bcrypt.Cost([]byte(hash))
+	if cost != bcrypt.MinCost+1 {
+		t.Fatalf("Expected cost: %d; Got: %d", bcrypt.MinCost+1, cost)
+	}
+}
+
+func TestConfig_CalibratedCostOverridesBcryptCost(t *testing.T) {
+	config := DefaultConfig()
+	config.AccessSecret = "access secret of at least 32 bytes"
+	config.RefreshSecret = "refresh secret of at least 32 bytes"
+	config.BcryptCost = bcrypt.MaxCost + 1
+	config.BcryptTargetLatency = Duration(time.Nanosecond)
+
+	err := config.Validate()
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	hasher, err := config.passwordHasher()
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.DefaultCost-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasher.NeedsRehash(string(hash)) {
+		t.Fatalf("Expected hashes below the calibrated cost to need rehashing")
+	}
+}
+
+func TestConfig_BuildsPasswordPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	err := ioutil.WriteFile(path, []byte("password123\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig()
+	config.PasswordRequire = []string{"digit"}
+	config.PasswordDenylistFile = path
+
+	policy, err := config.passwordPolicy()
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	expectedPolicy := security.PasswordPolicy{
+		MinLength:       8,
+		MaxBytes:        security.BcryptMaxPasswordBytes,
+		RequiredClasses: []security.CharClass{security.Digit},
+		Denylist:        security.Denylist{"password123": true},
+		RejectUsername:  true,
+	}
+	if diff := cmp.Diff(expectedPolicy, policy); diff != "" {
+		t.Fatalf("Expected policy to match: \n%s", diff)
+	}
+}
+
+func TestConfig_ReportsMissingPasswordDenylist(t *testing.T) {
+	config := DefaultConfig()
+	config.PasswordDenylistFile = filepath.Join(t.TempDir(), "missing.txt")
+
+	_, err := config.passwordPolicy()
+	if err == nil || !strings.Contains(err.Error(), "loading password denylist") {
+		t.Fatalf("Expected denylist error; Got: '%v'", err)
+	}
+}
+
+func TestConfig_LogsTokensWithoutMailServer(t *testing.T) {
+	notifyLog := notify.NewLog(ioutil.Discard)
+	config := DefaultConfig()
+
+	if notifier := config.verificationNotifier(notifyLog); notifier != notifyLog {
+		t.Fatalf("Expected the notify log; Got: %#v", notifier)
+	}
+	if notifier := config.resetNotifier(notifyLog); notifier != notifyLog {
+		t.Fatalf("Expected the notify log; Got: %#v", notifier)
+	}
+	if notifier := config.resetNotifier(nil); notifier != nil {
+		t.Fatalf("Expected no reset notifier; Got: %#v", notifier)
+	}
+
+	config.MailFrom = "auth@example.com"
+	config.MailDropDir = "/var/spool/auth/mail"
+	expected := notify.Mailer{
+		Sender: notify.FileDropSender{Dir: "/var/spool/auth/mail"},
+		From:   "auth@example.com",
+	}
+	if notifier := config.verificationNotifier(notifyLog); notifier != expected {
+		t.Fatalf("Expected mailer: %#v; Got: %#v", expected, notifier)
+	}
+	if notifier := config.resetNotifier(nil); notifier != expected {
+		t.Fatalf("Expected mailer: %#v; Got: %#v", expected, notifier)
+	}
+}
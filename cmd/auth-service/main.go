@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/steve-kaufman/go-auth-service/implementations/db"
+	"github.com/steve-kaufman/go-auth-service/implementations/notify"
+	"github.com/steve-kaufman/go-auth-service/implementations/security"
+	"github.com/steve-kaufman/go-auth-service/implementations/security/jwtgen"
+	"github.com/steve-kaufman/go-auth-service/implementations/ui"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+	"github.com/steve-kaufman/go-auth-service/usecases"
+)
+
+const shutdownTimeout = 10 * time.Second
+const pruneInterval = time.Hour
+const notifyQueueSize = 100
+
+func main() {
+	configPath := flag.String("config", "", "path to a JSON config file")
+	flag.Parse()
+
+	config, err := LoadConfig(*configPath, os.LookupEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = run(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(config Config) error {
+	timeGetter := new(jwtgen.StdTimeGetter)
+	jwtConfig, err := config.jwtConfig(timeGetter)
+	if err != nil {
+		return err
+	}
+
+	store, err := db.NewSQLite(config.DatabasePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	err = checkUsersHaveEmails(config, store)
+	if err != nil {
+		return err
+	}
+
+	policy, err := config.passwordPolicy()
+	if err != nil {
+		return err
+	}
+	hasher, err := config.passwordHasher()
+	if err != nil {
+		return err
+	}
+	var notifyLog *notify.Log
+	if config.NotifyFile != "" {
+		notifyOutput, err := openNotifyOutput(config.NotifyFile)
+		if err != nil {
+			return err
+		}
+		defer notifyOutput.Close()
+		notifyLog = notify.NewLog(notifyOutput)
+	}
+	notifyQueue := notify.NewQueue(notifyQueueSize, log.Default())
+	defer drainNotifyQueue(notifyQueue)
+	var breachChecker interfaces.BreachChecker
+	if config.BreachIndexFile != "" {
+		breachIndex, err := security.OpenBreachIndex(config.BreachIndexFile)
+		if err != nil {
+			return fmt.Errorf("opening breach index: %w", err)
+		}
+		defer breachIndex.Close()
+		breachChecker = breachIndex
+	}
+	verifier := jwtgen.NewVerifier(jwtConfig, timeGetter)
+	service := usecases.NewService(usecases.ServiceDependencies{
+		UserGetter:    store,
+		UserCreator:   store,
+		UserUpdater:   store,
+		PassPolicy:    policy,
+		BreachChecker: breachChecker,
+		PassHasher:    hasher,
+		PassMatcher:   new(security.MultiMatcher),
+		PassRehasher:  hasher,
+		PassUpdater:   store,
+		PassChanger:   store,
+		TokenGenerator: jwtgen.NewGenerator(
+			jwtConfig, timeGetter, new(jwtgen.RandomIDGetter),
+		),
+		TokenVerifier:     verifier,
+		RefreshTokenStore: store,
+		OneTimeTokens:     new(security.OneTimeTokens),
+		ResetTokenStore:   store,
+		ResetNotifier:     notifyQueue.ResetNotifier(config.resetNotifier(notifyLog)),
+		TimeGetter:        timeGetter,
+		MaxTokenLifetime:  config.maxTokenLifetime(),
+		ResetTokenTTL:     time.Duration(config.ResetTokenTTL),
+
+		RequireVerifiedEmail: config.RequireVerifiedEmail,
+		EmailVerifier:        store,
+		VerificationStore:    store,
+		VerificationNotifier: notifyQueue.VerificationNotifier(
+			config.verificationNotifier(notifyLog),
+		),
+		VerificationTokenTTL: time.Duration(config.EmailVerificationTTL),
+
+		LoginFailures: store,
+		Lockout:       config.lockoutPolicy(),
+	})
+
+	server := new(ui.HTTP)
+	server.UseService(service)
+	server.UseKeyProvider(verifier)
+
+	ctx, stop := signal.NotifyContext(
+		context.Background(), syscall.SIGTERM, os.Interrupt,
+	)
+	defer stop()
+
+	go pruneExpiredRows(ctx, store, timeGetter, time.Duration(config.LockoutResetAfter))
+	if jwtConfig.Keyring != nil {
+		go reloadKeys(ctx, jwtConfig.Keyring, time.Duration(config.KeyReloadInterval))
+	}
+
+	return serve(ctx, &http.Server{
+		Addr:    config.ListenAddr,
+		Handler: server,
+	})
+}
+
+// checkUsersHaveEmails refuses to require verified emails while some users
+// have no email, since they could never log in again.
+func checkUsersHaveEmails(config Config, store *db.SQLite) error {
+	if !config.RequireVerifiedEmail {
+		return nil
+	}
+	count, err := store.CountUsersWithoutEmail()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf(
+			"require_verified_email is set, but %d users have no email to verify", count,
+		)
+	}
+	return nil
+}
+
+// openNotifyOutput opens path for appending.
+func openNotifyOutput(path string) (io.WriteCloser, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening notify file: %w", err)
+	}
+	return file, nil
+}
+
+// drainNotifyQueue gives queued notifications shutdownTimeout to be
+// delivered.
+func drainNotifyQueue(queue *notify.Queue) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := queue.Close(ctx)
+	if err != nil {
+		log.Printf("delivering queued notifications: %v", err)
+	}
+}
+
+// pruneExpiredRows keeps the spent refresh token, revoked family, reset
+// token, email verification token and login failure tables from growing
+// forever.
+func pruneExpiredRows(
+	ctx context.Context, store *db.SQLite, timeGetter jwtgen.TimeGetter,
+	lockoutResetAfter time.Duration,
+) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := timeGetter.GetTime()
+		err := store.DeleteExpiredRefreshTokens(now)
+		if err != nil {
+			log.Printf("pruning refresh tokens: %v", err)
+		}
+		err = store.DeleteExpiredRevokedFamilies(now)
+		if err != nil {
+			log.Printf("pruning revoked token families: %v", err)
+		}
+		err = store.DeleteExpiredResetTokens(now)
+		if err != nil {
+			log.Printf("pruning reset tokens: %v", err)
+		}
+		err = store.DeleteExpiredEmailVerificationTokens(now)
+		if err != nil {
+			log.Printf("pruning email verification tokens: %v", err)
+		}
+		err = store.DeleteStaleLoginFailures(now-lockoutResetAfter.Seconds(), now)
+		if err != nil {
+			log.Printf("pruning login failures: %v", err)
+		}
+	}
+}
+
+// reloadKeys rereads the key directory on SIGHUP, and every interval if it
+// isn't zero. A failed reload keeps the keys that were already loaded.
+func reloadKeys(ctx context.Context, keyring *jwtgen.Keyring, interval time.Duration) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	var ticks <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+		case <-ticks:
+		}
+		err := keyring.Reload()
+		if err != nil {
+			log.Printf("reloading signing keys: %v", err)
+		}
+	}
+}
+
+// serve blocks until the server fails or ctx is cancelled, in which case
+// in-flight requests get shutdownTimeout to finish.
+func serve(ctx context.Context, httpServer *http.Server) error {
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %s", httpServer.Addr)
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Print("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return httpServer.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,36 @@
+// Command breach-index converts a Pwned Passwords corpus into the index that
+// auth-service reads from AUTH_BREACH_INDEX_FILE:
+//
+//	breach-index -hash sha1 -o breach.idx pwnedpasswords.txt
+//
+// The corpus is either the single downloaded file or a directory of range
+// files, and is read in one pass, so the full corpus needs no extra memory.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/steve-kaufman/go-auth-service/implementations/security"
+)
+
+func main() {
+	hash := flag.String("hash", "sha1", "hash the corpus lists passwords by: sha1 or ntlm")
+	out := flag.String("o", "breach.idx", "path to write the index to")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] corpus\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := security.BuildBreachIndex(flag.Arg(0), security.BreachHash(*hash), *out)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,14 @@
+package entities
+
+// PublicKey is a token verification key in JSON Web Key form. The key
+// parameters are base64url encoded, and only the ones for KeyType are set.
+type PublicKey struct {
+	ID        string
+	Algorithm string
+	KeyType   string
+	Curve     string
+	N         string
+	E         string
+	X         string
+	Y         string
+}
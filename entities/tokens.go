@@ -4,3 +4,59 @@ type LoginTokens struct {
 	AccessToken  string
 	RefreshToken string
 }
+
+// TokenSubject is who a pair of tokens is issued to. Tokens issued from a
+// refresh token stay in that token's Family; an empty Family starts a new
+// one.
+type TokenSubject struct {
+	UserID   int
+	Username string
+	Family   string
+	Version  int
+}
+
+// TokenClaims are the verified contents of a token. Times are seconds since
+// the Unix epoch. Custom holds any app specific claims, decoded from JSON.
+type TokenClaims struct {
+	ID        string
+	Family    string
+	Issuer    string
+	Audience  []string
+	UserID    int
+	Username  string
+	Version   int
+	Type      string
+	IssuedAt  float64
+	NotBefore float64
+	ExpiresAt float64
+	Custom    map[string]interface{}
+}
+
+// RefreshToken is what's remembered about a refresh token once it has been
+// exchanged, so that presenting it again can be detected.
+type RefreshToken struct {
+	ID        string
+	Family    string
+	UserID    int
+	ExpiresAt float64
+}
+
+// ResetToken is what's stored about a password reset token. Only a hash of
+// the token is kept, so that a leaked database can't be used to reset
+// passwords.
+type ResetToken struct {
+	Hash      string
+	UserID    int
+	Username  string
+	ExpiresAt float64
+}
+
+// EmailVerificationToken is what's stored about an email verification
+// token. It's tied to the address it was sent to, so that it can't verify a
+// different address the user switches to later.
+type EmailVerificationToken struct {
+	Hash      string
+	UserID    int
+	Email     string
+	ExpiresAt float64
+}
@@ -4,4 +4,21 @@ type User struct {
 	ID       int
 	Username string
 	Password string
+	// TokenVersion is embedded in issued tokens. Bumping it invalidates
+	// every token issued before.
+	TokenVersion int
+	// Email is optional, normalized to lowercase, and unique among users
+	// that have one.
+	Email         string
+	EmailVerified bool
+}
+
+// LoginFailures counts failed logins with an identifier, whether or not any
+// user has it, since the last successful one. Times are in seconds since the
+// epoch.
+type LoginFailures struct {
+	Identifier    string
+	Count         int
+	LastFailureAt float64
+	LockedUntil   float64
 }
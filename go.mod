@@ -3,8 +3,9 @@ module github.com/steve-kaufman/go-auth-service
 go 1.16
 
 require (
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible // indirect
-	github.com/gomagedon/expectate v1.1.0 // indirect
-	github.com/google/go-cmp v0.5.5 // indirect
-	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b // indirect
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/gomagedon/expectate v1.1.0
+	github.com/google/go-cmp v0.5.5
+	github.com/mattn/go-sqlite3 v1.14.7
+	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b
 )
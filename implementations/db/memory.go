@@ -0,0 +1,351 @@
+package db
+
+import (
+	"sync"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/usecases"
+)
+
+// Memory is a non-persistent, concurrency-safe store. It's meant for tests
+// and local development; everything is lost when the process exits.
+type Memory struct {
+	mutex  sync.Mutex
+	users  map[string]entities.User
+	nextID int
+
+	usedRefreshTokens    map[string]entities.RefreshToken
+	revokedTokenFamilies map[string]float64
+	resetTokens          map[string]entities.ResetToken
+	verificationTokens   map[string]entities.EmailVerificationToken
+	loginFailures        map[string]entities.LoginFailures
+}
+
+func NewMemory() *Memory {
+	store := new(Memory)
+	store.users = make(map[string]entities.User)
+	store.nextID = 1
+	store.usedRefreshTokens = make(map[string]entities.RefreshToken)
+	store.revokedTokenFamilies = make(map[string]float64)
+	store.resetTokens = make(map[string]entities.ResetToken)
+	store.verificationTokens = make(map[string]entities.EmailVerificationToken)
+	store.loginFailures = make(map[string]entities.LoginFailures)
+	return store
+}
+
+func (store *Memory) GetUserByUsername(username string) (entities.User, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	user, ok := store.users[username]
+	if !ok {
+		return entities.User{}, usecases.ErrNotFound
+	}
+	return user, nil
+}
+
+func (store *Memory) GetUserByEmail(email string) (entities.User, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, user := range store.users {
+		if user.Email != "" && user.Email == email {
+			return user, nil
+		}
+	}
+	return entities.User{}, usecases.ErrNotFound
+}
+
+func (store *Memory) CreateUser(user entities.User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, exists := store.users[user.Username]; exists {
+		return usecases.ErrDuplicate
+	}
+	for _, existing := range store.users {
+		if user.Email != "" && existing.Email == user.Email {
+			return usecases.ErrDuplicateEmail
+		}
+	}
+	user.ID = store.nextID
+	store.nextID++
+	store.users[user.Username] = user
+	return nil
+}
+
+func (store *Memory) UpdatePassword(userID int, oldHash string, newHash string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for username, user := range store.users {
+		if user.ID == userID && user.Password == oldHash {
+			user.Password = newHash
+			store.users[username] = user
+			return nil
+		}
+	}
+	return usecases.ErrNotFound
+}
+
+func (store *Memory) ChangePassword(userID int, oldHash string, newHash string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for username, user := range store.users {
+		if user.ID == userID && user.Password == oldHash {
+			user.Password = newHash
+			user.TokenVersion++
+			store.users[username] = user
+			return nil
+		}
+	}
+	return usecases.ErrNotFound
+}
+
+func (store *Memory) MarkEmailVerified(userID int, email string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for username, user := range store.users {
+		if user.ID == userID && user.Email == email {
+			user.EmailVerified = true
+			store.users[username] = user
+			return nil
+		}
+	}
+	return usecases.ErrNotFound
+}
+
+func (store *Memory) IncrementTokenVersion(userID int) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for username, user := range store.users {
+		if user.ID == userID {
+			user.TokenVersion++
+			store.users[username] = user
+			return nil
+		}
+	}
+	return usecases.ErrNotFound
+}
+
+func (store *Memory) UseRefreshToken(token entities.RefreshToken) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, used := store.usedRefreshTokens[token.ID]; used {
+		return usecases.ErrTokenReused
+	}
+	store.usedRefreshTokens[token.ID] = token
+	return nil
+}
+
+func (store *Memory) RevokeTokenFamily(family string, expiresAt float64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	previous, revoked := store.revokedTokenFamilies[family]
+	if !revoked || expiresAt > previous {
+		store.revokedTokenFamilies[family] = expiresAt
+	}
+	return nil
+}
+
+func (store *Memory) IsTokenFamilyRevoked(family string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	_, revoked := store.revokedTokenFamilies[family]
+	return revoked, nil
+}
+
+// DeleteExpiredRevokedFamilies forgets revoked families that expired at or
+// before now, when every token in them has expired too.
+func (store *Memory) DeleteExpiredRevokedFamilies(now float64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for family, expiresAt := range store.revokedTokenFamilies {
+		if expiresAt <= now {
+			delete(store.revokedTokenFamilies, family)
+		}
+	}
+	return nil
+}
+
+// DeleteExpiredRefreshTokens forgets spent tokens that expired at or before
+// now. They no longer need tracking since they'd fail verification anyway.
+func (store *Memory) DeleteExpiredRefreshTokens(now float64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for id, token := range store.usedRefreshTokens {
+		if token.ExpiresAt <= now {
+			delete(store.usedRefreshTokens, id)
+		}
+	}
+	return nil
+}
+
+func (store *Memory) SaveResetToken(token entities.ResetToken) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for hash, existing := range store.resetTokens {
+		if existing.UserID == token.UserID {
+			delete(store.resetTokens, hash)
+		}
+	}
+	store.resetTokens[token.Hash] = token
+	return nil
+}
+
+func (store *Memory) GetResetToken(hash string) (entities.ResetToken, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	token, ok := store.resetTokens[hash]
+	if !ok {
+		return entities.ResetToken{}, usecases.ErrNotFound
+	}
+	return token, nil
+}
+
+func (store *Memory) UseResetToken(hash string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.resetTokens[hash]; !ok {
+		return usecases.ErrNotFound
+	}
+	delete(store.resetTokens, hash)
+	return nil
+}
+
+// DeleteExpiredResetTokens forgets reset tokens that expired unused at or
+// before now.
+func (store *Memory) DeleteExpiredResetTokens(now float64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for hash, token := range store.resetTokens {
+		if token.ExpiresAt <= now {
+			delete(store.resetTokens, hash)
+		}
+	}
+	return nil
+}
+
+func (store *Memory) SaveEmailVerificationToken(token entities.EmailVerificationToken) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for hash, existing := range store.verificationTokens {
+		if existing.UserID == token.UserID {
+			delete(store.verificationTokens, hash)
+		}
+	}
+	store.verificationTokens[token.Hash] = token
+	return nil
+}
+
+func (store *Memory) GetEmailVerificationToken(
+	hash string,
+) (entities.EmailVerificationToken, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	token, ok := store.verificationTokens[hash]
+	if !ok {
+		return entities.EmailVerificationToken{}, usecases.ErrNotFound
+	}
+	return token, nil
+}
+
+func (store *Memory) UseEmailVerificationToken(hash string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.verificationTokens[hash]; !ok {
+		return usecases.ErrNotFound
+	}
+	delete(store.verificationTokens, hash)
+	return nil
+}
+
+// DeleteExpiredEmailVerificationTokens forgets verification tokens that
+// expired unused at or before now.
+func (store *Memory) DeleteExpiredEmailVerificationTokens(now float64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for hash, token := range store.verificationTokens {
+		if token.ExpiresAt <= now {
+			delete(store.verificationTokens, hash)
+		}
+	}
+	return nil
+}
+
+func (store *Memory) GetLoginFailures(identifier string) (entities.LoginFailures, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.loginFailures[identifier], nil
+}
+
+func (store *Memory) AddLoginFailure(
+	identifier string, at float64, forgetBefore float64,
+) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	failures := store.loginFailures[identifier]
+	failures.Identifier = identifier
+	if failures.LastFailureAt < forgetBefore {
+		failures.Count = 0
+	}
+	failures.Count++
+	failures.LastFailureAt = at
+	store.loginFailures[identifier] = failures
+	return failures.Count, nil
+}
+
+func (store *Memory) LockAccount(identifier string, until float64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	failures, ok := store.loginFailures[identifier]
+	if !ok {
+		return nil
+	}
+	if until > failures.LockedUntil {
+		failures.LockedUntil = until
+	}
+	store.loginFailures[identifier] = failures
+	return nil
+}
+
+func (store *Memory) ClearLoginFailures(identifier string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.loginFailures, identifier)
+	return nil
+}
+
+// DeleteStaleLoginFailures forgets failures that were last added before
+// forgetBefore and aren't holding a lock at now.
+func (store *Memory) DeleteStaleLoginFailures(forgetBefore float64, now float64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for identifier, failures := range store.loginFailures {
+		if failures.LastFailureAt < forgetBefore && failures.LockedUntil <= now {
+			delete(store.loginFailures, identifier)
+		}
+	}
+	return nil
+}
@@ -1 +1,481 @@
 package db
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/usecases"
+)
+
+// migrations are applied in order on startup. The index of the last applied
+// migration is tracked with SQLite's user_version pragma, so new schema
+// changes must only ever be appended.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id       INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT    NOT NULL UNIQUE,
+		password TEXT    NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS used_refresh_tokens (
+		id         TEXT    PRIMARY KEY,
+		family     TEXT    NOT NULL,
+		user_id    INTEGER NOT NULL,
+		expires_at REAL    NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS revoked_token_families (
+		family TEXT PRIMARY KEY
+	)`,
+	`ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS reset_tokens (
+		hash       TEXT    PRIMARY KEY,
+		user_id    INTEGER NOT NULL UNIQUE,
+		username   TEXT    NOT NULL,
+		expires_at REAL    NOT NULL
+	)`,
+	`ALTER TABLE users ADD COLUMN email TEXT`,
+	`ALTER TABLE users ADD COLUMN email_verified INTEGER NOT NULL DEFAULT 0`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS users_email ON users (email)`,
+	`CREATE TABLE IF NOT EXISTS email_verification_tokens (
+		hash       TEXT    PRIMARY KEY,
+		user_id    INTEGER NOT NULL UNIQUE,
+		email      TEXT    NOT NULL,
+		expires_at REAL    NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS login_failures (
+		identifier      TEXT    PRIMARY KEY,
+		count           INTEGER NOT NULL,
+		last_failure_at REAL    NOT NULL,
+		locked_until    REAL    NOT NULL DEFAULT 0
+	)`,
+	// Families revoked before this have no expiry, and are never pruned.
+	`ALTER TABLE revoked_token_families ADD COLUMN expires_at REAL`,
+}
+
+type SQLite struct {
+	db *sql.DB
+}
+
+func NewSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_foreign_keys=on")
+	if err != nil {
+		return nil, err
+	}
+	store := new(SQLite)
+	store.db = db
+	err = store.migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store SQLite) Close() error {
+	return store.db.Close()
+}
+
+func (store SQLite) migrate() error {
+	var version int
+	err := store.db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	for ; version < len(migrations); version++ {
+		err = store.applyMigration(version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store SQLite) applyMigration(version int) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(migrations[version])
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// PRAGMA statements can't take bound parameters.
+	_, err = tx.Exec("PRAGMA user_version = " + strconv.Itoa(version+1))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store SQLite) GetUserByUsername(username string) (entities.User, error) {
+	row := store.db.QueryRow(
+		`SELECT id, username, password, token_version, email, email_verified
+		FROM users WHERE username = ?`,
+		username,
+	)
+	return scanUser(row)
+}
+
+func (store SQLite) GetUserByEmail(email string) (entities.User, error) {
+	row := store.db.QueryRow(
+		`SELECT id, username, password, token_version, email, email_verified
+		FROM users WHERE email = ?`,
+		email,
+	)
+	return scanUser(row)
+}
+
+// CountUsersWithoutEmail counts users who signed up without an email.
+func (store SQLite) CountUsersWithoutEmail() (int, error) {
+	var count int
+	err := store.db.QueryRow(
+		"SELECT COUNT(*) FROM users WHERE email IS NULL",
+	).Scan(&count)
+	return count, err
+}
+
+func scanUser(row *sql.Row) (entities.User, error) {
+	var user entities.User
+	var email sql.NullString
+	err := row.Scan(
+		&user.ID, &user.Username, &user.Password, &user.TokenVersion,
+		&email, &user.EmailVerified,
+	)
+	if err == sql.ErrNoRows {
+		return entities.User{}, usecases.ErrNotFound
+	}
+	if err != nil {
+		return entities.User{}, err
+	}
+	user.Email = email.String
+	return user, nil
+}
+
+// CreateUser stores a missing email as NULL, which the unique index on email
+// doesn't count as a duplicate. Which one was taken is looked up in the same
+// transaction, since SQLite's error only names it in the message.
+func (store SQLite) CreateUser(user entities.User) error {
+	email := sql.NullString{String: user.Email, Valid: user.Email != ""}
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO users (username, password, email, email_verified)
+		VALUES (?, ?, ?, ?)`,
+		user.Username, user.Password, email, user.EmailVerified,
+	)
+	if isUniqueViolation(err) {
+		err = duplicateUserError(tx, user.Username)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// duplicateUserError reports a taken username ahead of a taken email, as
+// Memory does.
+func duplicateUserError(tx *sql.Tx, username string) error {
+	var exists bool
+	err := tx.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM users WHERE username = ?)", username,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return usecases.ErrDuplicate
+	}
+	return usecases.ErrDuplicateEmail
+}
+
+func (store SQLite) UpdatePassword(userID int, oldHash string, newHash string) error {
+	result, err := store.db.Exec(
+		"UPDATE users SET password = ? WHERE id = ? AND password = ?",
+		newHash, userID, oldHash,
+	)
+	if err != nil {
+		return err
+	}
+	return checkRowWasAffected(result)
+}
+
+func (store SQLite) ChangePassword(userID int, oldHash string, newHash string) error {
+	result, err := store.db.Exec(
+		`UPDATE users SET password = ?, token_version = token_version + 1
+		WHERE id = ? AND password = ?`,
+		newHash, userID, oldHash,
+	)
+	if err != nil {
+		return err
+	}
+	return checkRowWasAffected(result)
+}
+
+func (store SQLite) MarkEmailVerified(userID int, email string) error {
+	result, err := store.db.Exec(
+		"UPDATE users SET email_verified = 1 WHERE id = ? AND email = ?",
+		userID, email,
+	)
+	if err != nil {
+		return err
+	}
+	return checkRowWasAffected(result)
+}
+
+func (store SQLite) IncrementTokenVersion(userID int) error {
+	result, err := store.db.Exec(
+		"UPDATE users SET token_version = token_version + 1 WHERE id = ?",
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	return checkRowWasAffected(result)
+}
+
+func (store SQLite) UseRefreshToken(token entities.RefreshToken) error {
+	_, err := store.db.Exec(
+		`INSERT INTO used_refresh_tokens (id, family, user_id, expires_at)
+		VALUES (?, ?, ?, ?)`,
+		token.ID, token.Family, token.UserID, token.ExpiresAt,
+	)
+	if isUniqueViolation(err) {
+		return usecases.ErrTokenReused
+	}
+	return err
+}
+
+func (store SQLite) RevokeTokenFamily(family string, expiresAt float64) error {
+	_, err := store.db.Exec(
+		`INSERT INTO revoked_token_families (family, expires_at) VALUES (?, ?)
+		ON CONFLICT (family) DO UPDATE
+		SET expires_at = max(expires_at, excluded.expires_at)`,
+		family, expiresAt,
+	)
+	return err
+}
+
+func (store SQLite) IsTokenFamilyRevoked(family string) (bool, error) {
+	var revoked bool
+	err := store.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM revoked_token_families WHERE family = ?)",
+		family,
+	).Scan(&revoked)
+	return revoked, err
+}
+
+// DeleteExpiredRefreshTokens forgets spent tokens that expired at or before
+// now. They no longer need tracking since they'd fail verification anyway.
+func (store SQLite) DeleteExpiredRefreshTokens(now float64) error {
+	_, err := store.db.Exec(
+		"DELETE FROM used_refresh_tokens WHERE expires_at <= ?", now,
+	)
+	return err
+}
+
+// DeleteExpiredRevokedFamilies forgets revoked families that expired at or
+// before now, when every token in them has expired too.
+func (store SQLite) DeleteExpiredRevokedFamilies(now float64) error {
+	_, err := store.db.Exec(
+		"DELETE FROM revoked_token_families WHERE expires_at <= ?", now,
+	)
+	return err
+}
+
+// SaveResetToken relies on the unique user_id to replace the user's
+// previous token.
+func (store SQLite) SaveResetToken(token entities.ResetToken) error {
+	_, err := store.db.Exec(
+		`INSERT OR REPLACE INTO reset_tokens (hash, user_id, username, expires_at)
+		VALUES (?, ?, ?, ?)`,
+		token.Hash, token.UserID, token.Username, token.ExpiresAt,
+	)
+	return err
+}
+
+func (store SQLite) GetResetToken(hash string) (entities.ResetToken, error) {
+	row := store.db.QueryRow(
+		`SELECT hash, user_id, username, expires_at
+		FROM reset_tokens WHERE hash = ?`,
+		hash,
+	)
+	var token entities.ResetToken
+	err := row.Scan(&token.Hash, &token.UserID, &token.Username, &token.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return entities.ResetToken{}, usecases.ErrNotFound
+	}
+	if err != nil {
+		return entities.ResetToken{}, err
+	}
+	return token, nil
+}
+
+func (store SQLite) UseResetToken(hash string) error {
+	result, err := store.db.Exec("DELETE FROM reset_tokens WHERE hash = ?", hash)
+	if err != nil {
+		return err
+	}
+	return checkRowWasAffected(result)
+}
+
+// DeleteExpiredResetTokens forgets reset tokens that expired unused at or
+// before now.
+func (store SQLite) DeleteExpiredResetTokens(now float64) error {
+	_, err := store.db.Exec("DELETE FROM reset_tokens WHERE expires_at <= ?", now)
+	return err
+}
+
+// SaveEmailVerificationToken relies on the unique user_id to replace the
+// user's previous token.
+func (store SQLite) SaveEmailVerificationToken(token entities.EmailVerificationToken) error {
+	_, err := store.db.Exec(
+		`INSERT OR REPLACE INTO email_verification_tokens
+		(hash, user_id, email, expires_at) VALUES (?, ?, ?, ?)`,
+		token.Hash, token.UserID, token.Email, token.ExpiresAt,
+	)
+	return err
+}
+
+func (store SQLite) GetEmailVerificationToken(
+	hash string,
+) (entities.EmailVerificationToken, error) {
+	row := store.db.QueryRow(
+		`SELECT hash, user_id, email, expires_at
+		FROM email_verification_tokens WHERE hash = ?`,
+		hash,
+	)
+	var token entities.EmailVerificationToken
+	err := row.Scan(&token.Hash, &token.UserID, &token.Email, &token.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return entities.EmailVerificationToken{}, usecases.ErrNotFound
+	}
+	if err != nil {
+		return entities.EmailVerificationToken{}, err
+	}
+	return token, nil
+}
+
+func (store SQLite) UseEmailVerificationToken(hash string) error {
+	result, err := store.db.Exec(
+		"DELETE FROM email_verification_tokens WHERE hash = ?", hash,
+	)
+	if err != nil {
+		return err
+	}
+	return checkRowWasAffected(result)
+}
+
+// DeleteExpiredEmailVerificationTokens forgets verification tokens that
+// expired unused at or before now.
+func (store SQLite) DeleteExpiredEmailVerificationTokens(now float64) error {
+	_, err := store.db.Exec(
+		"DELETE FROM email_verification_tokens WHERE expires_at <= ?", now,
+	)
+	return err
+}
+
+func (store SQLite) GetLoginFailures(identifier string) (entities.LoginFailures, error) {
+	row := store.db.QueryRow(
+		`SELECT identifier, count, last_failure_at, locked_until
+		FROM login_failures WHERE identifier = ?`,
+		identifier,
+	)
+	var failures entities.LoginFailures
+	err := row.Scan(
+		&failures.Identifier, &failures.Count, &failures.LastFailureAt, &failures.LockedUntil,
+	)
+	if err == sql.ErrNoRows {
+		return entities.LoginFailures{}, nil
+	}
+	if err != nil {
+		return entities.LoginFailures{}, err
+	}
+	return failures, nil
+}
+
+// AddLoginFailure writes before it reads, so that the transaction holds the
+// write lock throughout and concurrent failures are each counted.
+func (store SQLite) AddLoginFailure(
+	identifier string, at float64, forgetBefore float64,
+) (int, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO login_failures (identifier, count, last_failure_at)
+		VALUES (?, 1, ?)
+		ON CONFLICT (identifier) DO UPDATE SET
+			count = CASE WHEN last_failure_at < ? THEN 1 ELSE count + 1 END,
+			last_failure_at = excluded.last_failure_at`,
+		identifier, at, forgetBefore,
+	)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	var count int
+	err = tx.QueryRow(
+		"SELECT count FROM login_failures WHERE identifier = ?", identifier,
+	).Scan(&count)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+func (store SQLite) LockAccount(identifier string, until float64) error {
+	_, err := store.db.Exec(
+		`UPDATE login_failures SET locked_until = MAX(locked_until, ?)
+		WHERE identifier = ?`,
+		until, identifier,
+	)
+	return err
+}
+
+func (store SQLite) ClearLoginFailures(identifier string) error {
+	_, err := store.db.Exec(
+		"DELETE FROM login_failures WHERE identifier = ?", identifier,
+	)
+	return err
+}
+
+// DeleteStaleLoginFailures forgets failures that were last added before
+// forgetBefore and aren't holding a lock at now. Unknown identifiers are
+// counted too, so without this the table would only grow.
+func (store SQLite) DeleteStaleLoginFailures(forgetBefore float64, now float64) error {
+	_, err := store.db.Exec(
+		`DELETE FROM login_failures
+		WHERE last_failure_at < ? AND locked_until <= ?`,
+		forgetBefore, now,
+	)
+	return err
+}
+
+func checkRowWasAffected(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return usecases.ErrNotFound
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+		sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+}
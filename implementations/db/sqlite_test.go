@@ -1 +1,524 @@
 package db_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/implementations/db"
+	"github.com/steve-kaufman/go-auth-service/usecases"
+)
+
+func setupSQLite(t *testing.T) (*db.SQLite, string) {
+	path := filepath.Join(t.TempDir(), "auth.db")
+	store, err := db.NewSQLite(path)
+	if err != nil {
+		t.Fatalf("Expected no error opening database; Got: '%v'", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, path
+}
+
+func TestSQLite_GetUserByUsername_ReturnsErrNotFound(t *testing.T) {
+	store, _ := setupSQLite(t)
+
+	_, err := store.GetUserByUsername("johndoe")
+	if err != usecases.ErrNotFound {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrNotFound, err)
+	}
+}
+
+func TestSQLite_CreateUser_ThenGetUserByUsername(t *testing.T) {
+	store, _ := setupSQLite(t)
+
+	err := store.CreateUser(entities.User{
+		Username: "johndoe",
+		Password: "hashedpass",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	user, err := store.GetUserByUsername("johndoe")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	expectedUser := entities.User{
+		ID:       1,
+		Username: "johndoe",
+		Password: "hashedpass",
+	}
+	if diff := cmp.Diff(expectedUser, user); diff != "" {
+		t.Fatalf("Expected user to match: \n%s", diff)
+	}
+}
+
+func TestSQLite_CreateUser_ReturnsErrDuplicate(t *testing.T) {
+	store, _ := setupSQLite(t)
+
+	err := store.CreateUser(entities.User{Username: "johndoe", Password: "foo"})
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	err = store.CreateUser(entities.User{Username: "johndoe", Password: "bar"})
+	if err != usecases.ErrDuplicate {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrDuplicate, err)
+	}
+}
+
+func TestSQLite_PersistsAcrossReopen(t *testing.T) {
+	store, path := setupSQLite(t)
+
+	err := store.CreateUser(entities.User{Username: "johndoe", Password: "foo"})
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	store.Close()
+
+	reopened, err := db.NewSQLite(path)
+	if err != nil {
+		t.Fatalf("Expected no error reopening database; Got: '%v'", err)
+	}
+	defer reopened.Close()
+
+	user, err := reopened.GetUserByUsername("johndoe")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	if user.Password != "foo" {
+		t.Fatalf("Expected password: 'foo'; Got: '%s'", user.Password)
+	}
+}
+
+func TestSQLite_UseRefreshToken_ReturnsErrTokenReused(t *testing.T) {
+	store, _ := setupSQLite(t)
+	token := entities.RefreshToken{
+		ID:        "token-1",
+		Family:    "family-1",
+		UserID:    1,
+		ExpiresAt: 100,
+	}
+
+	err := store.UseRefreshToken(token)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	err = store.UseRefreshToken(token)
+	if err != usecases.ErrTokenReused {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrTokenReused, err)
+	}
+}
+
+func TestSQLite_RevokeTokenFamily(t *testing.T) {
+	store, _ := setupSQLite(t)
+
+	revoked, err := store.IsTokenFamilyRevoked("family-1")
+	if err != nil || revoked {
+		t.Fatalf("Expected family not to be revoked; Got: %v, '%v'", revoked, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = store.RevokeTokenFamily("family-1", 100)
+		if err != nil {
+			t.Fatalf("Expected no error; Got: '%v'", err)
+		}
+	}
+
+	revoked, err = store.IsTokenFamilyRevoked("family-1")
+	if err != nil || !revoked {
+		t.Fatalf("Expected family to be revoked; Got: %v, '%v'", revoked, err)
+	}
+}
+
+func TestSQLite_DeleteExpiredRevokedFamilies(t *testing.T) {
+	store, _ := setupSQLite(t)
+	store.RevokeTokenFamily("expired", 50)
+	store.RevokeTokenFamily("live", 50)
+	// Revoking again keeps the later expiry.
+	store.RevokeTokenFamily("live", 150)
+	store.RevokeTokenFamily("live", 60)
+
+	err := store.DeleteExpiredRevokedFamilies(100)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	if revoked, _ := store.IsTokenFamilyRevoked("expired"); revoked {
+		t.Fatal("Expected expired family to be forgotten")
+	}
+	if revoked, _ := store.IsTokenFamilyRevoked("live"); !revoked {
+		t.Fatal("Expected live family to stay revoked")
+	}
+}
+
+func TestSQLite_DeleteExpiredRefreshTokens(t *testing.T) {
+	store, _ := setupSQLite(t)
+	expired := entities.RefreshToken{ID: "expired", Family: "f", ExpiresAt: 50}
+	live := entities.RefreshToken{ID: "live", Family: "f", ExpiresAt: 150}
+	store.UseRefreshToken(expired)
+	store.UseRefreshToken(live)
+
+	err := store.DeleteExpiredRefreshTokens(100)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	if err := store.UseRefreshToken(expired); err != nil {
+		t.Fatalf("Expected expired token to be forgotten; Got: '%v'", err)
+	}
+	if err := store.UseRefreshToken(live); err != usecases.ErrTokenReused {
+		t.Fatalf("Expected live token to be remembered; Got: '%v'", err)
+	}
+}
+
+func TestSQLite_IncrementTokenVersion(t *testing.T) {
+	store, _ := setupSQLite(t)
+	store.CreateUser(entities.User{Username: "johndoe", Password: "foo"})
+
+	for i := 0; i < 2; i++ {
+		err := store.IncrementTokenVersion(1)
+		if err != nil {
+			t.Fatalf("Expected no error; Got: '%v'", err)
+		}
+	}
+
+	user, _ := store.GetUserByUsername("johndoe")
+	if user.TokenVersion != 2 {
+		t.Fatalf("Expected token version: 2; Got: %d", user.TokenVersion)
+	}
+}
+
+func TestSQLite_IncrementTokenVersion_ReturnsErrNotFound(t *testing.T) {
+	store, _ := setupSQLite(t)
+
+	err := store.IncrementTokenVersion(1)
+	if err != usecases.ErrNotFound {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrNotFound, err)
+	}
+}
+
+func TestSQLite_UpdatePassword(t *testing.T) {
+	store, _ := setupSQLite(t)
+	store.CreateUser(entities.User{Username: "johndoe", Password: "oldhash"})
+
+	err := store.UpdatePassword(1, "oldhash", "newhash")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	user, _ := store.GetUserByUsername("johndoe")
+	if user.Password != "newhash" {
+		t.Fatalf("Expected password: 'newhash'; Got: '%s'", user.Password)
+	}
+}
+
+func TestSQLite_UpdatePassword_ReturnsErrNotFound(t *testing.T) {
+	store, _ := setupSQLite(t)
+	store.CreateUser(entities.User{Username: "johndoe", Password: "currenthash"})
+
+	// A stale old hash means the password changed since it was read.
+	err := store.UpdatePassword(1, "stalehash", "newhash")
+	if err != usecases.ErrNotFound {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrNotFound, err)
+	}
+	err = store.UpdatePassword(2, "currenthash", "newhash")
+	if err != usecases.ErrNotFound {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrNotFound, err)
+	}
+
+	user, _ := store.GetUserByUsername("johndoe")
+	if user.Password != "currenthash" {
+		t.Fatalf("Expected password: 'currenthash'; Got: '%s'", user.Password)
+	}
+}
+
+func TestSQLite_ChangePassword_BumpsTokenVersion(t *testing.T) {
+	store, _ := setupSQLite(t)
+	store.CreateUser(entities.User{Username: "johndoe", Password: "oldhash"})
+
+	err := store.ChangePassword(1, "oldhash", "newhash")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	user, _ := store.GetUserByUsername("johndoe")
+	if user.Password != "newhash" || user.TokenVersion != 1 {
+		t.Fatalf("Expected password 'newhash' at version 1; Got: '%s' at %d",
+			user.Password, user.TokenVersion)
+	}
+
+	err = store.ChangePassword(1, "oldhash", "otherhash")
+	if err != usecases.ErrNotFound {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrNotFound, err)
+	}
+	user, _ = store.GetUserByUsername("johndoe")
+	if user.Password != "newhash" || user.TokenVersion != 1 {
+		t.Fatalf("Expected stale change to do nothing; Got: '%s' at %d",
+			user.Password, user.TokenVersion)
+	}
+}
+
+func TestSQLite_SaveResetToken_ReplacesUsersOldToken(t *testing.T) {
+	store, _ := setupSQLite(t)
+	old := entities.ResetToken{Hash: "old", UserID: 1, Username: "johndoe", ExpiresAt: 150}
+	latest := entities.ResetToken{Hash: "new", UserID: 1, Username: "johndoe", ExpiresAt: 200}
+	store.SaveResetToken(old)
+
+	err := store.SaveResetToken(latest)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	if _, err := store.GetResetToken("old"); err != usecases.ErrNotFound {
+		t.Fatalf("Expected old token to be replaced; Got: '%v'", err)
+	}
+	token, err := store.GetResetToken("new")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	if diff := cmp.Diff(latest, token); diff != "" {
+		t.Fatalf("Expected token to match: \n%s", diff)
+	}
+}
+
+func TestSQLite_UseResetToken_OnlyOnce(t *testing.T) {
+	store, _ := setupSQLite(t)
+	store.SaveResetToken(entities.ResetToken{Hash: "h", UserID: 1, Username: "johndoe"})
+
+	if err := store.UseResetToken("h"); err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	if err := store.UseResetToken("h"); err != usecases.ErrNotFound {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrNotFound, err)
+	}
+	if _, err := store.GetResetToken("h"); err != usecases.ErrNotFound {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrNotFound, err)
+	}
+}
+
+func TestSQLite_DeleteExpiredResetTokens(t *testing.T) {
+	store, _ := setupSQLite(t)
+	store.SaveResetToken(entities.ResetToken{Hash: "expired", UserID: 1, ExpiresAt: 50})
+	store.SaveResetToken(entities.ResetToken{Hash: "live", UserID: 2, ExpiresAt: 150})
+
+	err := store.DeleteExpiredResetTokens(100)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	if _, err := store.GetResetToken("expired"); err != usecases.ErrNotFound {
+		t.Fatalf("Expected expired token to be deleted; Got: '%v'", err)
+	}
+	if _, err := store.GetResetToken("live"); err != nil {
+		t.Fatalf("Expected live token to be kept; Got: '%v'", err)
+	}
+}
+
+func TestSQLite_CountUsersWithoutEmail(t *testing.T) {
+	store, _ := setupSQLite(t)
+	store.CreateUser(entities.User{Username: "noemail", Password: "foo"})
+	store.CreateUser(entities.User{
+		Username: "johndoe", Password: "foo", Email: "john@example.com",
+	})
+
+	count, err := store.CountUsersWithoutEmail()
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	if count != 1 {
+		t.Fatalf("Expected 1 user without email; Got: %d", count)
+	}
+}
+
+func TestSQLite_CreateUser_StoresEmail(t *testing.T) {
+	store, _ := setupSQLite(t)
+
+	err := store.CreateUser(entities.User{
+		Username: "johndoe", Password: "hashedpass", Email: "john@example.com",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	user, err := store.GetUserByUsername("johndoe")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	expectedUser := entities.User{
+		ID:       1,
+		Username: "johndoe",
+		Password: "hashedpass",
+		Email:    "john@example.com",
+	}
+	if diff := cmp.Diff(expectedUser, user); diff != "" {
+		t.Fatalf("Expected user to match: \n%s", diff)
+	}
+}
+
+func TestSQLite_CreateUser_ReturnsErrDuplicateEmail(t *testing.T) {
+	store, _ := setupSQLite(t)
+	store.CreateUser(entities.User{Username: "johndoe", Password: "foo", Email: "j@example.com"})
+
+	err := store.CreateUser(entities.User{Username: "janedoe", Password: "bar", Email: "j@example.com"})
+	if err != usecases.ErrDuplicateEmail {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrDuplicateEmail, err)
+	}
+}
+
+func TestSQLite_CreateUser_AllowsManyUsersWithoutEmail(t *testing.T) {
+	store, _ := setupSQLite(t)
+
+	for _, username := range []string{"johndoe", "janedoe"} {
+		err := store.CreateUser(entities.User{Username: username, Password: "foo"})
+		if err != nil {
+			t.Fatalf("Expected no error; Got: '%v'", err)
+		}
+	}
+}
+
+func TestSQLite_MarkEmailVerified(t *testing.T) {
+	store, _ := setupSQLite(t)
+	store.CreateUser(entities.User{Username: "johndoe", Password: "foo", Email: "j@example.com"})
+
+	err := store.MarkEmailVerified(1, "old@example.com")
+	if err != usecases.ErrNotFound {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrNotFound, err)
+	}
+	err = store.MarkEmailVerified(1, "j@example.com")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	user, _ := store.GetUserByUsername("johndoe")
+	if !user.EmailVerified {
+		t.Fatal("Expected email to be verified")
+	}
+}
+
+func TestSQLite_EmailVerificationTokens(t *testing.T) {
+	store, _ := setupSQLite(t)
+	old := entities.EmailVerificationToken{Hash: "old", UserID: 1, Email: "j@example.com", ExpiresAt: 50}
+	latest := entities.EmailVerificationToken{Hash: "new", UserID: 1, Email: "j@example.com", ExpiresAt: 150}
+	store.SaveEmailVerificationToken(old)
+	store.SaveEmailVerificationToken(latest)
+
+	if _, err := store.GetEmailVerificationToken("old"); err != usecases.ErrNotFound {
+		t.Fatalf("Expected old token to be replaced; Got: '%v'", err)
+	}
+	token, err := store.GetEmailVerificationToken("new")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	if diff := cmp.Diff(latest, token); diff != "" {
+		t.Fatalf("Expected token to match: \n%s", diff)
+	}
+
+	if err := store.UseEmailVerificationToken("new"); err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	if err := store.UseEmailVerificationToken("new"); err != usecases.ErrNotFound {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrNotFound, err)
+	}
+}
+
+func TestSQLite_DeleteExpiredEmailVerificationTokens(t *testing.T) {
+	store, _ := setupSQLite(t)
+	store.SaveEmailVerificationToken(entities.EmailVerificationToken{Hash: "expired", UserID: 1, ExpiresAt: 50})
+	store.SaveEmailVerificationToken(entities.EmailVerificationToken{Hash: "live", UserID: 2, ExpiresAt: 150})
+
+	err := store.DeleteExpiredEmailVerificationTokens(100)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	if _, err := store.GetEmailVerificationToken("expired"); err != usecases.ErrNotFound {
+		t.Fatalf("Expected expired token to be deleted; Got: '%v'", err)
+	}
+	if _, err := store.GetEmailVerificationToken("live"); err != nil {
+		t.Fatalf("Expected live token to be kept; Got: '%v'", err)
+	}
+}
+
+func TestSQLite_GetUserByEmail(t *testing.T) {
+	store, _ := setupSQLite(t)
+	store.CreateUser(entities.User{Username: "johndoe", Password: "foo", Email: "j@example.com"})
+
+	user, err := store.GetUserByEmail("j@example.com")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	if user.Username != "johndoe" {
+		t.Fatalf("Expected user: 'johndoe'; Got: '%s'", user.Username)
+	}
+
+	_, err = store.GetUserByEmail("other@example.com")
+	if err != usecases.ErrNotFound {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrNotFound, err)
+	}
+}
+
+func TestSQLite_LoginFailures(t *testing.T) {
+	store, _ := setupSQLite(t)
+
+	failures, err := store.GetLoginFailures("user1")
+	if err != nil || failures != (entities.LoginFailures{}) {
+		t.Fatalf("Expected no failures; Got: %+v, '%v'", failures, err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		count, err := store.AddLoginFailure("user1", float64(100+i), 0)
+		if err != nil || count != i {
+			t.Fatalf("Expected count %d; Got: %d, '%v'", i, count, err)
+		}
+	}
+	store.LockAccount("user1", 300)
+	store.LockAccount("user1", 200)
+
+	failures, _ = store.GetLoginFailures("user1")
+	expected := entities.LoginFailures{
+		Identifier: "user1", Count: 2, LastFailureAt: 102, LockedUntil: 300,
+	}
+	if failures != expected {
+		t.Fatalf("Expected failures: %+v; Got: %+v", expected, failures)
+	}
+
+	count, _ := store.AddLoginFailure("user1", 500, 200)
+	if count != 1 {
+		t.Fatalf("Expected old failures to be forgotten; Got count: %d", count)
+	}
+
+	err = store.ClearLoginFailures("user1")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	failures, _ = store.GetLoginFailures("user1")
+	if failures != (entities.LoginFailures{}) {
+		t.Fatalf("Expected failures to be cleared; Got: %+v", failures)
+	}
+}
+
+func TestSQLite_DeleteStaleLoginFailures(t *testing.T) {
+	store, _ := setupSQLite(t)
+	store.AddLoginFailure("old", 100, 0)
+	store.AddLoginFailure("locked", 100, 0)
+	store.LockAccount("locked", 400)
+	store.AddLoginFailure("recent", 250, 0)
+
+	err := store.DeleteStaleLoginFailures(200, 300)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	for identifier, expectedCount := range map[string]int{
+		"old": 0, "locked": 1, "recent": 1,
+	} {
+		failures, _ := store.GetLoginFailures(identifier)
+		if failures.Count != expectedCount {
+			t.Fatalf("Expected %d failures for '%s'; Got: %d",
+				expectedCount, identifier, failures.Count)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileDropSender writes each message to its own .eml file in Dir, for
+// local development or for a separate process to deliver. Files are written
+// under a temporary name and renamed, so that a reader never sees half a
+// message.
+type FileDropSender struct {
+	Dir string
+}
+
+func (sender FileDropSender) SendMail(message Message) error {
+	contents, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	name, err := dropFileName()
+	if err != nil {
+		return err
+	}
+
+	temp, err := ioutil.TempFile(sender.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(contents)
+	closeErr := temp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), filepath.Join(sender.Dir, name))
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
+
+// dropFileName sorts by time, with a random suffix to keep names unique.
+func dropFileName() (string, error) {
+	random := make([]byte, 4)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), hex.EncodeToString(random)), nil
+}
@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+)
+
+// Log writes each notification to a writer as a line of JSON, instead of
+// delivering it. It's for development and tests, or for handing messages to
+// another process that tails the file.
+type Log struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewLog(writer io.Writer) *Log {
+	log := new(Log)
+	log.encoder = json.NewEncoder(writer)
+	return log
+}
+
+// LogEntry is a line written by Log.
+type LogEntry struct {
+	Type     string `json:"type"`
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+	Token    string `json:"token"`
+}
+
+func (log *Log) SendPasswordReset(user entities.User, token string) error {
+	return log.write(LogEntry{
+		Type:     "password_reset",
+		UserID:   user.ID,
+		Username: user.Username,
+		Token:    token,
+	})
+}
+
+func (log *Log) SendEmailVerification(user entities.User, token string) error {
+	return log.write(LogEntry{
+		Type:     "email_verification",
+		UserID:   user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Token:    token,
+	})
+}
+
+func (log *Log) write(entry LogEntry) error {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	return log.encoder.Encode(entry)
+}
@@ -0,0 +1,61 @@
+package notify_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/implementations/notify"
+)
+
+func TestLog_SendPasswordReset_WritesJSONLine(t *testing.T) {
+	var out bytes.Buffer
+	log := notify.NewLog(&out)
+
+	err := log.SendPasswordReset(
+		entities.User{ID: 1, Username: "johndoe", Password: "hashedpass"}, "reset-token",
+	)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	var entry notify.LogEntry
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a JSON line; Got: '%s'", out.String())
+	}
+	expected := notify.LogEntry{
+		Type: "password_reset", UserID: 1, Username: "johndoe", Token: "reset-token",
+	}
+	if entry != expected {
+		t.Fatalf("Expected entry: %+v; Got: %+v", expected, entry)
+	}
+	if bytes.Contains(out.Bytes(), []byte("hashedpass")) {
+		t.Fatal("Expected password hash not to be written")
+	}
+}
+
+func TestLog_SendEmailVerification_IncludesEmail(t *testing.T) {
+	var out bytes.Buffer
+	log := notify.NewLog(&out)
+
+	err := log.SendEmailVerification(
+		entities.User{ID: 1, Username: "johndoe", Email: "john@example.com"}, "token",
+	)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	var entry notify.LogEntry
+	json.Unmarshal(out.Bytes(), &entry)
+	expected := notify.LogEntry{
+		Type:     "email_verification",
+		UserID:   1,
+		Username: "johndoe",
+		Email:    "john@example.com",
+		Token:    "token",
+	}
+	if entry != expected {
+		t.Fatalf("Expected entry: %+v; Got: %+v", expected, entry)
+	}
+}
@@ -0,0 +1,120 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+)
+
+var ErrInvalidHeader = errors.New("mail header contains a line break")
+
+// Message is a plain text email.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Date    time.Time
+	Body    string
+}
+
+// Bytes formats the message as RFC 5322 text with CRLF line endings.
+func (message Message) Bytes() ([]byte, error) {
+	headers := [][2]string{
+		{"From", message.From},
+		{"To", message.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", message.Subject)},
+		{"Date", message.Date.Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "8bit"},
+	}
+	var buffer bytes.Buffer
+	for _, header := range headers {
+		if strings.ContainsAny(header[1], "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+		fmt.Fprintf(&buffer, "%s: %s\r\n", header[0], header[1])
+	}
+	buffer.WriteString("\r\n")
+	body := strings.ReplaceAll(message.Body, "\r\n", "\n")
+	buffer.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buffer.Bytes(), nil
+}
+
+// MailSender delivers a message, e.g. over SMTP or to a directory.
+type MailSender interface {
+	SendMail(Message) error
+}
+
+// Mailer implements interfaces.ResetNotifier and EmailVerificationNotifier
+// by email. If VerifyURL is set, the email links to it with the token in a token query
+// parameter. It should be a page that posts the token to /verify-email,
+// since following a link mustn't verify by itself: mail scanners follow
+// links too.
+type Mailer struct {
+	Sender    MailSender
+	From      string
+	VerifyURL string
+}
+
+func (mailer Mailer) SendEmailVerification(user entities.User, token string) error {
+	body := fmt.Sprintf(
+		"Hi %s,\n\nUse this token to verify your email address:\n\n%s\n",
+		user.Username, token,
+	)
+	if mailer.VerifyURL != "" {
+		link, err := addQueryParam(mailer.VerifyURL, "token", token)
+		if err != nil {
+			return err
+		}
+		body += fmt.Sprintf("\nOr open this link:\n\n%s\n", link)
+	}
+	body += "\nIf you didn't sign up, you can ignore this email.\n"
+
+	return mailer.Sender.SendMail(Message{
+		From:    mailer.From,
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Date:    time.Now(),
+		Body:    body,
+	})
+}
+
+// SendPasswordReset sends to user.Email. A user without one has nowhere to
+// be sent a token, so nothing is sent, and ForgotPassword succeeds as it
+// does for an unknown username.
+func (mailer Mailer) SendPasswordReset(user entities.User, token string) error {
+	if user.Email == "" {
+		return nil
+	}
+	body := fmt.Sprintf(
+		"Hi %s,\n\nUse this token to reset your password:\n\n%s\n"+
+			"\nIf you didn't ask to reset your password, you can ignore this "+
+			"email.\n",
+		user.Username, token,
+	)
+	return mailer.Sender.SendMail(Message{
+		From:    mailer.From,
+		To:      user.Email,
+		Subject: "Reset your password",
+		Date:    time.Now(),
+		Body:    body,
+	})
+}
+
+func addQueryParam(rawURL string, key string, value string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := parsed.Query()
+	query.Set(key, value)
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
@@ -0,0 +1,234 @@
+package notify_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/implementations/notify"
+)
+
+var testMessage = notify.Message{
+	From:    "Auth <auth@example.com>",
+	To:      "john@example.com",
+	Subject: "Verify your email address",
+	Date:    time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+	Body:    "Line one\nLine two\n",
+}
+
+func TestMessage_Bytes(t *testing.T) {
+	contents, err := testMessage.Bytes()
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	expected := "From: Auth <auth@example.com>\r\n" +
+		"To: john@example.com\r\n" +
+		"Subject: Verify your email address\r\n" +
+		"Date: Tue, 01 Jun 2021 12:00:00 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		"Line one\r\nLine two\r\n"
+	if string(contents) != expected {
+		t.Fatalf("Expected message:\n%q\nGot:\n%q", expected, contents)
+	}
+}
+
+func TestMessage_Bytes_RejectsHeaderInjection(t *testing.T) {
+	message := testMessage
+	message.To = "john@example.com\r\nBcc: everyone@example.com"
+
+	_, err := message.Bytes()
+	if err != notify.ErrInvalidHeader {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", notify.ErrInvalidHeader, err)
+	}
+}
+
+type MockMailSender struct {
+	sent []notify.Message
+}
+
+func (sender *MockMailSender) SendMail(message notify.Message) error {
+	sender.sent = append(sender.sent, message)
+	return nil
+}
+
+func TestMailer_SendEmailVerification(t *testing.T) {
+	sender := new(MockMailSender)
+	mailer := notify.Mailer{
+		Sender:    sender,
+		From:      "auth@example.com",
+		VerifyURL: "https://example.com/verify?lang=en",
+	}
+
+	err := mailer.SendEmailVerification(
+		entities.User{Username: "johndoe", Email: "john@example.com"}, "a+b/c",
+	)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	if len(sender.sent) != 1 {
+		t.Fatalf("Expected 1 message; Got: %d", len(sender.sent))
+	}
+	message := sender.sent[0]
+	if message.From != "auth@example.com" || message.To != "john@example.com" {
+		t.Fatalf("Expected mail from auth to john; Got: '%s' to '%s'",
+			message.From, message.To)
+	}
+	for _, expected := range []string{
+		"\na+b/c\n", "https://example.com/verify?lang=en&token=a%2Bb%2Fc",
+	} {
+		if !strings.Contains(message.Body, expected) {
+			t.Fatalf("Expected body to contain '%s'; Got:\n%s", expected, message.Body)
+		}
+	}
+}
+
+func TestMailer_SendPasswordReset(t *testing.T) {
+	sender := new(MockMailSender)
+	mailer := notify.Mailer{Sender: sender, From: "auth@example.com"}
+
+	err := mailer.SendPasswordReset(
+		entities.User{Username: "johndoe", Email: "john@example.com"}, "a+b/c",
+	)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	err = mailer.SendPasswordReset(entities.User{Username: "janedoe"}, "d+e/f")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	if len(sender.sent) != 1 {
+		t.Fatalf("Expected 1 message; Got: %d", len(sender.sent))
+	}
+	message := sender.sent[0]
+	if message.To != "john@example.com" || !strings.Contains(message.Body, "\na+b/c\n") {
+		t.Fatalf("Expected token mailed to john; Got: %+v", message)
+	}
+}
+
+func TestFileDropSender_WritesMessageFile(t *testing.T) {
+	dir := t.TempDir()
+	sender := notify.FileDropSender{Dir: dir}
+
+	for i := 0; i < 2; i++ {
+		err := sender.SendMail(testMessage)
+		if err != nil {
+			t.Fatalf("Expected no error; Got: '%v'", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 files; Got: %v", files)
+	}
+	for _, file := range files {
+		if filepath.Ext(file) != ".eml" {
+			t.Fatalf("Expected only .eml files; Got: '%s'", file)
+		}
+	}
+	contents, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := testMessage.Bytes()
+	if string(contents) != string(expected) {
+		t.Fatalf("Expected file to hold the message; Got:\n%s", contents)
+	}
+}
+
+// serveSMTP accepts one message on a local port, just well enough for
+// net/smtp, and sends what it received on the returned channel.
+func serveSMTP(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		writer := bufio.NewWriter(conn)
+		reply := func(line string) {
+			writer.WriteString(line + "\r\n")
+			writer.Flush()
+		}
+
+		var transcript strings.Builder
+		reply("220 localhost ready")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			transcript.WriteString(line)
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"):
+				reply("250 localhost")
+			case command == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					transcript.WriteString(line)
+				}
+				reply("250 queued")
+			case command == "QUIT":
+				reply("221 bye")
+				received <- transcript.String()
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestSMTPSender_SendsMessage(t *testing.T) {
+	addr, received := serveSMTP(t)
+	sender := notify.SMTPSender{Addr: addr}
+
+	err := sender.SendMail(testMessage)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	transcript := <-received
+	for _, expected := range []string{
+		"MAIL FROM:<auth@example.com>", "RCPT TO:<john@example.com>",
+		"Subject: Verify your email address", "Line two",
+	} {
+		if !strings.Contains(transcript, expected) {
+			t.Fatalf("Expected SMTP session to contain '%s'; Got:\n%s", expected, transcript)
+		}
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(
+		transcript[strings.Index(transcript, "From: "):],
+	))
+	if err != nil || msg.Header.Get("To") != "john@example.com" {
+		t.Fatalf("Expected a readable message to john; Got: '%v'", err)
+	}
+}
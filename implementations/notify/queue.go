@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+)
+
+// Queue delivers notifications from a background goroutine, so that a
+// request that sends one gets the same answer in about the same time
+// whether delivery is quick, slow or fails. Failures are logged. When the
+// queue is full a notification is dropped and logged, rather than making
+// the request wait.
+type Queue struct {
+	logger *log.Logger
+
+	mutex  sync.Mutex
+	closed bool
+	jobs   chan queueJob
+	done   chan struct{}
+}
+
+type queueJob struct {
+	description string
+	send        func() error
+}
+
+func NewQueue(size int, logger *log.Logger) *Queue {
+	queue := new(Queue)
+	queue.logger = logger
+	queue.jobs = make(chan queueJob, size)
+	queue.done = make(chan struct{})
+	go queue.run()
+	return queue
+}
+
+// Close stops taking notifications and waits until the queued ones have
+// been delivered, or until ctx is done. Anything sent after Close is
+// dropped.
+func (queue *Queue) Close(ctx context.Context) error {
+	queue.mutex.Lock()
+	if !queue.closed {
+		queue.closed = true
+		close(queue.jobs)
+	}
+	queue.mutex.Unlock()
+
+	select {
+	case <-queue.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (queue *Queue) run() {
+	defer close(queue.done)
+	for job := range queue.jobs {
+		err := job.send()
+		if err != nil {
+			queue.logger.Printf("sending %s: %v", job.description, err)
+		}
+	}
+}
+
+func (queue *Queue) enqueue(job queueJob) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.closed {
+		queue.logger.Printf("dropping %s: notify queue is closed", job.description)
+		return
+	}
+	select {
+	case queue.jobs <- job:
+	default:
+		queue.logger.Printf("dropping %s: notify queue is full", job.description)
+	}
+}
+
+// ResetNotifier queues password resets for notifier. It's nil if notifier
+// is, so that a missing notifier still reads as one.
+func (queue *Queue) ResetNotifier(
+	notifier interfaces.ResetNotifier,
+) interfaces.ResetNotifier {
+	if notifier == nil {
+		return nil
+	}
+	return queuedResetNotifier{queue: queue, notifier: notifier}
+}
+
+type queuedResetNotifier struct {
+	queue    *Queue
+	notifier interfaces.ResetNotifier
+}
+
+func (queued queuedResetNotifier) SendPasswordReset(
+	user entities.User, token string,
+) error {
+	queued.queue.enqueue(queueJob{
+		description: fmt.Sprintf("password reset to user %d", user.ID),
+		send: func() error {
+			return queued.notifier.SendPasswordReset(user, token)
+		},
+	})
+	return nil
+}
+
+// VerificationNotifier queues email verifications for notifier, and like
+// ResetNotifier is nil if notifier is.
+func (queue *Queue) VerificationNotifier(
+	notifier interfaces.EmailVerificationNotifier,
+) interfaces.EmailVerificationNotifier {
+	if notifier == nil {
+		return nil
+	}
+	return queuedVerificationNotifier{queue: queue, notifier: notifier}
+}
+
+type queuedVerificationNotifier struct {
+	queue    *Queue
+	notifier interfaces.EmailVerificationNotifier
+}
+
+func (queued queuedVerificationNotifier) SendEmailVerification(
+	user entities.User, token string,
+) error {
+	queued.queue.enqueue(queueJob{
+		description: fmt.Sprintf("email verification to user %d", user.ID),
+		send: func() error {
+			return queued.notifier.SendEmailVerification(user, token)
+		},
+	})
+	return nil
+}
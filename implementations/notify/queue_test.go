@@ -0,0 +1,111 @@
+package notify_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/implementations/notify"
+)
+
+type FailingResetNotifier struct {
+	sent    int
+	blocker chan struct{}
+}
+
+func (notifier *FailingResetNotifier) SendPasswordReset(
+	user entities.User, token string,
+) error {
+	if notifier.blocker != nil {
+		<-notifier.blocker
+	}
+	notifier.sent++
+	return errors.New("mail server is down")
+}
+
+func TestQueue_LogsFailuresInsteadOfReturningThem(t *testing.T) {
+	var logs bytes.Buffer
+	queue := notify.NewQueue(10, log.New(&logs, "", 0))
+	notifier := new(FailingResetNotifier)
+
+	err := queue.ResetNotifier(notifier).SendPasswordReset(
+		entities.User{ID: 1, Username: "johndoe"}, "reset-token",
+	)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	err = queue.Close(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	if notifier.sent != 1 {
+		t.Fatalf("Expected 1 send; Got: %d", notifier.sent)
+	}
+	expected := "sending password reset to user 1: mail server is down"
+	if !strings.Contains(logs.String(), expected) {
+		t.Fatalf("Expected log to contain '%s'; Got: '%s'", expected, logs.String())
+	}
+	if strings.Contains(logs.String(), "reset-token") {
+		t.Fatal("Expected token not to be logged")
+	}
+}
+
+func TestQueue_VerificationNotifier_Delivers(t *testing.T) {
+	var out bytes.Buffer
+	queue := notify.NewQueue(10, log.New(new(bytes.Buffer), "", 0))
+
+	err := queue.VerificationNotifier(notify.NewLog(&out)).SendEmailVerification(
+		entities.User{ID: 1, Username: "johndoe", Email: "john@example.com"},
+		"verification-token",
+	)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	queue.Close(context.Background())
+
+	if !strings.Contains(out.String(), `"token":"verification-token"`) {
+		t.Fatalf("Expected the token to be delivered; Got: '%s'", out.String())
+	}
+}
+
+func TestQueue_DropsWhenFull(t *testing.T) {
+	var logs bytes.Buffer
+	queue := notify.NewQueue(1, log.New(&logs, "", 0))
+	notifier := &FailingResetNotifier{blocker: make(chan struct{})}
+	resets := queue.ResetNotifier(notifier)
+
+	// The first is taken by the worker, which blocks; the second fills the
+	// queue. Sending can't wait, so a third that finds it full is dropped.
+	for i := 0; i < 10; i++ {
+		err := resets.SendPasswordReset(entities.User{ID: 1}, "reset-token")
+		if err != nil {
+			t.Fatalf("Expected no error; Got: '%v'", err)
+		}
+	}
+	close(notifier.blocker)
+	queue.Close(context.Background())
+
+	if notifier.sent > 2 {
+		t.Fatalf("Expected at most 2 sends; Got: %d", notifier.sent)
+	}
+	if !strings.Contains(logs.String(), "notify queue is full") {
+		t.Fatalf("Expected a full queue to be logged; Got: '%s'", logs.String())
+	}
+}
+
+func TestQueue_IsNilWithoutNotifier(t *testing.T) {
+	queue := notify.NewQueue(1, log.New(new(bytes.Buffer), "", 0))
+	defer queue.Close(context.Background())
+
+	if queue.ResetNotifier(nil) != nil {
+		t.Fatal("Expected a nil ResetNotifier")
+	}
+	if queue.VerificationNotifier(nil) != nil {
+		t.Fatal("Expected a nil VerificationNotifier")
+	}
+}
@@ -0,0 +1,37 @@
+package notify
+
+import (
+	"net"
+	"net/mail"
+	"net/smtp"
+)
+
+// SMTPSender sends mail through an SMTP server at Addr, a host:port. The
+// connection is upgraded with STARTTLS when the server offers it, and if
+// Username is set it logs in with PLAIN auth, which net/smtp only allows
+// over TLS or to localhost.
+type SMTPSender struct {
+	Addr     string
+	Username string
+	Password string
+}
+
+func (sender SMTPSender) SendMail(message Message) error {
+	contents, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(message.From)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if sender.Username != "" {
+		host, _, err := net.SplitHostPort(sender.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", sender.Username, sender.Password, host)
+	}
+	return smtp.SendMail(sender.Addr, auth, from.Address, []string{message.To}, contents)
+}
@@ -0,0 +1,166 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var ErrMalformedHash = errors.New("malformed password hash")
+var ErrUnsupportedHashVersion = errors.New("unsupported argon2 version")
+
+// Argon2idParams are the cost settings for new hashes. Memory is in KiB.
+type Argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams follow the second recommendation in RFC 9106, with
+// parallelism lowered for small servers.
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Limits on the parameters read back from a stored hash, so that a tampered
+// hash can't make a login allocate more than 256 MiB or spin for minutes.
+const (
+	maxArgon2Memory     = 256 * 1024
+	maxArgon2Iterations = 64
+)
+
+// Argon2idHasher stores hashes in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>. Unlike bcrypt it uses the
+// whole password, however long. Zero Params fields fall back to
+// DefaultArgon2idParams.
+type Argon2idHasher struct {
+	Params Argon2idParams
+}
+
+func (hasher Argon2idHasher) HashPassword(password string) (string, error) {
+	params := hasher.params()
+	salt := make([]byte, params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(
+		[]byte(password), salt,
+		params.Iterations, params.Memory, params.Parallelism, params.KeyLength,
+	)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		encodePHCBytes(salt), encodePHCBytes(key),
+	), nil
+}
+
+// MatchPassword uses the parameters stored in hashedPass, so hashes made
+// with older settings still match. A wrong password isn't an error.
+func (Argon2idHasher) MatchPassword(plainPass string, hashedPass string) (bool, error) {
+	hash, err := parseArgon2idHash(hashedPass)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey(
+		[]byte(plainPass), hash.salt,
+		hash.params.Iterations, hash.params.Memory, hash.params.Parallelism,
+		uint32(len(hash.key)),
+	)
+	return subtle.ConstantTimeCompare(key, hash.key) == 1, nil
+}
+
+// NeedsRehash reports whether hashedPass isn't an Argon2id hash at least as
+// strong as the ones this hasher makes.
+func (hasher Argon2idHasher) NeedsRehash(hashedPass string) bool {
+	hash, err := parseArgon2idHash(hashedPass)
+	if err != nil {
+		return true
+	}
+	params := hasher.params()
+	return hash.params.Memory < params.Memory ||
+		hash.params.Iterations < params.Iterations ||
+		hash.params.Parallelism < params.Parallelism ||
+		uint32(len(hash.salt)) < params.SaltLength ||
+		uint32(len(hash.key)) < params.KeyLength
+}
+
+// Validate checks that hashes made with the hasher's params can be matched
+// again, since MatchPassword refuses params outside its limits.
+func (hasher Argon2idHasher) Validate() error {
+	params := hasher.params()
+	if !params.isSafe() || params.SaltLength < minPHCSaltLength ||
+		params.KeyLength < minPHCKeyLength {
+		return fmt.Errorf(
+			"argon2id needs at most %d iterations, between 8 KiB per lane and "+
+				"%d KiB of memory, a salt of at least %d bytes and a key of at "+
+				"least %d bytes",
+			maxArgon2Iterations, maxArgon2Memory, minPHCSaltLength, minPHCKeyLength,
+		)
+	}
+	return nil
+}
+
+func (hasher Argon2idHasher) params() Argon2idParams {
+	params := hasher.Params
+	if params.Memory == 0 {
+		params.Memory = DefaultArgon2idParams.Memory
+	}
+	if params.Iterations == 0 {
+		params.Iterations = DefaultArgon2idParams.Iterations
+	}
+	if params.Parallelism == 0 {
+		params.Parallelism = DefaultArgon2idParams.Parallelism
+	}
+	if params.SaltLength == 0 {
+		params.SaltLength = DefaultArgon2idParams.SaltLength
+	}
+	if params.KeyLength == 0 {
+		params.KeyLength = DefaultArgon2idParams.KeyLength
+	}
+	return params
+}
+
+type argon2idHash struct {
+	params Argon2idParams
+	salt   []byte
+	key    []byte
+}
+
+func parseArgon2idHash(hashedPass string) (argon2idHash, error) {
+	phc, err := parsePHCHash(hashedPass)
+	if err != nil || phc.id != "argon2id" || !phc.hasVersion {
+		return argon2idHash{}, ErrMalformedHash
+	}
+	if phc.version != argon2.Version {
+		return argon2idHash{}, ErrUnsupportedHashVersion
+	}
+	if !phc.hasParams("m", "t", "p") || phc.params["p"] > math.MaxUint8 {
+		return argon2idHash{}, ErrMalformedHash
+	}
+
+	hash := argon2idHash{salt: phc.salt, key: phc.key}
+	hash.params.Memory = uint32(phc.params["m"])
+	hash.params.Iterations = uint32(phc.params["t"])
+	hash.params.Parallelism = uint8(phc.params["p"])
+	if !hash.params.isSafe() {
+		return argon2idHash{}, ErrMalformedHash
+	}
+	return hash, nil
+}
+
+func (params Argon2idParams) isSafe() bool {
+	return params.Iterations <= maxArgon2Iterations &&
+		params.Memory >= 8*uint32(params.Parallelism) &&
+		params.Memory <= maxArgon2Memory
+}
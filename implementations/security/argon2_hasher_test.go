@@ -0,0 +1,239 @@
+package security_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/steve-kaufman/go-auth-service/implementations/security"
+)
+
+// testArgon2Params keep the tests fast. They're far too cheap for real use.
+var testArgon2Params = security.Argon2idParams{
+	Memory:      64,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var testArgon2Hasher = security.Argon2idHasher{Params: testArgon2Params}
+
+func hashWithTestParams(t *testing.T, password string) string {
+	hash, err := testArgon2Hasher.HashPassword(password)
+	if err != nil {
+		t.Fatalf("Expected no error hashing; Got: '%v'", err)
+	}
+	return hash
+}
+
+func expectMatch(t *testing.T, password string, hash string, expected bool) {
+	matches, err := testArgon2Hasher.MatchPassword(password, hash)
+	if err != nil {
+		t.Fatalf("Expected no error matching; Got: '%v'", err)
+	}
+	if matches != expected {
+		t.Fatalf("Expected match: %v; Got: %v", expected, matches)
+	}
+}
+
+func TestArgon2idHasher_RoundTrip(t *testing.T) {
+	hash := hashWithTestParams(t, "supersecret")
+
+	expectMatch(t, "supersecret", hash, true)
+	expectMatch(t, "supersecreT", hash, false)
+	expectMatch(t, "", hash, false)
+}
+
+func TestArgon2idHasher_UsesPHCFormat(t *testing.T) {
+	hash := hashWithTestParams(t, "supersecret")
+
+	format := regexp.MustCompile(
+		`^\$argon2id\$v=19\$m=64,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`,
+	)
+	if !format.MatchString(hash) {
+		t.Fatalf("Expected PHC formatted hash; Got: '%s'", hash)
+	}
+}
+
+func TestArgon2idHasher_SaltsEachHash(t *testing.T) {
+	first := hashWithTestParams(t, "supersecret")
+	second := hashWithTestParams(t, "supersecret")
+
+	if first == second {
+		t.Fatalf("Expected different hashes for the same password; Got: '%s' twice", first)
+	}
+}
+
+func TestArgon2idHasher_UsesWholePassword(t *testing.T) {
+	// bcrypt would ignore everything after the first 72 bytes.
+	prefix := strings.Repeat("a", 72)
+	hash := hashWithTestParams(t, prefix+"first")
+
+	expectMatch(t, prefix+"second", hash, false)
+	expectMatch(t, prefix+"first", hash, true)
+}
+
+func TestArgon2idHasher_MatchesHashWithOtherParams(t *testing.T) {
+	oldHasher := security.Argon2idHasher{Params: security.Argon2idParams{
+		Memory: 32, Iterations: 2, Parallelism: 2,
+	}}
+	hash, err := oldHasher.HashPassword("supersecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectMatch(t, "supersecret", hash, true)
+}
+
+func TestArgon2idHasher_UsesDefaultParams(t *testing.T) {
+	hash, err := new(security.Argon2idHasher).HashPassword("supersecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$") {
+		t.Fatalf("Expected default params; Got: '%s'", hash)
+	}
+}
+
+type TamperedHashTest struct {
+	name string
+
+	tamper      func(fields []string)
+	expectedErr error
+}
+
+var tamperedHashTests = []TamperedHashTest{
+	{
+		name: "Changed key",
+
+		tamper: func(fields []string) {
+			fields[5] = flipFirstChar(fields[5])
+		},
+	},
+	{
+		name: "Changed salt",
+
+		tamper: func(fields []string) {
+			fields[4] = flipFirstChar(fields[4])
+		},
+	},
+	{
+		name: "Changed iterations",
+
+		tamper: func(fields []string) {
+			fields[3] = "m=64,t=2,p=1"
+		},
+	},
+	{
+		name: "Other argon2 variant",
+
+		tamper:      func(fields []string) { fields[1] = "argon2i" },
+		expectedErr: security.ErrMalformedHash,
+	},
+	{
+		name: "Older argon2 version",
+
+		tamper:      func(fields []string) { fields[2] = "v=16" },
+		expectedErr: security.ErrUnsupportedHashVersion,
+	},
+	{
+		name: "Malformed version",
+
+		tamper:      func(fields []string) { fields[2] = "v=19x" },
+		expectedErr: security.ErrMalformedHash,
+	},
+	{
+		name: "Missing parameter",
+
+		tamper:      func(fields []string) { fields[3] = "m=64,t=1" },
+		expectedErr: security.ErrMalformedHash,
+	},
+	{
+		name: "Trailing parameter",
+
+		tamper:      func(fields []string) { fields[3] = "m=64,t=1,p=1,x=2" },
+		expectedErr: security.ErrMalformedHash,
+	},
+	{
+		name: "Zero parallelism",
+
+		tamper:      func(fields []string) { fields[3] = "m=64,t=1,p=0" },
+		expectedErr: security.ErrMalformedHash,
+	},
+	{
+		name: "Excessive memory",
+
+		tamper:      func(fields []string) { fields[3] = "m=4194304,t=1,p=1" },
+		expectedErr: security.ErrMalformedHash,
+	},
+	{
+		name: "Excessive iterations",
+
+		tamper:      func(fields []string) { fields[3] = "m=64,t=100000,p=1" },
+		expectedErr: security.ErrMalformedHash,
+	},
+	{
+		name: "Truncated key",
+
+		tamper:      func(fields []string) { fields[5] = fields[5][:8] },
+		expectedErr: security.ErrMalformedHash,
+	},
+	{
+		name: "Invalid base64 salt",
+
+		tamper:      func(fields []string) { fields[4] = "!!!!" + fields[4][4:] },
+		expectedErr: security.ErrMalformedHash,
+	},
+}
+
+func flipFirstChar(encoded string) string {
+	if encoded[0] == 'A' {
+		return "B" + encoded[1:]
+	}
+	return "A" + encoded[1:]
+}
+
+func TestArgon2idHasher_RejectsTamperedHashes(t *testing.T) {
+	hash := hashWithTestParams(t, "supersecret")
+
+	for _, tc := range tamperedHashTests {
+		t.Run(tc.name, func(t *testing.T) {
+			fields := strings.Split(hash, "$")
+			tc.tamper(fields)
+
+			matches, err := testArgon2Hasher.MatchPassword(
+				"supersecret", strings.Join(fields, "$"),
+			)
+			if err != tc.expectedErr {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+			if matches {
+				t.Fatal("Expected tampered hash not to match")
+			}
+		})
+	}
+}
+
+var malformedHashes = []string{
+	"",
+	"supersecret",
+	"$2a$12$R9h/cIPz0gi.URNNX3kh2OPST9/PgBkqquzi.Ss7KIUgO2t0jWMUW",
+	"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ",
+	"argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$c2FsdHNhbHRzYWx0c2FsdA",
+}
+
+func TestArgon2idHasher_RejectsMalformedHashes(t *testing.T) {
+	for _, hash := range malformedHashes {
+		t.Run(hash, func(t *testing.T) {
+			matches, err := testArgon2Hasher.MatchPassword("supersecret", hash)
+			if err != security.ErrMalformedHash {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", security.ErrMalformedHash, err)
+			}
+			if matches {
+				t.Fatal("Expected malformed hash not to match")
+			}
+		})
+	}
+}
@@ -1,15 +1,78 @@
 package security
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"time"
 
-type BcryptHasher struct{}
+	"golang.org/x/crypto/bcrypt"
+)
 
-func (BcryptHasher) HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+const DefaultBcryptCost = 12
+
+// BcryptHasher hashes with DefaultBcryptCost unless Cost is set. Stored
+// hashes below MinCost, or below the hashing cost if MinCost isn't set, need
+// rehashing.
+type BcryptHasher struct {
+	Cost    int
+	MinCost int
+}
+
+func (hasher BcryptHasher) HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), hasher.cost())
 	return string(hash), err
 }
 
+// MatchPassword only returns an error for a hash it can't read. A wrong
+// password is just a mismatch.
 func (BcryptHasher) MatchPassword(plainPass string, hashedPass string) (bool, error) {
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPass), []byte(plainPass))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
 	return err == nil, err
 }
+
+// NeedsRehash reports whether hashedPass isn't a bcrypt hash of at least
+// this hasher's minimum cost.
+func (hasher BcryptHasher) NeedsRehash(hashedPass string) bool {
+	cost, err := bcrypt.Cost([]byte(hashedPass))
+	return err != nil || cost < hasher.minCost()
+}
+
+func (hasher BcryptHasher) cost() int {
+	if hasher.Cost == 0 {
+		return DefaultBcryptCost
+	}
+	return hasher.Cost
+}
+
+func (hasher BcryptHasher) minCost() int {
+	if hasher.MinCost == 0 {
+		return hasher.cost()
+	}
+	return hasher.MinCost
+}
+
+// CalibrateBcryptCost times hashes from minCost upwards and returns the
+// highest cost, up to maxCost, that hashes within target. It never returns
+// less than minCost, however slow the machine. Each step up doubles the
+// work, so a cost is skipped once the previous one took over half the
+// target.
+func CalibrateBcryptCost(target time.Duration, minCost int, maxCost int) (int, error) {
+	cost := minCost
+	for {
+		start := time.Now()
+		_, err := bcrypt.GenerateFromPassword([]byte("calibration password"), cost)
+		if err != nil {
+			return 0, err
+		}
+		elapsed := time.Since(start)
+
+		if elapsed > target && cost > minCost {
+			return cost - 1, nil
+		}
+		if cost >= maxCost || elapsed > target/2 {
+			return cost, nil
+		}
+		cost++
+	}
+}
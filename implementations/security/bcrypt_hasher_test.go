@@ -0,0 +1,105 @@
+package security_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/steve-kaufman/go-auth-service/implementations/security"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBcryptHasher_UsesConfiguredCost(t *testing.T) {
+	hash, err := security.BcryptHasher{Cost: bcrypt.MinCost}.HashPassword("pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil || cost != bcrypt.MinCost {
+		t.Fatalf("Expected cost: %d; Got: %d, '%v'", bcrypt.MinCost, cost, err)
+	}
+}
+
+// A wrong password is a mismatch, not an error, so that a failed login
+// isn't reported as an internal error.
+func TestBcryptHasher_MatchPassword(t *testing.T) {
+	hasher := security.BcryptHasher{Cost: bcrypt.MinCost}
+	hash, err := hasher.HashPassword("pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for password, expected := range map[string]bool{"pass": true, "wrongpass": false} {
+		matches, err := hasher.MatchPassword(password, hash)
+		if err != nil {
+			t.Fatalf("Expected no error for '%s'; Got: '%v'", password, err)
+		}
+		if matches != expected {
+			t.Fatalf("Expected match for '%s': %v; Got: %v", password, expected, matches)
+		}
+	}
+}
+
+type CalibrationTest struct {
+	name string
+
+	target  time.Duration
+	minCost int
+	maxCost int
+
+	expectedCost int
+}
+
+var calibrationTests = []CalibrationTest{
+	{
+		name: "Never goes below the minimum cost",
+
+		target:  time.Nanosecond,
+		minCost: bcrypt.MinCost + 1,
+		maxCost: bcrypt.MinCost + 3,
+
+		expectedCost: bcrypt.MinCost + 1,
+	},
+	{
+		name: "Never goes above the maximum cost",
+
+		target:  time.Hour,
+		minCost: bcrypt.MinCost,
+		maxCost: bcrypt.MinCost + 2,
+
+		expectedCost: bcrypt.MinCost + 2,
+	},
+}
+
+func TestCalibrateBcryptCost(t *testing.T) {
+	for _, tc := range calibrationTests {
+		t.Run(tc.name, func(t *testing.T) {
+			cost, err := security.CalibrateBcryptCost(tc.target, tc.minCost, tc.maxCost)
+
+			if err != nil {
+				t.Fatalf("Expected no error; Got: '%v'", err)
+			}
+			if cost != tc.expectedCost {
+				t.Fatalf("Expected cost: %d; Got: %d", tc.expectedCost, cost)
+			}
+		})
+	}
+}
+
+func TestCalibrateBcryptCost_StaysUnderTarget(t *testing.T) {
+	target := 50 * time.Millisecond
+	cost, err := security.CalibrateBcryptCost(target, bcrypt.MinCost, bcrypt.MaxCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = security.BcryptHasher{Cost: cost}.HashPassword("pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Generous, since the first measurement can be skewed by a busy machine.
+	if elapsed := time.Since(start); cost > bcrypt.MinCost && elapsed > 4*target {
+		t.Fatalf("Expected cost %d to hash in about %v; Took: %v", cost, target, elapsed)
+	}
+}
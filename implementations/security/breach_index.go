@@ -0,0 +1,267 @@
+package security
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+var ErrMalformedCorpus = errors.New("malformed breach corpus")
+var ErrUnsortedCorpus = errors.New("breach corpus isn't sorted by hash")
+var ErrMalformedIndex = errors.New("malformed breach index")
+
+// BreachHash is the hash a breach corpus lists passwords by.
+type BreachHash string
+
+const (
+	SHA1 BreachHash = "sha1"
+	NTLM BreachHash = "ntlm"
+)
+
+var breachHashIDs = map[BreachHash]byte{SHA1: 1, NTLM: 2}
+
+var breachHashSizes = map[BreachHash]int{SHA1: sha1.Size, NTLM: md4.Size}
+
+// breachIndexMagic starts every index, followed by a version byte and the
+// BreachHash ID.
+const breachIndexMagic = "BRCH"
+const breachIndexVersion = 1
+const breachIndexHeaderLen = len(breachIndexMagic) + 2
+
+// BreachIndex looks passwords up in a file of sorted binary hashes, built
+// from a Pwned Passwords corpus by BuildBreachIndex. Lookups binary search
+// the file rather than loading it, so even the full corpus takes no memory
+// beyond the page cache, and a lookup is a few dozen small reads.
+type BreachIndex struct {
+	file    *os.File
+	hash    BreachHash
+	size    int
+	records int64
+}
+
+func OpenBreachIndex(path string) (*BreachIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	index, err := readBreachIndexHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return index, nil
+}
+
+func readBreachIndexHeader(file *os.File) (*BreachIndex, error) {
+	header := make([]byte, breachIndexHeaderLen)
+	_, err := io.ReadFull(file, header)
+	if err != nil || string(header[:len(breachIndexMagic)]) != breachIndexMagic ||
+		header[len(breachIndexMagic)] != breachIndexVersion {
+		return nil, ErrMalformedIndex
+	}
+	index := new(BreachIndex)
+	index.file = file
+	for hash, id := range breachHashIDs {
+		if header[len(breachIndexMagic)+1] == id {
+			index.hash = hash
+		}
+	}
+	if index.hash == "" {
+		return nil, ErrMalformedIndex
+	}
+	index.size = breachHashSizes[index.hash]
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	body := info.Size() - int64(breachIndexHeaderLen)
+	if body%int64(index.size) != 0 {
+		return nil, ErrMalformedIndex
+	}
+	index.records = body / int64(index.size)
+	return index, nil
+}
+
+func (index *BreachIndex) Close() error {
+	return index.file.Close()
+}
+
+// IsBreached reports whether password's hash is in the index.
+func (index *BreachIndex) IsBreached(password string) (bool, error) {
+	target := hashForBreachIndex(index.hash, password)
+	record := make([]byte, index.size)
+
+	var readErr error
+	found := sort.Search(int(index.records), func(i int) bool {
+		if readErr != nil {
+			return true
+		}
+		_, readErr = index.file.ReadAt(
+			record, int64(breachIndexHeaderLen)+int64(i)*int64(index.size),
+		)
+		return bytes.Compare(record, target) >= 0
+	})
+	if readErr != nil {
+		return false, readErr
+	}
+	if found == int(index.records) {
+		return false, nil
+	}
+	_, err := index.file.ReadAt(
+		record, int64(breachIndexHeaderLen)+int64(found)*int64(index.size),
+	)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(record, target), nil
+}
+
+func hashForBreachIndex(hash BreachHash, password string) []byte {
+	if hash == NTLM {
+		// NTLM hashes the password as UTF-16LE.
+		units := utf16.Encode([]rune(password))
+		encoded := make([]byte, 2*len(units))
+		for i, unit := range units {
+			binary.LittleEndian.PutUint16(encoded[2*i:], unit)
+		}
+		digest := md4.New()
+		digest.Write(encoded)
+		return digest.Sum(nil)
+	}
+	digest := sha1.Sum([]byte(password))
+	return digest[:]
+}
+
+// BuildBreachIndex converts a Pwned Passwords corpus into an index for
+// OpenBreachIndex. corpusPath is either a single file of HASH:COUNT lines, as
+// downloaded whole, or a directory of range files, each named after the
+// first five hex digits of its hashes and holding SUFFIX:COUNT lines. Either
+// way the hashes must be in order, as they're published, so that the corpus
+// can be streamed rather than sorted in memory. Hashes with a count of 0 are
+// padding and are skipped.
+func BuildBreachIndex(corpusPath string, hash BreachHash, indexPath string) error {
+	if _, ok := breachHashIDs[hash]; !ok {
+		return fmt.Errorf("unknown breach corpus hash %q", hash)
+	}
+	out, err := os.Create(indexPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(out)
+	err = writeBreachIndex(writer, corpusPath, hash)
+	if err == nil {
+		err = writer.Flush()
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(indexPath)
+	}
+	return err
+}
+
+func writeBreachIndex(writer io.Writer, corpusPath string, hash BreachHash) error {
+	_, err := writer.Write(append(
+		[]byte(breachIndexMagic), breachIndexVersion, breachHashIDs[hash],
+	))
+	if err != nil {
+		return err
+	}
+	corpus := breachCorpusWriter{writer: writer, size: breachHashSizes[hash]}
+
+	info, err := os.Stat(corpusPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return corpus.addFile(corpusPath, "")
+	}
+	entries, err := ioutil.ReadDir(corpusPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		prefix := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		err = corpus.addFile(filepath.Join(corpusPath, entry.Name()), prefix)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type breachCorpusWriter struct {
+	writer io.Writer
+	size   int
+	last   []byte
+}
+
+// addFile adds the hashes in a corpus file, each made of prefix and the
+// hex before the colon on a line.
+func (corpus *breachCorpusWriter) addFile(path string, prefix string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		err = corpus.addLine(prefix, strings.TrimSpace(scanner.Text()))
+		if err != nil {
+			return fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (corpus *breachCorpusWriter) addLine(prefix string, line string) error {
+	if line == "" {
+		return nil
+	}
+	fields := strings.SplitN(line, ":", 2)
+	if len(fields) == 2 {
+		count, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return ErrMalformedCorpus
+		}
+		if count == 0 {
+			return nil
+		}
+	}
+	record, err := hex.DecodeString(prefix + fields[0])
+	if err != nil || len(record) != corpus.size {
+		return ErrMalformedCorpus
+	}
+
+	order := bytes.Compare(record, corpus.last)
+	if corpus.last != nil && order < 0 {
+		return ErrUnsortedCorpus
+	}
+	if corpus.last != nil && order == 0 {
+		return nil
+	}
+	corpus.last = record
+	_, err = corpus.writer.Write(record)
+	return err
+}
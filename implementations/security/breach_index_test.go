@@ -0,0 +1,170 @@
+package security_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/steve-kaufman/go-auth-service/implementations/security"
+)
+
+func buildTestIndex(
+	t *testing.T, corpusPath string, hash security.BreachHash,
+) *security.BreachIndex {
+	indexPath := filepath.Join(t.TempDir(), "breach.idx")
+	err := security.BuildBreachIndex(corpusPath, hash, indexPath)
+	if err != nil {
+		t.Fatalf("Expected no error building index; Got: '%v'", err)
+	}
+	index, err := security.OpenBreachIndex(indexPath)
+	if err != nil {
+		t.Fatalf("Expected no error opening index; Got: '%v'", err)
+	}
+	t.Cleanup(func() { index.Close() })
+	return index
+}
+
+type BreachIndexTest struct {
+	name string
+
+	corpusPath string
+	hash       security.BreachHash
+}
+
+var breachIndexTests = []BreachIndexTest{
+	{
+		name: "SHA-1 corpus file",
+
+		corpusPath: "testdata/pwned-sha1.txt",
+		hash:       security.SHA1,
+	},
+	{
+		name: "SHA-1 range files",
+
+		corpusPath: "testdata/pwned-sha1-ranges",
+		hash:       security.SHA1,
+	},
+	{
+		name: "NTLM corpus file",
+
+		corpusPath: "testdata/pwned-ntlm.txt",
+		hash:       security.NTLM,
+	},
+}
+
+var breachedPasswords = map[string]bool{
+	"password":                  true,
+	"123456":                    true,
+	"trustno1":                  true,
+	"correcthorsebatterystaple": true,
+	"Password":                  false,
+	"correct horse 9":           false,
+	"":                          false,
+}
+
+func TestBreachIndex_IsBreached(t *testing.T) {
+	for _, tc := range breachIndexTests {
+		t.Run(tc.name, func(t *testing.T) {
+			index := buildTestIndex(t, tc.corpusPath, tc.hash)
+
+			for password, expected := range breachedPasswords {
+				breached, err := index.IsBreached(password)
+				if err != nil {
+					t.Fatalf("Expected no error; Got: '%v'", err)
+				}
+				if breached != expected {
+					t.Fatalf("Expected '%s' breached: %v; Got: %v",
+						password, expected, breached)
+				}
+			}
+		})
+	}
+}
+
+func TestBreachIndex_NTLMHashesUTF16(t *testing.T) {
+	index := buildTestIndex(t, "testdata/pwned-ntlm.txt", security.NTLM)
+
+	breached, err := index.IsBreached("pässwörd")
+	if err != nil || !breached {
+		t.Fatalf("Expected non-ASCII password breached; Got: %v, '%v'", breached, err)
+	}
+}
+
+func TestBreachIndex_IsCompact(t *testing.T) {
+	corpusPath := "testdata/pwned-sha1-ranges"
+	indexPath := filepath.Join(t.TempDir(), "breach.idx")
+	err := security.BuildBreachIndex(corpusPath, security.SHA1, indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A 6 byte header, then 20 bytes per hash, with the padding skipped.
+	if len(contents) != 6+10*20 {
+		t.Fatalf("Expected index of %d bytes; Got: %d", 6+10*20, len(contents))
+	}
+}
+
+type BadCorpusTest struct {
+	name string
+
+	contents    string
+	expectedErr error
+}
+
+var badCorpusTests = []BadCorpusTest{
+	{
+		name: "Unsorted hashes",
+
+		contents: "7C4A8D09CA3762AF61E59520943DC26494F8941B:1\n" +
+			"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:1\n",
+		expectedErr: security.ErrUnsortedCorpus,
+	},
+	{
+		name: "Truncated hash",
+
+		contents:    "5BAA61E4C9B93F3F0682250B6CF8331B7EE68F:1\n",
+		expectedErr: security.ErrMalformedCorpus,
+	},
+	{
+		name: "Non-numeric count",
+
+		contents:    "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:lots\n",
+		expectedErr: security.ErrMalformedCorpus,
+	},
+}
+
+func TestBuildBreachIndex_RejectsBadCorpus(t *testing.T) {
+	for _, tc := range badCorpusTests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			corpusPath := filepath.Join(dir, "corpus.txt")
+			err := ioutil.WriteFile(corpusPath, []byte(tc.contents), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			indexPath := filepath.Join(dir, "breach.idx")
+			err = security.BuildBreachIndex(corpusPath, security.SHA1, indexPath)
+
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+			if _, statErr := os.Stat(indexPath); !os.IsNotExist(statErr) {
+				t.Fatal("Expected no index to be left behind")
+			}
+		})
+	}
+}
+
+func TestOpenBreachIndex_RejectsOtherFiles(t *testing.T) {
+	_, err := security.OpenBreachIndex("testdata/pwned-sha1.txt")
+	if err != security.ErrMalformedIndex {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", security.ErrMalformedIndex, err)
+	}
+}
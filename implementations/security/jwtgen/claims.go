@@ -0,0 +1,73 @@
+package jwtgen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+)
+
+// DefaultMaxCustomClaimsSize caps the JSON size of a provider's claims, so
+// that access tokens still fit comfortably in an HTTP header.
+const DefaultMaxCustomClaimsSize = 1024
+
+var ErrReservedClaim = errors.New("custom claim would override a reserved claim")
+var ErrClaimsTooLarge = errors.New("custom claims are too large")
+
+// ClaimsProvider adds app specific claims such as roles or a tenant ID to
+// access tokens.
+type ClaimsProvider interface {
+	GetClaims(subject entities.TokenSubject) (map[string]interface{}, error)
+}
+
+// reservedClaims are the registered JWT claims and the ones this package
+// relies on. A ClaimsProvider can't set them.
+var reservedClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true,
+	"nbf": true, "iat": true, "jti": true,
+	"family": true, "token_use": true, "user_id": true,
+	"username": true, "token_version": true,
+}
+
+func (generator Generator) getCustomClaims(
+	subject entities.TokenSubject,
+) (map[string]interface{}, error) {
+	if generator.claimsProvider == nil {
+		return nil, nil
+	}
+	claims, err := generator.claimsProvider.GetClaims(subject)
+	if err != nil {
+		return nil, err
+	}
+	for name := range claims {
+		if reservedClaims[name] {
+			return nil, fmt.Errorf("%w: %q", ErrReservedClaim, name)
+		}
+	}
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	if len(encoded) > generator.maxCustomClaimsSize {
+		return nil, fmt.Errorf("%w: %d bytes is over the limit of %d",
+			ErrClaimsTooLarge, len(encoded), generator.maxCustomClaimsSize)
+	}
+	return claims, nil
+}
+
+// getCustomClaimsFromToken returns every claim that isn't reserved, or nil if
+// there are none.
+func getCustomClaimsFromToken(claims map[string]interface{}) map[string]interface{} {
+	var custom map[string]interface{}
+	for name, value := range claims {
+		if reservedClaims[name] {
+			continue
+		}
+		if custom == nil {
+			custom = map[string]interface{}{}
+		}
+		custom[name] = value
+	}
+	return custom
+}
@@ -0,0 +1,169 @@
+package jwtgen_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gomagedon/expectate"
+	"github.com/google/go-cmp/cmp"
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/implementations/security/jwtgen"
+)
+
+type MockClaimsProvider struct {
+	claims map[string]interface{}
+	err    error
+
+	subject entities.TokenSubject
+}
+
+func (provider *MockClaimsProvider) GetClaims(
+	subject entities.TokenSubject,
+) (map[string]interface{}, error) {
+	provider.subject = subject
+	return provider.claims, provider.err
+}
+
+func setupWithClaimsProvider(
+	provider jwtgen.ClaimsProvider, maxSize int,
+) (*jwtgen.Generator, *jwtgen.Verifier) {
+	timeGetter := &MockTimeGetter{Time: 42}
+	config := jwtgen.Config{
+		Secrets:             testSecrets,
+		ClaimsProvider:      provider,
+		MaxCustomClaimsSize: maxSize,
+	}
+	return jwtgen.NewGenerator(config, timeGetter, new(MockIDGetter)),
+		jwtgen.NewVerifier(config, timeGetter)
+}
+
+func TestClaimsProvider_AddsClaimsToAccessToken(t *testing.T) {
+	expect := expectate.Expect(t)
+	provider := &MockClaimsProvider{claims: map[string]interface{}{
+		"roles":        []string{"admin", "billing"},
+		"tenant_id":    "acme",
+		"display_name": "John Doe",
+	}}
+	generator, verifier := setupWithClaimsProvider(provider, 0)
+
+	tokens, err := generator.GetTokens(entities.TokenSubject{
+		UserID: 2, Username: "johndoe", Version: 1,
+	})
+	expect(err).ToBe(nil)
+	expect(provider.subject.Username).ToBe("johndoe")
+	expect(provider.subject.Version).ToBe(1)
+
+	accessClaims, err := verifier.VerifyAccessToken(tokens.AccessToken)
+	expect(err).ToBe(nil)
+	expectedCustom := map[string]interface{}{
+		"roles":        []interface{}{"admin", "billing"},
+		"tenant_id":    "acme",
+		"display_name": "John Doe",
+	}
+	if diff := cmp.Diff(expectedCustom, accessClaims.Custom); diff != "" {
+		t.Fatalf("Expected custom claims to match: \n%s", diff)
+	}
+	expect(accessClaims.Username).ToBe("johndoe")
+
+	refreshClaims, err := verifier.VerifyRefreshToken(tokens.RefreshToken)
+	expect(err).ToBe(nil)
+	expect(len(refreshClaims.Custom)).ToBe(0)
+}
+
+func TestClaimsProvider_WithoutProvider(t *testing.T) {
+	expect := expectate.Expect(t)
+	generator, verifier := setupWithClaimsProvider(nil, 0)
+
+	tokens, err := generator.GetTokens(entities.TokenSubject{UserID: 2, Username: "johndoe"})
+	expect(err).ToBe(nil)
+
+	claims, err := verifier.VerifyAccessToken(tokens.AccessToken)
+	expect(err).ToBe(nil)
+	expect(len(claims.Custom)).ToBe(0)
+}
+
+type ClaimsProviderErrorTest struct {
+	name string
+
+	claims      map[string]interface{}
+	providerErr error
+	maxSize     int
+	expectedErr error
+}
+
+var errProviderFailed = errors.New("roles lookup failed")
+
+var claimsProviderErrorTests = []ClaimsProviderErrorTest{
+	{
+		name: "Rejects override of user claim",
+
+		claims:      map[string]interface{}{"username": "admin"},
+		expectedErr: jwtgen.ErrReservedClaim,
+	},
+	{
+		name: "Rejects override of registered claim",
+
+		claims:      map[string]interface{}{"roles": "admin", "exp": 9999999999},
+		expectedErr: jwtgen.ErrReservedClaim,
+	},
+	{
+		name: "Rejects override of token_use",
+
+		claims:      map[string]interface{}{"token_use": "refresh"},
+		expectedErr: jwtgen.ErrReservedClaim,
+	},
+	{
+		name: "Rejects claims over the default size",
+
+		claims:      map[string]interface{}{"bio": strings.Repeat("a", 1024)},
+		expectedErr: jwtgen.ErrClaimsTooLarge,
+	},
+	{
+		name: "Rejects claims over the configured size",
+
+		claims:      map[string]interface{}{"tenant_id": "acme"},
+		maxSize:     10,
+		expectedErr: jwtgen.ErrClaimsTooLarge,
+	},
+	{
+		name: "Passes on provider errors",
+
+		providerErr: errProviderFailed,
+		expectedErr: errProviderFailed,
+	},
+}
+
+func TestClaimsProvider_Errors(t *testing.T) {
+	for _, tc := range claimsProviderErrorTests {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := &MockClaimsProvider{claims: tc.claims, err: tc.providerErr}
+			generator, _ := setupWithClaimsProvider(provider, tc.maxSize)
+
+			tokens, err := generator.GetTokens(entities.TokenSubject{
+				UserID: 2, Username: "johndoe",
+			})
+
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+			if tokens != (entities.LoginTokens{}) {
+				t.Fatalf("Expected no tokens; Got: %+v", tokens)
+			}
+		})
+	}
+}
+
+func TestClaimsProvider_CustomClaimsDoNotChangeVerification(t *testing.T) {
+	expect := expectate.Expect(t)
+
+	// A token carrying an unexpected claim is still a valid token.
+	token := signTestToken(t, jwt.SigningMethodHS256, claimsWith(jwt.MapClaims{
+		"tenant_id": "acme",
+	}), []byte(testSecrets.Access))
+
+	claims, err := setupVerifierWithTime(100).VerifyAccessToken(token)
+	expect(err).ToBe(nil)
+	expect(claims.Custom["tenant_id"]).ToBe("acme")
+}
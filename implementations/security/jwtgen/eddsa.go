@@ -0,0 +1,49 @@
+package jwtgen
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// jwt-go v3 predates EdDSA, so Ed25519 support is registered here.
+var SigningMethodEdDSA = new(signingMethodEdDSA)
+
+var errInvalidEdDSAKey = errors.New("key is not an Ed25519 key")
+
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (*signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (*signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", errInvalidEdDSAKey
+	}
+	signature := ed25519.Sign(privateKey, []byte(signingString))
+	return jwt.EncodeSegment(signature), nil
+}
+
+func (*signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return errInvalidEdDSAKey
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
@@ -1,34 +1,112 @@
 package jwtgen
 
 import (
+	"time"
+
 	"github.com/steve-kaufman/go-auth-service/entities"
 )
 
+// Secrets are shared HS256 secrets. They're only used if Keys isn't set.
 type Secrets struct {
 	Access  string
 	Refresh string
 }
 
+// Keys can be the same asymmetric key for both token types, since the
+// token_use claim already keeps them apart.
+type Keys struct {
+	Access  Key
+	Refresh Key
+}
+
+// Lifetimes are how long each type of token stays valid after it's issued.
+type Lifetimes struct {
+	Access  time.Duration
+	Refresh time.Duration
+}
+
+var DefaultLifetimes = Lifetimes{
+	Access:  15 * time.Minute,
+	Refresh: 30 * 24 * time.Hour,
+}
+
+// Config is shared by Generator and Verifier. A Keyring takes the place of
+// both Keys and Secrets. Zero Lifetimes fall back to DefaultLifetimes.
+//
+// Issuer and Audience are written to the iss and aud claims when set, and the
+// Verifier then only accepts tokens with that issuer and at least one of
+// those audiences. Giving each environment its own keeps their tokens apart.
+//
+// ClaimsProvider is optional. A zero MaxCustomClaimsSize falls back to
+// DefaultMaxCustomClaimsSize.
+type Config struct {
+	Secrets             Secrets
+	Keys                Keys
+	Keyring             *Keyring
+	Lifetimes           Lifetimes
+	Issuer              string
+	Audience            []string
+	ClaimsProvider      ClaimsProvider
+	MaxCustomClaimsSize int
+}
+
 type Generator struct {
-	accessSigner  *TokenSigner
-	refreshSigner *TokenSigner
+	accessSigner        *TokenSigner
+	refreshSigner       *TokenSigner
+	idGetter            IDGetter
+	claimsProvider      ClaimsProvider
+	maxCustomClaimsSize int
 }
 
-func NewGenerator(secrets Secrets, timeGetter TimeGetter) *Generator {
+func NewGenerator(
+	config Config, timeGetter TimeGetter, idGetter IDGetter,
+) *Generator {
+	accessKeys, refreshKeys := config.keySources()
+	lifetimes := config.lifetimes()
+
 	generator := new(Generator)
-	generator.accessSigner = NewTokenSigner(secrets.Access, timeGetter)
-	generator.refreshSigner = NewTokenSigner(secrets.Refresh, timeGetter)
+	generator.accessSigner = NewTokenSigner(
+		accessKeys, AccessToken, lifetimes.Access, timeGetter, idGetter,
+		config.Issuer, config.Audience,
+	)
+	generator.refreshSigner = NewTokenSigner(
+		refreshKeys, RefreshToken, lifetimes.Refresh, timeGetter, idGetter,
+		config.Issuer, config.Audience,
+	)
+	generator.idGetter = idGetter
+	generator.claimsProvider = config.ClaimsProvider
+	generator.maxCustomClaimsSize = config.MaxCustomClaimsSize
+	if generator.maxCustomClaimsSize == 0 {
+		generator.maxCustomClaimsSize = DefaultMaxCustomClaimsSize
+	}
 	return generator
 }
 
+// GetTokens issues an access and refresh token that share subject.Family,
+// starting a new family if it's empty. Custom claims only go in the access
+// token; the refresh token is only ever read by this service.
 func (generator Generator) GetTokens(
-	userID int, username string,
+	subject entities.TokenSubject,
 ) (entities.LoginTokens, error) {
-	accessToken, err := generator.accessSigner.GetSignedToken(userID, username)
+	if subject.Family == "" {
+		family, err := generator.idGetter.GetID()
+		if err != nil {
+			return entities.LoginTokens{}, err
+		}
+		subject.Family = family
+	}
+
+	customClaims, err := generator.getCustomClaims(subject)
 	if err != nil {
 		return entities.LoginTokens{}, err
 	}
-	refreshToken, err := generator.refreshSigner.GetSignedToken(userID, username)
+	accessToken, err := generator.accessSigner.getSignedTokenWithClaims(
+		subject, customClaims,
+	)
+	if err != nil {
+		return entities.LoginTokens{}, err
+	}
+	refreshToken, err := generator.refreshSigner.GetSignedToken(subject)
 	if err != nil {
 		return entities.LoginTokens{}, err
 	}
@@ -38,3 +116,28 @@ func (generator Generator) GetTokens(
 		RefreshToken: refreshToken,
 	}, nil
 }
+
+func (config Config) lifetimes() Lifetimes {
+	lifetimes := config.Lifetimes
+	if lifetimes.Access == 0 {
+		lifetimes.Access = DefaultLifetimes.Access
+	}
+	if lifetimes.Refresh == 0 {
+		lifetimes.Refresh = DefaultLifetimes.Refresh
+	}
+	return lifetimes
+}
+
+func (config Config) keySources() (access KeySource, refresh KeySource) {
+	if config.Keyring != nil {
+		return config.Keyring.AccessKeys(), config.Keyring.RefreshKeys()
+	}
+	keys := config.Keys
+	if keys.Access.isZero() {
+		keys.Access = NewHMACKey(config.Secrets.Access)
+	}
+	if keys.Refresh.isZero() {
+		keys.Refresh = NewHMACKey(config.Secrets.Refresh)
+	}
+	return keys.Access, keys.Refresh
+}
@@ -0,0 +1,23 @@
+package jwtgen
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// IDGetter supplies jti and family IDs. Like TimeGetter, it exists so tests
+// can produce deterministic tokens.
+type IDGetter interface {
+	GetID() (string, error)
+}
+
+type RandomIDGetter struct{}
+
+func (RandomIDGetter) GetID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
@@ -0,0 +1,86 @@
+package jwtgen
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+)
+
+// publicKey describes key as a JSON Web Key. Shared secrets must never be
+// published, so ok is false for HMAC keys.
+func (key Key) publicKey() (publicKey entities.PublicKey, ok bool) {
+	switch verifyKey := key.verifyKey.(type) {
+	case *rsa.PublicKey:
+		publicKey = entities.PublicKey{
+			KeyType: "RSA",
+			N:       encodeJWKParam(verifyKey.N.Bytes()),
+			E:       encodeJWKParam(big.NewInt(int64(verifyKey.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (verifyKey.Curve.Params().BitSize + 7) / 8
+		publicKey = entities.PublicKey{
+			KeyType: "EC",
+			Curve:   verifyKey.Curve.Params().Name,
+			X:       encodeJWKParam(padLeft(verifyKey.X.Bytes(), size)),
+			Y:       encodeJWKParam(padLeft(verifyKey.Y.Bytes(), size)),
+		}
+	case ed25519.PublicKey:
+		publicKey = entities.PublicKey{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       encodeJWKParam(verifyKey),
+		}
+	default:
+		return entities.PublicKey{}, false
+	}
+	publicKey.ID = key.id
+	publicKey.Algorithm = key.Algorithm()
+	return publicKey, true
+}
+
+// withThumbprintID names an asymmetric key by its RFC 7638 thumbprint, so
+// the same key always gets the same kid without any extra configuration.
+func (key Key) withThumbprintID() Key {
+	publicKey, ok := key.publicKey()
+	if !ok {
+		return key
+	}
+	// The thumbprint covers only the required members, in lexicographic
+	// order, which is how encoding/json already writes map keys.
+	members := map[string]string{"kty": publicKey.KeyType}
+	switch publicKey.KeyType {
+	case "RSA":
+		members["n"] = publicKey.N
+		members["e"] = publicKey.E
+	case "EC":
+		members["crv"] = publicKey.Curve
+		members["x"] = publicKey.X
+		members["y"] = publicKey.Y
+	case "OKP":
+		members["crv"] = publicKey.Curve
+		members["x"] = publicKey.X
+	}
+	canonical, _ := json.Marshal(members)
+	digest := sha256.Sum256(canonical)
+	key.id = encodeJWKParam(digest[:])
+	return key
+}
+
+func encodeJWKParam(param []byte) string {
+	return base64.RawURLEncoding.EncodeToString(param)
+}
+
+func padLeft(param []byte, size int) []byte {
+	if len(param) >= size {
+		return param
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(param):], param)
+	return padded
+}
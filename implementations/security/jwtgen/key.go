@@ -0,0 +1,169 @@
+package jwtgen
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const minRSABits = 2048
+
+// MinHMACSecretLength is the shortest HS256 secret accepted, in bytes. It's
+// the length of the SHA-256 output, so that the secret isn't the weak point.
+const MinHMACSecretLength = 32
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+var ErrInvalidKey = errors.New("key doesn't match signing algorithm")
+
+// Key signs and verifies tokens with one algorithm. A Key made from a public
+// key can only verify. Asymmetric keys are identified by their thumbprint,
+// which is sent as the kid header of the tokens they sign.
+type Key struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+func NewHMACKey(secret string) Key {
+	return Key{
+		method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+}
+
+// LoadPrivateKeyFile reads a PEM encoded private key for algorithm, which is
+// one of RS256, ES256 or EdDSA.
+func LoadPrivateKeyFile(algorithm string, path string) (Key, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+	return ParsePrivateKeyPEM(algorithm, contents)
+}
+
+func ParsePrivateKeyPEM(algorithm string, pemBytes []byte) (Key, error) {
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return Key{}, err
+		}
+		return newRSAKey(privateKey, &privateKey.PublicKey)
+	case jwt.SigningMethodES256.Alg():
+		privateKey, err := jwt.ParseECPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return Key{}, err
+		}
+		return newECKey(privateKey, &privateKey.PublicKey)
+	case SigningMethodEdDSA.Alg():
+		privateKey, err := parsePKCS8PEM(pemBytes)
+		if err != nil {
+			return Key{}, err
+		}
+		edKey, ok := privateKey.(ed25519.PrivateKey)
+		if !ok {
+			return Key{}, ErrInvalidKey
+		}
+		return newEdDSAKey(edKey, edKey.Public().(ed25519.PublicKey)), nil
+	}
+	return Key{}, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, algorithm)
+}
+
+// ParsePublicKeyPEM makes a verify-only Key, for services that check tokens
+// but must not be able to mint them.
+func ParsePublicKeyPEM(algorithm string, pemBytes []byte) (Key, error) {
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM(pemBytes)
+		if err != nil {
+			return Key{}, err
+		}
+		return newRSAKey(nil, publicKey)
+	case jwt.SigningMethodES256.Alg():
+		publicKey, err := jwt.ParseECPublicKeyFromPEM(pemBytes)
+		if err != nil {
+			return Key{}, err
+		}
+		return newECKey(nil, publicKey)
+	case SigningMethodEdDSA.Alg():
+		block, _ := pem.Decode(pemBytes)
+		if block == nil {
+			return Key{}, ErrInvalidKey
+		}
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+		edKey, ok := publicKey.(ed25519.PublicKey)
+		if !ok {
+			return Key{}, ErrInvalidKey
+		}
+		return newEdDSAKey(nil, edKey), nil
+	}
+	return Key{}, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, algorithm)
+}
+
+func newRSAKey(privateKey *rsa.PrivateKey, publicKey *rsa.PublicKey) (Key, error) {
+	if publicKey.N.BitLen() < minRSABits {
+		return Key{}, fmt.Errorf("%w: RSA keys must be at least %d bits",
+			ErrInvalidKey, minRSABits)
+	}
+	key := Key{method: jwt.SigningMethodRS256, verifyKey: publicKey}
+	if privateKey != nil {
+		key.signKey = privateKey
+	}
+	return key.withThumbprintID(), nil
+}
+
+func newECKey(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey) (Key, error) {
+	if publicKey.Curve != elliptic.P256() {
+		return Key{}, fmt.Errorf("%w: ES256 needs a P-256 key", ErrInvalidKey)
+	}
+	key := Key{method: jwt.SigningMethodES256, verifyKey: publicKey}
+	if privateKey != nil {
+		key.signKey = privateKey
+	}
+	return key.withThumbprintID(), nil
+}
+
+func newEdDSAKey(privateKey ed25519.PrivateKey, publicKey ed25519.PublicKey) Key {
+	key := Key{method: SigningMethodEdDSA, verifyKey: publicKey}
+	if privateKey != nil {
+		key.signKey = privateKey
+	}
+	return key.withThumbprintID()
+}
+
+func parsePKCS8PEM(pemBytes []byte) (interface{}, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, ErrInvalidKey
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+// ID is empty for HMAC keys.
+func (key Key) ID() string {
+	return key.id
+}
+
+func (key Key) Algorithm() string {
+	if key.method == nil {
+		return ""
+	}
+	return key.method.Alg()
+}
+
+func (key Key) isZero() bool {
+	return key.method == nil
+}
@@ -0,0 +1,352 @@
+package jwtgen_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gomagedon/expectate"
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/implementations/security/jwtgen"
+)
+
+type KeyPEMs struct {
+	private []byte
+	public  []byte
+}
+
+func encodePEM(t *testing.T, blockType string, der []byte, err error) []byte {
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func encodePublicPEM(t *testing.T, publicKey interface{}) []byte {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	return encodePEM(t, "PUBLIC KEY", der, err)
+}
+
+func generateRSAPEMs(t *testing.T, bits int) KeyPEMs {
+	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return KeyPEMs{
+		private: encodePEM(t, "RSA PRIVATE KEY",
+			x509.MarshalPKCS1PrivateKey(privateKey), nil),
+		public: encodePublicPEM(t, &privateKey.PublicKey),
+	}
+}
+
+func generateECPEMs(t *testing.T, curve elliptic.Curve) KeyPEMs {
+	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(privateKey)
+	return KeyPEMs{
+		private: encodePEM(t, "EC PRIVATE KEY", der, err),
+		public:  encodePublicPEM(t, &privateKey.PublicKey),
+	}
+}
+
+func generateEdDSAPEMs(t *testing.T) KeyPEMs {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	return KeyPEMs{
+		private: encodePEM(t, "PRIVATE KEY", der, err),
+		public:  encodePublicPEM(t, publicKey),
+	}
+}
+
+func getTokenHeader(t *testing.T, token string) map[string]interface{} {
+	segment, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header map[string]interface{}
+	err = json.Unmarshal(segment, &header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+type AsymmetricKeyTest struct {
+	algorithm    string
+	generatePEMs func(t *testing.T) KeyPEMs
+}
+
+var asymmetricKeyTests = []AsymmetricKeyTest{
+	{
+		algorithm:    "RS256",
+		generatePEMs: func(t *testing.T) KeyPEMs { return generateRSAPEMs(t, 2048) },
+	},
+	{
+		algorithm:    "ES256",
+		generatePEMs: func(t *testing.T) KeyPEMs { return generateECPEMs(t, elliptic.P256()) },
+	},
+	{
+		algorithm:    "EdDSA",
+		generatePEMs: generateEdDSAPEMs,
+	},
+}
+
+func TestKey_SignsWithAsymmetricKeys(t *testing.T) {
+	for _, tc := range asymmetricKeyTests {
+		t.Run(tc.algorithm, func(t *testing.T) {
+			expect := expectate.Expect(t)
+			pems := tc.generatePEMs(t)
+
+			privateKey, err := jwtgen.ParsePrivateKeyPEM(tc.algorithm, pems.private)
+			expect(err).ToBe(nil)
+			expect(privateKey.Algorithm()).ToBe(tc.algorithm)
+
+			timeGetter := &MockTimeGetter{Time: 42}
+			generator := jwtgen.NewGenerator(jwtgen.Config{
+				Keys: jwtgen.Keys{Access: privateKey, Refresh: privateKey},
+			}, timeGetter, new(MockIDGetter))
+
+			tokens, err := generator.GetTokens(entities.TokenSubject{
+				UserID: 2, Username: "johndoe",
+			})
+			expect(err).ToBe(nil)
+			expect(getTokenHeader(t, tokens.AccessToken)["alg"]).ToBe(tc.algorithm)
+			expect(getTokenHeader(t, tokens.AccessToken)["kid"]).ToBe(privateKey.ID())
+
+			// Resource servers only need the public key.
+			publicKey, err := jwtgen.ParsePublicKeyPEM(tc.algorithm, pems.public)
+			expect(err).ToBe(nil)
+			verifier := jwtgen.NewVerifier(jwtgen.Config{
+				Keys: jwtgen.Keys{Access: publicKey, Refresh: publicKey},
+			}, timeGetter)
+
+			claims, err := verifier.VerifyAccessToken(tokens.AccessToken)
+			expect(err).ToBe(nil)
+			expect(claims.Username).ToBe("johndoe")
+
+			_, err = verifier.VerifyRefreshToken(tokens.RefreshToken)
+			expect(err).ToBe(nil)
+
+			expect(publicKey.ID()).ToBe(privateKey.ID())
+			publicKeys := verifier.GetPublicKeys()
+			expect(len(publicKeys)).ToBe(1)
+			expect(publicKeys[0].ID).ToBe(privateKey.ID())
+			expect(publicKeys[0].Algorithm).ToBe(tc.algorithm)
+
+			_, err = verifier.VerifyAccessToken(tokens.RefreshToken)
+			expect(err).ToBe(jwtgen.ErrWrongTokenType)
+
+			// Tokens signed with the old shared secrets are no longer accepted.
+			oldTokens, err := setupWithTime(42).GetTokens(entities.TokenSubject{
+				UserID: 2, Username: "johndoe",
+			})
+			expect(err).ToBe(nil)
+			_, err = verifier.VerifyAccessToken(oldTokens.AccessToken)
+			expect(err).ToBe(jwtgen.ErrBadSignature)
+		})
+	}
+}
+
+func TestKey_PublicKeyCannotSign(t *testing.T) {
+	expect := expectate.Expect(t)
+
+	publicKey, err := jwtgen.ParsePublicKeyPEM("EdDSA", generateEdDSAPEMs(t).public)
+	expect(err).ToBe(nil)
+
+	generator := jwtgen.NewGenerator(jwtgen.Config{
+		Keys: jwtgen.Keys{Access: publicKey, Refresh: publicKey},
+	}, &MockTimeGetter{Time: 42}, new(MockIDGetter))
+
+	_, err = generator.GetTokens(entities.TokenSubject{UserID: 2, Username: "johndoe"})
+	expect(err).ToBe(jwtgen.ErrCannotSign)
+}
+
+func TestKey_RejectsHMACTokenSignedWithPublicKey(t *testing.T) {
+	expect := expectate.Expect(t)
+	pems := generateRSAPEMs(t, 2048)
+
+	publicKey, err := jwtgen.ParsePublicKeyPEM("RS256", pems.public)
+	expect(err).ToBe(nil)
+	verifier := jwtgen.NewVerifier(jwtgen.Config{
+		Keys: jwtgen.Keys{Access: publicKey, Refresh: publicKey},
+	}, &MockTimeGetter{Time: 100})
+
+	forged := signTestToken(t, jwt.SigningMethodHS256, validClaims, pems.public)
+
+	_, err = verifier.VerifyAccessToken(forged)
+	expect(err).ToBe(jwtgen.ErrBadSignature)
+}
+
+func TestKey_IDIsThumbprint(t *testing.T) {
+	expect := expectate.Expect(t)
+
+	// The Ed25519 example from RFC 8037, appendix A.
+	x, err := base64.RawURLEncoding.DecodeString(
+		"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo",
+	)
+	expect(err).ToBe(nil)
+
+	publicKey, err := jwtgen.ParsePublicKeyPEM(
+		"EdDSA", encodePublicPEM(t, ed25519.PublicKey(x)),
+	)
+	expect(err).ToBe(nil)
+	expect(publicKey.ID()).ToBe("kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k")
+}
+
+func TestKey_PublishesKeyParameters(t *testing.T) {
+	expect := expectate.Expect(t)
+	decode := func(param string) []byte {
+		decoded, err := base64.RawURLEncoding.DecodeString(param)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decoded
+	}
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	pems := map[string][]byte{
+		"RS256": encodePublicPEM(t, &rsaKey.PublicKey),
+		"ES256": encodePublicPEM(t, &ecKey.PublicKey),
+		"EdDSA": encodePublicPEM(t, edKey),
+	}
+	published := map[string]entities.PublicKey{}
+	for algorithm, pem := range pems {
+		key, err := jwtgen.ParsePublicKeyPEM(algorithm, pem)
+		expect(err).ToBe(nil)
+		verifier := jwtgen.NewVerifier(jwtgen.Config{
+			Keys: jwtgen.Keys{Access: key, Refresh: key},
+		}, &MockTimeGetter{Time: 100})
+		published[algorithm] = verifier.GetPublicKeys()[0]
+	}
+
+	expect(published["RS256"].KeyType).ToBe("RSA")
+	expect(new(big.Int).SetBytes(decode(published["RS256"].N)).Cmp(rsaKey.N)).ToBe(0)
+	expect(int(new(big.Int).SetBytes(decode(published["RS256"].E)).Int64())).ToBe(rsaKey.E)
+
+	expect(published["ES256"].KeyType).ToBe("EC")
+	expect(published["ES256"].Curve).ToBe("P-256")
+	expect(len(decode(published["ES256"].X))).ToBe(32)
+	expect(new(big.Int).SetBytes(decode(published["ES256"].X)).Cmp(ecKey.X)).ToBe(0)
+	expect(new(big.Int).SetBytes(decode(published["ES256"].Y)).Cmp(ecKey.Y)).ToBe(0)
+
+	expect(published["EdDSA"].KeyType).ToBe("OKP")
+	expect(published["EdDSA"].Curve).ToBe("Ed25519")
+	expect(string(decode(published["EdDSA"].X))).ToBe(string(edKey))
+}
+
+func TestVerifier_PicksKeyByKid(t *testing.T) {
+	expect := expectate.Expect(t)
+	timeGetter := &MockTimeGetter{Time: 42}
+
+	trustedKey, err := jwtgen.ParsePrivateKeyPEM("ES256", generateECPEMs(t, elliptic.P256()).private)
+	expect(err).ToBe(nil)
+	otherPEMs := generateECPEMs(t, elliptic.P256())
+	otherKey, err := jwtgen.ParsePrivateKeyPEM("ES256", otherPEMs.private)
+	expect(err).ToBe(nil)
+	verifier := jwtgen.NewVerifier(jwtgen.Config{
+		Keys: jwtgen.Keys{Access: trustedKey, Refresh: trustedKey},
+	}, timeGetter)
+
+	otherTokens, err := jwtgen.NewGenerator(jwtgen.Config{
+		Keys: jwtgen.Keys{Access: otherKey, Refresh: otherKey},
+	}, timeGetter, new(MockIDGetter)).GetTokens(entities.TokenSubject{
+		UserID: 2, Username: "johndoe",
+	})
+	expect(err).ToBe(nil)
+	_, err = verifier.VerifyAccessToken(otherTokens.AccessToken)
+	expect(err).ToBe(jwtgen.ErrUnknownKey)
+
+	// Claiming a trusted kid doesn't help without the matching signature.
+	privateKey, err := jwt.ParseECPrivateKeyFromPEM(otherPEMs.private)
+	expect(err).ToBe(nil)
+	forged := jwt.NewWithClaims(jwt.SigningMethodES256, claimsWith(jwt.MapClaims{
+		"iat": 0, "nbf": 0, "exp": 100,
+	}))
+	forged.Header["kid"] = trustedKey.ID()
+	forgedToken, err := forged.SignedString(privateKey)
+	expect(err).ToBe(nil)
+	_, err = verifier.VerifyAccessToken(forgedToken)
+	expect(err).ToBe(jwtgen.ErrBadSignature)
+}
+
+type BadKeyTest struct {
+	name string
+
+	algorithm   string
+	pem         func(t *testing.T) []byte
+	expectedErr error
+}
+
+var badKeyTests = []BadKeyTest{
+	{
+		name: "Unsupported algorithm",
+
+		algorithm:   "PS512",
+		pem:         func(t *testing.T) []byte { return generateRSAPEMs(t, 2048).private },
+		expectedErr: jwtgen.ErrUnsupportedAlgorithm,
+	},
+	{
+		name: "RSA key too short",
+
+		algorithm:   "RS256",
+		pem:         func(t *testing.T) []byte { return generateRSAPEMs(t, 1024).private },
+		expectedErr: jwtgen.ErrInvalidKey,
+	},
+	{
+		name: "ES256 with P-384 key",
+
+		algorithm:   "ES256",
+		pem:         func(t *testing.T) []byte { return generateECPEMs(t, elliptic.P384()).private },
+		expectedErr: jwtgen.ErrInvalidKey,
+	},
+	{
+		name: "EdDSA with RSA key",
+
+		algorithm: "EdDSA",
+		pem: func(t *testing.T) []byte {
+			privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+			return encodePEM(t, "PRIVATE KEY", der, err)
+		},
+		expectedErr: jwtgen.ErrInvalidKey,
+	},
+	{
+		name: "EdDSA with garbage",
+
+		algorithm:   "EdDSA",
+		pem:         func(*testing.T) []byte { return []byte("not a key") },
+		expectedErr: jwtgen.ErrInvalidKey,
+	},
+}
+
+func TestParsePrivateKeyPEM_RejectsBadKeys(t *testing.T) {
+	for _, tc := range badKeyTests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := jwtgen.ParsePrivateKeyPEM(tc.algorithm, tc.pem(t))
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package jwtgen
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var ErrNoKeys = errors.New("key directory has no keys")
+
+// KeySource supplies the key that new tokens are signed with and every key
+// that tokens may still be verified with, the signing key first. A single Key
+// is a KeySource that never changes.
+type KeySource interface {
+	SigningKey() Key
+	VerificationKeys() []Key
+}
+
+func (key Key) SigningKey() Key {
+	return key
+}
+
+func (key Key) VerificationKeys() []Key {
+	return []Key{key}
+}
+
+// Keyring is backed by a directory of key files, one key per file. Files are
+// ordered by name, so name them in the order they're created, e.g. by date.
+//
+// A new key is published for publishDelay before it signs anything, so that
+// resource servers that cache the published keys for that long have it by
+// the time they see a token signed with it. Then it becomes the active
+// signing key, and the keys before it are retired: they keep verifying until
+// maxTokenLifetime after they stopped being active, when every token they
+// signed has expired.
+//
+// A key is taken to have been added when a reload first found its file, or
+// for files that were there when the keyring was made, when the file was
+// last modified, which survives restarts. Don't touch key files once they're
+// written, and don't copy one in while the service is down with an old
+// modification time, since the key before it would retire too early.
+// Deleting a key's file stops it verifying straight away, which is how a
+// leaked key is revoked.
+type Keyring struct {
+	dir              string
+	algorithm        string
+	maxTokenLifetime time.Duration
+	publishDelay     time.Duration
+	timeGetter       TimeGetter
+
+	mutex sync.RWMutex
+	files []keyFile
+}
+
+// keyFile is a key file loaded as a key for each token type. They're the
+// same key unless it's an HS256 secret.
+type keyFile struct {
+	name    string
+	access  Key
+	refresh Key
+	addedAt float64
+}
+
+func (file keyFile) key(tokenType TokenType) Key {
+	if tokenType == RefreshToken {
+		return file.refresh
+	}
+	return file.access
+}
+
+// NewKeyring loads the keys in dir. For HS256 each file holds a shared
+// secret of at least MinHMACSecretLength bytes, from which separate access
+// and refresh secrets are derived, as they're kept apart without a keyring;
+// otherwise each file is a PEM private key for algorithm.
+func NewKeyring(
+	dir string, algorithm string,
+	maxTokenLifetime time.Duration, publishDelay time.Duration,
+	timeGetter TimeGetter,
+) (*Keyring, error) {
+	keyring := new(Keyring)
+	keyring.dir = dir
+	keyring.algorithm = algorithm
+	keyring.maxTokenLifetime = maxTokenLifetime
+	keyring.publishDelay = publishDelay
+	keyring.timeGetter = timeGetter
+	err := keyring.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// Reload rereads the key directory. If any file can't be loaded the keyring
+// is left as it was.
+func (keyring *Keyring) Reload() error {
+	files, err := loadKeyDir(keyring.dir, keyring.algorithm)
+	if err != nil {
+		return err
+	}
+	now := keyring.timeGetter.GetTime()
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+	if keyring.files != nil {
+		keyring.clampAddedTimes(files, now)
+	}
+	keyring.files = files
+	return nil
+}
+
+// clampAddedTimes stops a new file's modification time, which cp -p or tar
+// can set to long ago, from making its key sign straight away or retiring
+// the key before it early. Files seen before keep the time they were added.
+func (keyring *Keyring) clampAddedTimes(files []keyFile, now float64) {
+	addedAt := map[string]float64{}
+	for _, file := range keyring.files {
+		addedAt[file.identity()] = file.addedAt
+	}
+	for i := range files {
+		if seenAt, ok := addedAt[files[i].identity()]; ok {
+			files[i].addedAt = seenAt
+		} else if files[i].addedAt < now {
+			files[i].addedAt = now
+		}
+	}
+}
+
+// identity tells a file apart from one that replaced it under the same name.
+func (file keyFile) identity() string {
+	return file.name + "\x00" + file.access.ID()
+}
+
+// activatedAt is when a file's key starts signing, if it's the newest.
+func (keyring *Keyring) activatedAt(file keyFile) float64 {
+	return file.addedAt + keyring.publishDelay.Seconds()
+}
+
+// signingIndex is the newest file that has been published for
+// publishDelay. If none has, as when the keyring is first made from new
+// files, it's the oldest, since nothing has been published before it.
+func (keyring *Keyring) signingIndex(now float64) int {
+	for i := len(keyring.files) - 1; i > 0; i-- {
+		if keyring.activatedAt(keyring.files[i]) <= now {
+			return i
+		}
+	}
+	return 0
+}
+
+// AccessKeys and RefreshKeys are the KeySources for each token type.
+func (keyring *Keyring) AccessKeys() KeySource {
+	return keyringSource{keyring: keyring, tokenType: AccessToken}
+}
+
+func (keyring *Keyring) RefreshKeys() KeySource {
+	return keyringSource{keyring: keyring, tokenType: RefreshToken}
+}
+
+type keyringSource struct {
+	keyring   *Keyring
+	tokenType TokenType
+}
+
+func (source keyringSource) SigningKey() Key {
+	now := source.keyring.timeGetter.GetTime()
+	source.keyring.mutex.RLock()
+	defer source.keyring.mutex.RUnlock()
+	signing := source.keyring.signingIndex(now)
+	return source.keyring.files[signing].key(source.tokenType)
+}
+
+// VerificationKeys has the keys waiting to sign as well as the retired ones.
+func (source keyringSource) VerificationKeys() []Key {
+	now := source.keyring.timeGetter.GetTime()
+	lifetime := source.keyring.maxTokenLifetime.Seconds()
+	source.keyring.mutex.RLock()
+	defer source.keyring.mutex.RUnlock()
+	files := source.keyring.files
+	signing := source.keyring.signingIndex(now)
+	keys := []Key{files[signing].key(source.tokenType)}
+	for i := len(files) - 1; i >= 0; i-- {
+		if i == signing {
+			continue
+		}
+		pending := i > signing
+		if pending || now < source.keyring.activatedAt(files[i+1])+lifetime {
+			keys = append(keys, files[i].key(source.tokenType))
+		}
+	}
+	return keys
+}
+
+func loadKeyDir(dir string, algorithm string) ([]keyFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	modTimes := map[string]float64{}
+	for _, entry := range entries {
+		// Skips the hidden files and directories that secret volume mounts
+		// keep next to the keys.
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			continue
+		}
+		names = append(names, entry.Name())
+		modTimes[entry.Name()] = float64(info.ModTime().UnixNano()) / float64(time.Second)
+	}
+	if len(names) == 0 {
+		return nil, ErrNoKeys
+	}
+	sort.Strings(names)
+
+	files := make([]keyFile, 0, len(names))
+	for _, name := range names {
+		file, err := loadKeyFile(algorithm, filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("loading key %s: %w", name, err)
+		}
+		file.name = name
+		file.addedAt = modTimes[name]
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func loadKeyFile(algorithm string, path string) (keyFile, error) {
+	if algorithm != jwt.SigningMethodHS256.Alg() {
+		key, err := LoadPrivateKeyFile(algorithm, path)
+		return keyFile{access: key, refresh: key}, err
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return keyFile{}, err
+	}
+	secret := strings.TrimSpace(string(contents))
+	if len(secret) < MinHMACSecretLength {
+		return keyFile{}, fmt.Errorf(
+			"%w: secret is shorter than %d bytes", ErrInvalidKey, MinHMACSecretLength,
+		)
+	}
+	id := hmacKeyID(secret)
+	return keyFile{
+		access:  deriveHMACKey(secret, AccessToken).withID(id),
+		refresh: deriveHMACKey(secret, RefreshToken).withID(id),
+	}, nil
+}
+
+// deriveHMACKey makes a secret for one token type from a keyring secret.
+func deriveHMACKey(secret string, tokenType TokenType) Key {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("go-auth-service " + string(tokenType) + " token"))
+	return NewHMACKey(string(mac.Sum(nil)))
+}
+
+// hmacKeyID names a keyring secret by a hash of it, so that two files can't
+// end up with the same kid. The hash is truncated; it only has to tell the
+// few keys in a keyring apart.
+func hmacKeyID(secret string) string {
+	digest := sha256.Sum256([]byte("go-auth-service kid " + secret))
+	return base64.RawURLEncoding.EncodeToString(digest[:12])
+}
+
+func (key Key) withID(id string) Key {
+	key.id = id
+	return key
+}
@@ -0,0 +1,308 @@
+package jwtgen_test
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gomagedon/expectate"
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/implementations/security/jwtgen"
+)
+
+const testMaxTokenLifetime = time.Hour
+const testKeyPublishDelay = 5 * time.Minute
+
+type KeyringSetup struct {
+	dir        string
+	timeGetter *MockTimeGetter
+	keyring    *jwtgen.Keyring
+	generator  *jwtgen.Generator
+	verifier   *jwtgen.Verifier
+}
+
+// writeKeyFile sets the file's modification time to at, which is when a
+// keyring made afterwards takes the key to have been added.
+func writeKeyFile(t *testing.T, dir string, name string, contents []byte, at float64) {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, contents, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Unix(int64(at), 0)
+	err = os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setupKeyring(t *testing.T, algorithm string, files map[string][]byte) KeyringSetup {
+	dir := t.TempDir()
+	for name, contents := range files {
+		writeKeyFile(t, dir, name, contents, 1000)
+	}
+	// Late enough that every key has been published for long enough to sign.
+	timeGetter := &MockTimeGetter{Time: 1000 + testKeyPublishDelay.Seconds()}
+
+	keyring, err := jwtgen.NewKeyring(
+		dir, algorithm, testMaxTokenLifetime, testKeyPublishDelay, timeGetter,
+	)
+	if err != nil {
+		t.Fatalf("Expected no error loading keyring; Got: '%v'", err)
+	}
+	config := jwtgen.Config{
+		Keyring: keyring,
+		Lifetimes: jwtgen.Lifetimes{
+			Access:  15 * time.Minute,
+			Refresh: testMaxTokenLifetime,
+		},
+	}
+	return KeyringSetup{
+		dir:        dir,
+		timeGetter: timeGetter,
+		keyring:    keyring,
+		generator:  jwtgen.NewGenerator(config, timeGetter, new(MockIDGetter)),
+		verifier:   jwtgen.NewVerifier(config, timeGetter),
+	}
+}
+
+func (setup KeyringSetup) issueTokens(t *testing.T) entities.LoginTokens {
+	tokens, err := setup.generator.GetTokens(entities.TokenSubject{
+		UserID: 2, Username: "johndoe",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error issuing tokens; Got: '%v'", err)
+	}
+	return tokens
+}
+
+func publicKeyIDs(verifier *jwtgen.Verifier) []string {
+	var ids []string
+	for _, publicKey := range verifier.GetPublicKeys() {
+		ids = append(ids, publicKey.ID)
+	}
+	return ids
+}
+
+func parseKeyID(t *testing.T, algorithm string, pem []byte) string {
+	key, err := jwtgen.ParsePrivateKeyPEM(algorithm, pem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.ID()
+}
+
+func TestKeyring_SignsWithNewestKey(t *testing.T) {
+	expect := expectate.Expect(t)
+	oldPEM := generateECPEMs(t, elliptic.P256()).private
+	newPEM := generateECPEMs(t, elliptic.P256()).private
+
+	setup := setupKeyring(t, "ES256", map[string][]byte{
+		"2021-01-01.pem": oldPEM,
+		"2021-06-01.pem": newPEM,
+	})
+
+	tokens := setup.issueTokens(t)
+	expect(getTokenHeader(t, tokens.AccessToken)["kid"]).ToBe(parseKeyID(t, "ES256", newPEM))
+	expect(getTokenHeader(t, tokens.RefreshToken)["kid"]).ToBe(parseKeyID(t, "ES256", newPEM))
+	expect(len(publicKeyIDs(setup.verifier))).ToBe(2)
+}
+
+func TestKeyring_RotatesWithoutInvalidatingTokens(t *testing.T) {
+	expect := expectate.Expect(t)
+	oldPEM := generateECPEMs(t, elliptic.P256()).private
+	newPEM := generateECPEMs(t, elliptic.P256()).private
+	setup := setupKeyring(t, "ES256", map[string][]byte{"1.pem": oldPEM})
+	oldTokens := setup.issueTokens(t)
+
+	writeKeyFile(t, setup.dir, "2.pem", newPEM, 2000)
+	setup.timeGetter.Time = 2000
+	expect(setup.keyring.Reload()).ToBe(nil)
+	activatedAt := 2000 + testKeyPublishDelay.Seconds()
+	setup.timeGetter.Time = activatedAt
+
+	newTokens := setup.issueTokens(t)
+	expect(getTokenHeader(t, newTokens.AccessToken)["kid"]).ToBe(parseKeyID(t, "ES256", newPEM))
+	_, err := setup.verifier.VerifyRefreshToken(oldTokens.RefreshToken)
+	expect(err).ToBe(nil)
+	_, err = setup.verifier.VerifyRefreshToken(newTokens.RefreshToken)
+	expect(err).ToBe(nil)
+
+	// Still verifying just before every token from the old key has expired.
+	setup.timeGetter.Time = activatedAt + testMaxTokenLifetime.Seconds() - 1
+	expect(setup.keyring.Reload()).ToBe(nil)
+	expect(len(publicKeyIDs(setup.verifier))).ToBe(2)
+
+	setup.timeGetter.Time = activatedAt + testMaxTokenLifetime.Seconds()
+	expect(publicKeyIDs(setup.verifier)[0]).ToBe(parseKeyID(t, "ES256", newPEM))
+	expect(len(publicKeyIDs(setup.verifier))).ToBe(1)
+	_, err = setup.verifier.VerifyRefreshToken(oldTokens.RefreshToken)
+	expect(err).ToBe(jwtgen.ErrUnknownKey)
+}
+
+func TestKeyring_PublishesNewKeyBeforeSigningWithIt(t *testing.T) {
+	expect := expectate.Expect(t)
+	oldPEM := generateECPEMs(t, elliptic.P256()).private
+	newPEM := generateECPEMs(t, elliptic.P256()).private
+	setup := setupKeyring(t, "ES256", map[string][]byte{"1.pem": oldPEM})
+
+	writeKeyFile(t, setup.dir, "2.pem", newPEM, 2000)
+	setup.timeGetter.Time = 2000
+	expect(setup.keyring.Reload()).ToBe(nil)
+
+	// Published straight away, so that caches pick it up...
+	expect(publicKeyIDs(setup.verifier)).ToEqual([]string{
+		parseKeyID(t, "ES256", oldPEM), parseKeyID(t, "ES256", newPEM),
+	})
+	// ...but only signing once they've had time to.
+	setup.timeGetter.Time = 2000 + testKeyPublishDelay.Seconds() - 1
+	tokens := setup.issueTokens(t)
+	expect(getTokenHeader(t, tokens.AccessToken)["kid"]).ToBe(parseKeyID(t, "ES256", oldPEM))
+
+	setup.timeGetter.Time = 2000 + testKeyPublishDelay.Seconds()
+	tokens = setup.issueTokens(t)
+	expect(getTokenHeader(t, tokens.AccessToken)["kid"]).ToBe(parseKeyID(t, "ES256", newPEM))
+}
+
+func TestKeyring_OldModTimeDoesNotRetirePreviousKeyEarly(t *testing.T) {
+	expect := expectate.Expect(t)
+	oldPEM := generateECPEMs(t, elliptic.P256()).private
+	newPEM := generateECPEMs(t, elliptic.P256()).private
+	setup := setupKeyring(t, "ES256", map[string][]byte{"1.pem": oldPEM})
+
+	// Copied in with its modification time kept, from long before.
+	writeKeyFile(t, setup.dir, "2.pem", newPEM, 1)
+	setup.timeGetter.Time = 10000
+	expect(setup.keyring.Reload()).ToBe(nil)
+	oldTokens := setup.issueTokens(t)
+	expect(getTokenHeader(t, oldTokens.AccessToken)["kid"]).ToBe(parseKeyID(t, "ES256", oldPEM))
+
+	activatedAt := 10000 + testKeyPublishDelay.Seconds()
+	setup.timeGetter.Time = activatedAt + testMaxTokenLifetime.Seconds() - 1
+	expect(setup.keyring.Reload()).ToBe(nil)
+	expect(len(publicKeyIDs(setup.verifier))).ToBe(2)
+
+	setup.timeGetter.Time = activatedAt + testMaxTokenLifetime.Seconds()
+	expect(publicKeyIDs(setup.verifier)).ToEqual([]string{parseKeyID(t, "ES256", newPEM)})
+}
+
+func TestKeyring_RetirementSurvivesRestart(t *testing.T) {
+	expect := expectate.Expect(t)
+	setup := setupKeyring(t, "HS256", map[string][]byte{
+		"1.key": []byte("first secret of at least 32 bytes\n"),
+		"2.key": []byte("second secret of at least 32 bytes\n"),
+	})
+	expect(len(setup.keyring.AccessKeys().VerificationKeys())).ToBe(2)
+
+	// A restart after every token from the first key has expired.
+	setup.timeGetter.Time += testMaxTokenLifetime.Seconds()
+	keyring, err := jwtgen.NewKeyring(
+		setup.dir, "HS256", testMaxTokenLifetime, testKeyPublishDelay, setup.timeGetter,
+	)
+	expect(err).ToBe(nil)
+
+	keys := keyring.AccessKeys().VerificationKeys()
+	expect(len(keys)).ToBe(1)
+	expect(keys[0].ID()).ToBe(keyring.AccessKeys().SigningKey().ID())
+}
+
+func TestKeyring_HMACKeyIDsComeFromSecrets(t *testing.T) {
+	expect := expectate.Expect(t)
+	setup := setupKeyring(t, "HS256", map[string][]byte{
+		"a.key": []byte("first secret of at least 32 bytes"),
+		"a.pem": []byte("second secret of at least 32 bytes"),
+	})
+
+	keys := setup.keyring.AccessKeys().VerificationKeys()
+	expect(len(keys)).ToBe(2)
+	expect(keys[0].ID() == keys[1].ID()).ToBe(false)
+	expect(keys[0].ID() == "a").ToBe(false)
+}
+
+func TestKeyring_HS256KeepsAccessAndRefreshKeysApart(t *testing.T) {
+	expect := expectate.Expect(t)
+	setup := setupKeyring(t, "HS256", map[string][]byte{
+		"1.key": []byte("only secret of at least 32 bytes"),
+	})
+	tokens := setup.issueTokens(t)
+
+	_, err := setup.verifier.VerifyAccessToken(tokens.AccessToken)
+	expect(err).ToBe(nil)
+	_, err = setup.verifier.VerifyRefreshToken(tokens.AccessToken)
+	expect(err).ToBe(jwtgen.ErrBadSignature)
+	_, err = setup.verifier.VerifyAccessToken(tokens.RefreshToken)
+	expect(err).ToBe(jwtgen.ErrBadSignature)
+}
+
+func TestKeyring_DeletedKeyStopsVerifying(t *testing.T) {
+	expect := expectate.Expect(t)
+	setup := setupKeyring(t, "HS256", map[string][]byte{"1.key": []byte("first secret of at least 32 bytes")})
+	oldTokens := setup.issueTokens(t)
+	expect(getTokenHeader(t, oldTokens.AccessToken)["kid"]).
+		ToBe(setup.keyring.AccessKeys().SigningKey().ID())
+
+	writeKeyFile(t, setup.dir, "2.key", []byte("second secret of at least 32 bytes"), 1000)
+	expect(setup.keyring.Reload()).ToBe(nil)
+	_, err := setup.verifier.VerifyAccessToken(oldTokens.AccessToken)
+	expect(err).ToBe(nil)
+
+	err = os.Remove(filepath.Join(setup.dir, "1.key"))
+	expect(err).ToBe(nil)
+	expect(setup.keyring.Reload()).ToBe(nil)
+	_, err = setup.verifier.VerifyAccessToken(oldTokens.AccessToken)
+	expect(err).ToBe(jwtgen.ErrUnknownKey)
+}
+
+func TestKeyring_KeepsKeysWhenReloadFails(t *testing.T) {
+	expect := expectate.Expect(t)
+	pem := generateECPEMs(t, elliptic.P256()).private
+	setup := setupKeyring(t, "ES256", map[string][]byte{"1.pem": pem})
+
+	writeKeyFile(t, setup.dir, "2.pem", []byte("not a key"), 1000)
+	err := setup.keyring.Reload()
+
+	expect(err == nil).ToBe(false)
+	expect(setup.keyring.AccessKeys().SigningKey().ID()).ToBe(parseKeyID(t, "ES256", pem))
+}
+
+func TestKeyring_SkipsHiddenFilesAndDirectories(t *testing.T) {
+	expect := expectate.Expect(t)
+	dir := t.TempDir()
+	writeKeyFile(t, dir, ".hidden", []byte("not a key"), 1000)
+	err := os.Mkdir(filepath.Join(dir, "..data"), 0700)
+	expect(err).ToBe(nil)
+	writeKeyFile(t, dir, "1.key", []byte("only secret of at least 32 bytes"), 1000)
+
+	keyring, err := jwtgen.NewKeyring(
+		dir, "HS256", time.Hour, testKeyPublishDelay, &MockTimeGetter{Time: 1000},
+	)
+
+	expect(err).ToBe(nil)
+	expect(len(keyring.AccessKeys().VerificationKeys())).ToBe(1)
+}
+
+func TestNewKeyring_RejectsEmptyDirectory(t *testing.T) {
+	expect := expectate.Expect(t)
+
+	_, err := jwtgen.NewKeyring(
+		t.TempDir(), "HS256", time.Hour, testKeyPublishDelay, &MockTimeGetter{Time: 1000},
+	)
+
+	expect(err).ToBe(jwtgen.ErrNoKeys)
+}
+
+func TestNewKeyring_RejectsShortHMACSecret(t *testing.T) {
+	expect := expectate.Expect(t)
+	dir := t.TempDir()
+	writeKeyFile(t, dir, "1.key", []byte("too short"), 1000)
+
+	_, err := jwtgen.NewKeyring(
+		dir, "HS256", time.Hour, testKeyPublishDelay, &MockTimeGetter{Time: 1000},
+	)
+
+	expect(errors.Is(err, jwtgen.ErrInvalidKey)).ToBe(true)
+}
@@ -1,53 +1,130 @@
 package jwtgen
 
-import "github.com/dgrijalva/jwt-go"
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/steve-kaufman/go-auth-service/entities"
+)
+
+// TokenType is written to the token_use claim so that a token can only be
+// accepted as the type it was issued as.
+type TokenType string
+
+const (
+	AccessToken  TokenType = "access"
+	RefreshToken TokenType = "refresh"
+)
+
+var ErrCannotSign = errors.New("key can only verify tokens")
 
 type Token struct {
 	Header map[string]interface{}
 	Claims map[string]interface{}
-	Secret string
+	Key    Key
 }
 
 type TokenSigner struct {
-	secret     string
+	keys       KeySource
+	tokenType  TokenType
+	lifetime   time.Duration
 	timeGetter TimeGetter
+	idGetter   IDGetter
+	issuer     string
+	audience   []string
 }
 
-func NewTokenSigner(secret string, timeGetter TimeGetter) *TokenSigner {
+func NewTokenSigner(
+	keys KeySource, tokenType TokenType, lifetime time.Duration,
+	timeGetter TimeGetter, idGetter IDGetter, issuer string, audience []string,
+) *TokenSigner {
 	signer := new(TokenSigner)
-	signer.secret = secret
+	signer.keys = keys
+	signer.tokenType = tokenType
+	signer.lifetime = lifetime
 	signer.timeGetter = timeGetter
+	signer.idGetter = idGetter
+	signer.issuer = issuer
+	signer.audience = audience
 	return signer
 }
 
-func (signer TokenSigner) GetSignedToken(userID int, username string) (string, error) {
+// GetSignedToken expects subject.Family to already be set.
+func (signer TokenSigner) GetSignedToken(
+	subject entities.TokenSubject,
+) (string, error) {
+	return signer.getSignedTokenWithClaims(subject, nil)
+}
+
+// getSignedTokenWithClaims adds customClaims, which must already have been
+// checked against reservedClaims.
+func (signer TokenSigner) getSignedTokenWithClaims(
+	subject entities.TokenSubject, customClaims map[string]interface{},
+) (string, error) {
+	tokenID, err := signer.idGetter.GetID()
+	if err != nil {
+		return "", err
+	}
+	claims := signer.getClaimsFromUserInfo(tokenID, subject)
+	for name, value := range customClaims {
+		claims[name] = value
+	}
+	key := signer.keys.SigningKey()
 	return signToken(Token{
-		Header: signer.getHeader(),
-		Claims: signer.getClaimsFromUserInfo(userID, username),
-		Secret: signer.secret,
+		Header: getHeader(key),
+		Claims: claims,
+		Key:    key,
 	})
 }
 
-func (TokenSigner) getHeader() map[string]interface{} {
-	return map[string]interface{}{
-		"alg": "HS256",
+func getHeader(key Key) map[string]interface{} {
+	header := map[string]interface{}{
+		"alg": key.Algorithm(),
 		"typ": "JWT",
 	}
+	if key.ID() != "" {
+		header["kid"] = key.ID()
+	}
+	return header
 }
 
 func (signer TokenSigner) getClaimsFromUserInfo(
-	userID int, username string,
+	tokenID string, subject entities.TokenSubject,
 ) map[string]interface{} {
-	return map[string]interface{}{
-		"iat":      signer.timeGetter.GetTime(),
-		"user_id":  userID,
-		"username": username,
+	now := signer.timeGetter.GetTime()
+	claims := map[string]interface{}{
+		"jti":           tokenID,
+		"sub":           strconv.Itoa(subject.UserID),
+		"family":        subject.Family,
+		"iat":           now,
+		"nbf":           now,
+		"exp":           now + signer.lifetime.Seconds(),
+		"token_use":     signer.tokenType,
+		"user_id":       subject.UserID,
+		"username":      subject.Username,
+		"token_version": subject.Version,
 	}
+	if signer.issuer != "" {
+		claims["iss"] = signer.issuer
+	}
+	// A single audience is written as a plain string, which is how most
+	// consumers expect to see it.
+	if len(signer.audience) == 1 {
+		claims["aud"] = signer.audience[0]
+	} else if len(signer.audience) > 1 {
+		claims["aud"] = signer.audience
+	}
+	return claims
 }
 
 func signToken(token Token) (string, error) {
-	jwtObj := jwt.New(jwt.SigningMethodHS256)
+	if token.Key.signKey == nil {
+		return "", ErrCannotSign
+	}
+	jwtObj := jwt.New(token.Key.method)
 	jwtObj.Header = token.Header
 	jwtObj.Claims = jwt.MapClaims(token.Claims)
-	return jwtObj.SignedString([]byte(token.Secret))
+	return jwtObj.SignedString(token.Key.signKey)
 }
@@ -0,0 +1,242 @@
+package jwtgen
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/steve-kaufman/go-auth-service/entities"
+)
+
+var ErrMalformedToken = errors.New("malformed token")
+var ErrBadSignature = errors.New("invalid token signature")
+var ErrExpiredToken = errors.New("token has expired")
+var ErrTokenNotYetValid = errors.New("token is not valid yet")
+var ErrWrongTokenType = errors.New("wrong token type")
+var ErrUnknownKey = errors.New("token signed with unknown key")
+var ErrWrongIssuer = errors.New("token is from another issuer")
+var ErrWrongAudience = errors.New("token is meant for another audience")
+
+var errAlgorithmMismatch = errors.New("token algorithm doesn't match key")
+
+type Verifier struct {
+	accessKeys  KeySource
+	refreshKeys KeySource
+	timeGetter  TimeGetter
+	issuer      string
+	audience    []string
+}
+
+func NewVerifier(config Config, timeGetter TimeGetter) *Verifier {
+	verifier := new(Verifier)
+	verifier.accessKeys, verifier.refreshKeys = config.keySources()
+	verifier.issuer = config.Issuer
+	verifier.audience = config.Audience
+	verifier.timeGetter = timeGetter
+	return verifier
+}
+
+func (verifier Verifier) VerifyAccessToken(token string) (entities.TokenClaims, error) {
+	return verifier.verify(token, verifier.accessKeys.VerificationKeys(), AccessToken)
+}
+
+func (verifier Verifier) VerifyRefreshToken(token string) (entities.TokenClaims, error) {
+	return verifier.verify(token, verifier.refreshKeys.VerificationKeys(), RefreshToken)
+}
+
+// GetPublicKeys returns the asymmetric keys that tokens are verified with,
+// for resource servers to fetch. HMAC secrets are left out.
+func (verifier Verifier) GetPublicKeys() []entities.PublicKey {
+	publicKeys := []entities.PublicKey{}
+	seen := map[string]bool{}
+	keys := append(
+		verifier.accessKeys.VerificationKeys(),
+		verifier.refreshKeys.VerificationKeys()...,
+	)
+	for _, key := range keys {
+		publicKey, ok := key.publicKey()
+		if !ok || seen[publicKey.ID] {
+			continue
+		}
+		seen[publicKey.ID] = true
+		publicKeys = append(publicKeys, publicKey)
+	}
+	return publicKeys
+}
+
+func (verifier Verifier) verify(
+	token string, keys []Key, tokenType TokenType,
+) (entities.TokenClaims, error) {
+	claims, err := parseToken(token, keys)
+	if err != nil {
+		return entities.TokenClaims{}, err
+	}
+	tokenClaims, err := getTokenClaims(claims)
+	if err != nil {
+		return entities.TokenClaims{}, err
+	}
+	if tokenClaims.Type != string(tokenType) {
+		return entities.TokenClaims{}, ErrWrongTokenType
+	}
+	err = verifier.checkIssuerAndAudience(tokenClaims)
+	if err != nil {
+		return entities.TokenClaims{}, err
+	}
+	err = verifier.checkValidityPeriod(tokenClaims)
+	if err != nil {
+		return entities.TokenClaims{}, err
+	}
+	return tokenClaims, nil
+}
+
+// parseToken checks the signature but skips the library's claim validation,
+// which reads the wall clock instead of our TimeGetter.
+func parseToken(token string, keys []Key) (jwt.MapClaims, error) {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(token, claims, func(parsed *jwt.Token) (interface{}, error) {
+		key, err := findKey(parsed, keys)
+		if err != nil {
+			return nil, err
+		}
+		return key.verifyKey, nil
+	})
+	if err != nil {
+		return nil, getParseError(err)
+	}
+	return claims, nil
+}
+
+// findKey picks the key named by the token's kid header, or the signing key if
+// there isn't one. Only the key's own algorithm is accepted, so an RSA public
+// key can never be used as an HMAC secret.
+func findKey(token *jwt.Token, keys []Key) (Key, error) {
+	key := keys[0]
+	if kid, hasKid := token.Header["kid"]; hasKid {
+		var found bool
+		key, found = findKeyByID(kid, keys)
+		if !found {
+			return Key{}, ErrUnknownKey
+		}
+	}
+	if token.Method.Alg() != key.Algorithm() {
+		return Key{}, errAlgorithmMismatch
+	}
+	return key, nil
+}
+
+func findKeyByID(kid interface{}, keys []Key) (Key, bool) {
+	for _, key := range keys {
+		if key.ID() != "" && key.ID() == kid {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+func getParseError(err error) error {
+	var validationErr *jwt.ValidationError
+	if !errors.As(err, &validationErr) {
+		return ErrMalformedToken
+	}
+	if validationErr.Errors&jwt.ValidationErrorMalformed != 0 {
+		return ErrMalformedToken
+	}
+	if validationErr.Inner == ErrUnknownKey {
+		return ErrUnknownKey
+	}
+	return ErrBadSignature
+}
+
+func (verifier Verifier) checkIssuerAndAudience(claims entities.TokenClaims) error {
+	if verifier.issuer != "" && claims.Issuer != verifier.issuer {
+		return ErrWrongIssuer
+	}
+	if len(verifier.audience) == 0 {
+		return nil
+	}
+	for _, audience := range claims.Audience {
+		for _, expected := range verifier.audience {
+			if audience == expected {
+				return nil
+			}
+		}
+	}
+	return ErrWrongAudience
+}
+
+func (verifier Verifier) checkValidityPeriod(claims entities.TokenClaims) error {
+	now := verifier.timeGetter.GetTime()
+	if now < claims.NotBefore {
+		return ErrTokenNotYetValid
+	}
+	if now >= claims.ExpiresAt {
+		return ErrExpiredToken
+	}
+	return nil
+}
+
+func getTokenClaims(claims jwt.MapClaims) (entities.TokenClaims, error) {
+	tokenID, isTokenID := claims["jti"].(string)
+	family, isFamily := claims["family"].(string)
+	userID, isUserID := claims["user_id"].(float64)
+	username, isUsername := claims["username"].(string)
+	version, isVersion := claims["token_version"].(float64)
+	issuedAt, isIssuedAt := claims["iat"].(float64)
+	notBefore, isNotBefore := claims["nbf"].(float64)
+	expiresAt, isExpiresAt := claims["exp"].(float64)
+	tokenType, isTokenType := claims["token_use"].(string)
+	subject, isSubject := claims["sub"].(string)
+	if !isTokenID || !isFamily || !isUserID || !isUsername || !isVersion ||
+		!isIssuedAt || !isNotBefore || !isExpiresAt || !isTokenType || !isSubject {
+		return entities.TokenClaims{}, ErrMalformedToken
+	}
+	if subject != strconv.Itoa(int(userID)) {
+		return entities.TokenClaims{}, ErrMalformedToken
+	}
+	// iss and aud are optional here; the Verifier decides whether it needs
+	// them.
+	issuer, isIssuer := claims["iss"].(string)
+	if _, hasIssuer := claims["iss"]; hasIssuer && !isIssuer {
+		return entities.TokenClaims{}, ErrMalformedToken
+	}
+	audience, err := getAudience(claims)
+	if err != nil {
+		return entities.TokenClaims{}, err
+	}
+	return entities.TokenClaims{
+		ID:        tokenID,
+		Family:    family,
+		Issuer:    issuer,
+		Audience:  audience,
+		UserID:    int(userID),
+		Username:  username,
+		Version:   int(version),
+		Type:      tokenType,
+		IssuedAt:  issuedAt,
+		NotBefore: notBefore,
+		ExpiresAt: expiresAt,
+		Custom:    getCustomClaimsFromToken(claims),
+	}, nil
+}
+
+// getAudience accepts aud as either a single string or a list of them.
+func getAudience(claims jwt.MapClaims) ([]string, error) {
+	switch aud := claims["aud"].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{aud}, nil
+	case []interface{}:
+		audience := make([]string, 0, len(aud))
+		for _, item := range aud {
+			str, ok := item.(string)
+			if !ok {
+				return nil, ErrMalformedToken
+			}
+			audience = append(audience, str)
+		}
+		return audience, nil
+	}
+	return nil, ErrMalformedToken
+}
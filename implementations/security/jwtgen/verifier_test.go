@@ -0,0 +1,454 @@
+package jwtgen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gomagedon/expectate"
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/implementations/security/jwtgen"
+)
+
+var testSecrets = jwtgen.Secrets{
+	Access:  "fake_access_secret",
+	Refresh: "fake_refresh_secret",
+}
+
+func setupVerifierWithTime(time float64) *jwtgen.Verifier {
+	timeGetter := new(MockTimeGetter)
+	timeGetter.Time = time
+
+	return jwtgen.NewVerifier(jwtgen.Config{Secrets: testSecrets}, timeGetter)
+}
+
+func signTestToken(t *testing.T, method jwt.SigningMethod, claims jwt.MapClaims, key interface{}) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerifier_AcceptsGeneratedTokens(t *testing.T) {
+	expect := expectate.Expect(t)
+
+	tokens, err := setupWithTime(42).GetTokens(entities.TokenSubject{UserID: 2, Username: "johndoe"})
+	expect(err).ToBe(nil)
+
+	verifier := setupVerifierWithTime(42)
+
+	claims, err := verifier.VerifyAccessToken(tokens.AccessToken)
+	expect(err).ToBe(nil)
+	expect(claims).ToEqual(entities.TokenClaims{
+		ID:        "id-2",
+		Family:    "id-1",
+		UserID:    2,
+		Username:  "johndoe",
+		Type:      "access",
+		IssuedAt:  42,
+		NotBefore: 42,
+		ExpiresAt: 42 + 15*60,
+	})
+
+	claims, err = verifier.VerifyRefreshToken(tokens.RefreshToken)
+	expect(err).ToBe(nil)
+	expect(claims).ToEqual(entities.TokenClaims{
+		ID:        "id-3",
+		Family:    "id-1",
+		UserID:    2,
+		Username:  "johndoe",
+		Type:      "refresh",
+		IssuedAt:  42,
+		NotBefore: 42,
+		ExpiresAt: 42 + 30*24*60*60,
+	})
+}
+
+func TestVerifier_RejectsTokenOfOtherType(t *testing.T) {
+	expect := expectate.Expect(t)
+
+	tokens, err := setupWithTime(42).GetTokens(entities.TokenSubject{UserID: 2, Username: "johndoe"})
+	expect(err).ToBe(nil)
+
+	verifier := setupVerifierWithTime(42)
+
+	_, err = verifier.VerifyAccessToken(tokens.RefreshToken)
+	expect(err).ToBe(jwtgen.ErrBadSignature)
+
+	_, err = verifier.VerifyRefreshToken(tokens.AccessToken)
+	expect(err).ToBe(jwtgen.ErrBadSignature)
+}
+
+func TestVerifier_RejectsTokenOfOtherType_WithSharedSecret(t *testing.T) {
+	expect := expectate.Expect(t)
+
+	timeGetter := &MockTimeGetter{Time: 42}
+	config := jwtgen.Config{
+		Secrets: jwtgen.Secrets{Access: "shared", Refresh: "shared"},
+	}
+
+	generator := jwtgen.NewGenerator(config, timeGetter, new(MockIDGetter))
+	tokens, err := generator.GetTokens(entities.TokenSubject{UserID: 2, Username: "johndoe"})
+	expect(err).ToBe(nil)
+
+	verifier := jwtgen.NewVerifier(config, timeGetter)
+
+	_, err = verifier.VerifyAccessToken(tokens.RefreshToken)
+	expect(err).ToBe(jwtgen.ErrWrongTokenType)
+
+	_, err = verifier.VerifyRefreshToken(tokens.AccessToken)
+	expect(err).ToBe(jwtgen.ErrWrongTokenType)
+}
+
+func TestVerifier_DoesNotPublishSecrets(t *testing.T) {
+	expect := expectate.Expect(t)
+
+	verifier := setupVerifierWithTime(100)
+
+	expect(len(verifier.GetPublicKeys())).ToBe(0)
+}
+
+type VerifierTest struct {
+	name string
+
+	token       func(t *testing.T) string
+	expectedErr error
+}
+
+// claimsWith returns a valid set of access token claims for time 100 with
+// the given claims replaced. A nil value removes the claim.
+func claimsWith(overrides jwt.MapClaims) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"jti":           "id-2",
+		"sub":           "2",
+		"family":        "id-1",
+		"iat":           42,
+		"nbf":           42,
+		"exp":           142,
+		"token_use":     "access",
+		"user_id":       2,
+		"username":      "johndoe",
+		"token_version": 0,
+	}
+	for key, value := range overrides {
+		if value == nil {
+			delete(claims, key)
+			continue
+		}
+		claims[key] = value
+	}
+	return claims
+}
+
+var validClaims = claimsWith(nil)
+
+var verifierTests = []VerifierTest{
+	{
+		name: "Rejects garbage",
+
+		token:       func(*testing.T) string { return "not a token" },
+		expectedErr: jwtgen.ErrMalformedToken,
+	},
+	{
+		name: "Rejects empty string",
+
+		token:       func(*testing.T) string { return "" },
+		expectedErr: jwtgen.ErrMalformedToken,
+	},
+	{
+		name: "Rejects tampered payload",
+
+		token: func(t *testing.T) string {
+			token := signTestToken(t, jwt.SigningMethodHS256, validClaims,
+				[]byte(testSecrets.Access))
+			forged := signTestToken(t, jwt.SigningMethodHS256, claimsWith(jwt.MapClaims{
+				"user_id":  1,
+				"username": "admin",
+			}), []byte("attacker_secret"))
+			parts := strings.Split(token, ".")
+			forgedParts := strings.Split(forged, ".")
+			return parts[0] + "." + forgedParts[1] + "." + parts[2]
+		},
+		expectedErr: jwtgen.ErrBadSignature,
+	},
+	{
+		name: "Rejects wrong secret",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodHS256, validClaims,
+				[]byte("wrong_secret"))
+		},
+		expectedErr: jwtgen.ErrBadSignature,
+	},
+	{
+		name: "Rejects other HMAC algorithm",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodHS512, validClaims,
+				[]byte(testSecrets.Access))
+		},
+		expectedErr: jwtgen.ErrBadSignature,
+	},
+	{
+		name: "Rejects unsigned token",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodNone, validClaims,
+				jwt.UnsafeAllowNoneSignatureType)
+		},
+		expectedErr: jwtgen.ErrBadSignature,
+	},
+	{
+		name: "Rejects expired token",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodHS256, claimsWith(jwt.MapClaims{
+				"exp": 100,
+			}), []byte(testSecrets.Access))
+		},
+		expectedErr: jwtgen.ErrExpiredToken,
+	},
+	{
+		name: "Rejects token used before nbf",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodHS256, claimsWith(jwt.MapClaims{
+				"nbf": 101,
+			}), []byte(testSecrets.Access))
+		},
+		expectedErr: jwtgen.ErrTokenNotYetValid,
+	},
+	{
+		name: "Rejects token without exp",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodHS256, claimsWith(jwt.MapClaims{
+				"exp": nil,
+			}), []byte(testSecrets.Access))
+		},
+		expectedErr: jwtgen.ErrMalformedToken,
+	},
+	{
+		name: "Rejects token without token_use",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodHS256, claimsWith(jwt.MapClaims{
+				"token_use": nil,
+			}), []byte(testSecrets.Access))
+		},
+		expectedErr: jwtgen.ErrMalformedToken,
+	},
+	{
+		name: "Rejects refresh token_use",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodHS256, claimsWith(jwt.MapClaims{
+				"token_use": "refresh",
+			}), []byte(testSecrets.Access))
+		},
+		expectedErr: jwtgen.ErrWrongTokenType,
+	},
+	{
+		name: "Rejects token without jti or family",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodHS256, claimsWith(jwt.MapClaims{
+				"jti":    nil,
+				"family": nil,
+			}), []byte(testSecrets.Access))
+		},
+		expectedErr: jwtgen.ErrMalformedToken,
+	},
+	{
+		name: "Rejects token missing user claims",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodHS256, claimsWith(jwt.MapClaims{
+				"user_id":  nil,
+				"username": nil,
+			}), []byte(testSecrets.Access))
+		},
+		expectedErr: jwtgen.ErrMalformedToken,
+	},
+	{
+		name: "Rejects token without sub",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodHS256, claimsWith(jwt.MapClaims{
+				"sub": nil,
+			}), []byte(testSecrets.Access))
+		},
+		expectedErr: jwtgen.ErrMalformedToken,
+	},
+	{
+		name: "Rejects sub that doesn't match user_id",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodHS256, claimsWith(jwt.MapClaims{
+				"sub": "1",
+			}), []byte(testSecrets.Access))
+		},
+		expectedErr: jwtgen.ErrMalformedToken,
+	},
+	{
+		name: "Rejects non-string aud",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodHS256, claimsWith(jwt.MapClaims{
+				"aud": []interface{}{"api", 7},
+			}), []byte(testSecrets.Access))
+		},
+		expectedErr: jwtgen.ErrMalformedToken,
+	},
+	{
+		name: "Accepts token with iss and aud when none are expected",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodHS256, claimsWith(jwt.MapClaims{
+				"iss": "https://auth.example.com",
+				"aud": "api",
+			}), []byte(testSecrets.Access))
+		},
+		expectedErr: nil,
+	},
+	{
+		name: "Accepts token that hasn't expired yet",
+
+		token: func(t *testing.T) string {
+			return signTestToken(t, jwt.SigningMethodHS256, claimsWith(jwt.MapClaims{
+				"exp": 101,
+			}), []byte(testSecrets.Access))
+		},
+		expectedErr: nil,
+	},
+}
+
+func TestVerifier_VerifyAccessToken(t *testing.T) {
+	for _, tc := range verifierTests {
+		t.Run(tc.name, func(t *testing.T) {
+			expect := expectate.Expect(t)
+
+			verifier := setupVerifierWithTime(100)
+
+			_, err := verifier.VerifyAccessToken(tc.token(t))
+			expect(err).ToBe(tc.expectedErr)
+		})
+	}
+}
+
+type IssuerAudienceTest struct {
+	name string
+
+	tokenIssuer      string
+	tokenAudience    []string
+	expectedIssuer   string
+	expectedAudience []string
+	expectedErr      error
+}
+
+var issuerAudienceTests = []IssuerAudienceTest{
+	{
+		name: "Accepts same issuer and audience",
+
+		tokenIssuer:      "https://auth.staging.example.com",
+		tokenAudience:    []string{"api", "admin"},
+		expectedIssuer:   "https://auth.staging.example.com",
+		expectedAudience: []string{"api"},
+		expectedErr:      nil,
+	},
+	{
+		name: "Accepts any shared audience",
+
+		tokenIssuer:      "https://auth.staging.example.com",
+		tokenAudience:    []string{"api", "admin"},
+		expectedIssuer:   "https://auth.staging.example.com",
+		expectedAudience: []string{"billing", "admin"},
+		expectedErr:      nil,
+	},
+	{
+		name: "Rejects other issuer",
+
+		tokenIssuer:      "https://auth.staging.example.com",
+		tokenAudience:    []string{"api", "admin"},
+		expectedIssuer:   "https://auth.example.com",
+		expectedAudience: []string{"api"},
+		expectedErr:      jwtgen.ErrWrongIssuer,
+	},
+	{
+		name: "Rejects other audience",
+
+		tokenIssuer:      "https://auth.staging.example.com",
+		tokenAudience:    []string{"api", "admin"},
+		expectedIssuer:   "https://auth.staging.example.com",
+		expectedAudience: []string{"reports"},
+		expectedErr:      jwtgen.ErrWrongAudience,
+	},
+	{
+		name: "Rejects token without issuer",
+
+		expectedIssuer: "https://auth.staging.example.com",
+		expectedErr:    jwtgen.ErrWrongIssuer,
+	},
+	{
+		name: "Rejects token without audience",
+
+		tokenIssuer:      "https://auth.staging.example.com",
+		expectedIssuer:   "https://auth.staging.example.com",
+		expectedAudience: []string{"api"},
+		expectedErr:      jwtgen.ErrWrongAudience,
+	},
+}
+
+func TestVerifier_ChecksIssuerAndAudience(t *testing.T) {
+	for _, tc := range issuerAudienceTests {
+		t.Run(tc.name, func(t *testing.T) {
+			expect := expectate.Expect(t)
+			timeGetter := &MockTimeGetter{Time: 42}
+
+			generator := jwtgen.NewGenerator(jwtgen.Config{
+				Secrets:  testSecrets,
+				Issuer:   tc.tokenIssuer,
+				Audience: tc.tokenAudience,
+			}, timeGetter, new(MockIDGetter))
+			tokens, err := generator.GetTokens(entities.TokenSubject{
+				UserID: 2, Username: "johndoe",
+			})
+			expect(err).ToBe(nil)
+
+			verifier := jwtgen.NewVerifier(jwtgen.Config{
+				Secrets:  testSecrets,
+				Issuer:   tc.expectedIssuer,
+				Audience: tc.expectedAudience,
+			}, timeGetter)
+
+			claims, err := verifier.VerifyAccessToken(tokens.AccessToken)
+			expect(err).ToBe(tc.expectedErr)
+			if err != nil {
+				return
+			}
+			expect(claims.Issuer).ToBe(tc.tokenIssuer)
+			expect(len(claims.Audience)).ToBe(len(tc.tokenAudience))
+		})
+	}
+}
+
+func TestJWTGenerator_WritesSingleAudienceAsString(t *testing.T) {
+	expect := expectate.Expect(t)
+	timeGetter := &MockTimeGetter{Time: 42}
+	config := jwtgen.Config{Secrets: testSecrets, Audience: []string{"api"}}
+
+	tokens, err := jwtgen.NewGenerator(config, timeGetter, new(MockIDGetter)).
+		GetTokens(entities.TokenSubject{UserID: 2, Username: "johndoe"})
+	expect(err).ToBe(nil)
+
+	claims := jwt.MapClaims{}
+	_, _, err = new(jwt.Parser).ParseUnverified(tokens.AccessToken, claims)
+	expect(err).ToBe(nil)
+	expect(claims["aud"]).ToBe("api")
+	expect(claims["sub"]).ToBe("2")
+
+	verified, err := jwtgen.NewVerifier(config, timeGetter).VerifyAccessToken(tokens.AccessToken)
+	expect(err).ToBe(nil)
+	expect(verified.Audience[0]).ToBe("api")
+}
@@ -0,0 +1,70 @@
+package security
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Limits on the parameters read back from a stored hash, as for Argon2id.
+// Scrypt needs 128·r·N bytes, so r·N is capped to keep that within
+// maxArgon2Memory.
+const (
+	maxScryptLogN       = 20
+	maxScryptR          = 32
+	maxScryptP          = 16
+	maxScryptRN         = maxArgon2Memory * 1024 / 128
+	maxPBKDF2Iterations = 5000000
+	pbkdf2SHA256Prefix  = "$pbkdf2-sha256$"
+	pbkdf2SHA512Prefix  = "$pbkdf2-sha512$"
+	scryptPrefix        = "$scrypt$"
+)
+
+// matchScrypt checks hashes imported from other systems, in the form
+// $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<key>. They can only be matched, so
+// users are moved to the preferred hasher when they next log in.
+func matchScrypt(plainPass string, hashedPass string) (bool, error) {
+	phc, err := parsePHCHash(hashedPass)
+	if err != nil || phc.id != "scrypt" || phc.hasVersion ||
+		!phc.hasParams("ln", "r", "p") {
+		return false, ErrMalformedHash
+	}
+	logN, r, p := phc.params["ln"], phc.params["r"], phc.params["p"]
+	if logN > maxScryptLogN || r > maxScryptR || p > maxScryptP ||
+		r<<logN > maxScryptRN {
+		return false, ErrMalformedHash
+	}
+	key, err := scrypt.Key([]byte(plainPass), phc.salt, 1<<logN, r, p, len(phc.key))
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+	return subtle.ConstantTimeCompare(key, phc.key) == 1, nil
+}
+
+// matchPBKDF2 checks imported hashes in the form
+// $pbkdf2-<sha256|sha512>$i=<iterations>$<salt>$<key>.
+func matchPBKDF2(plainPass string, hashedPass string) (bool, error) {
+	phc, err := parsePHCHash(hashedPass)
+	if err != nil || phc.hasVersion || !phc.hasParams("i") {
+		return false, ErrMalformedHash
+	}
+	var newHash func() hash.Hash
+	switch phc.id {
+	case "pbkdf2-sha256":
+		newHash = sha256.New
+	case "pbkdf2-sha512":
+		newHash = sha512.New
+	default:
+		return false, ErrMalformedHash
+	}
+	iterations := phc.params["i"]
+	if iterations > maxPBKDF2Iterations {
+		return false, ErrMalformedHash
+	}
+	key := pbkdf2.Key([]byte(plainPass), phc.salt, iterations, len(phc.key), newHash)
+	return subtle.ConstantTimeCompare(key, phc.key) == 1, nil
+}
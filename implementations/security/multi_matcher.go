@@ -0,0 +1,46 @@
+package security
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrUnknownHashAlgorithm = errors.New("password hash algorithm not recognized")
+
+// MultiMatcher matches passwords against a hash from any supported
+// algorithm, told apart by the hash's prefix. It lets the preferred hasher
+// change without locking out users whose hashes were made by an older one.
+type MultiMatcher struct{}
+
+var hashMatchers = []struct {
+	prefixes []string
+	match    func(plainPass string, hashedPass string) (bool, error)
+}{
+	{
+		prefixes: []string{"$2a$", "$2b$", "$2y$"},
+		match:    BcryptHasher{}.MatchPassword,
+	},
+	{
+		prefixes: []string{"$argon2id$"},
+		match:    Argon2idHasher{}.MatchPassword,
+	},
+	{
+		prefixes: []string{scryptPrefix},
+		match:    matchScrypt,
+	},
+	{
+		prefixes: []string{pbkdf2SHA256Prefix, pbkdf2SHA512Prefix},
+		match:    matchPBKDF2,
+	},
+}
+
+func (MultiMatcher) MatchPassword(plainPass string, hashedPass string) (bool, error) {
+	for _, matcher := range hashMatchers {
+		for _, prefix := range matcher.prefixes {
+			if strings.HasPrefix(hashedPass, prefix) {
+				return matcher.match(plainPass, hashedPass)
+			}
+		}
+	}
+	return false, ErrUnknownHashAlgorithm
+}
@@ -0,0 +1,230 @@
+package security_test
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"testing"
+
+	"github.com/steve-kaufman/go-auth-service/implementations/security"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+var testSalt = []byte("0123456789abcdef")
+
+func encode(value []byte) string {
+	return base64.RawStdEncoding.EncodeToString(value)
+}
+
+func makeBcryptHash(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func makeScryptHash(t *testing.T, password string) string {
+	key, err := scrypt.Key([]byte(password), testSalt, 1<<10, 8, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("$scrypt$ln=10,r=8,p=1$%s$%s", encode(testSalt), encode(key))
+}
+
+func makePBKDF2Hash(name string, newHash func() hash.Hash, password string) string {
+	key := pbkdf2.Key([]byte(password), testSalt, 1000, 32, newHash)
+	return fmt.Sprintf("$pbkdf2-%s$i=1000$%s$%s", name, encode(testSalt), encode(key))
+}
+
+type MultiMatcherTest struct {
+	name string
+
+	hash func(t *testing.T, password string) string
+}
+
+var multiMatcherTests = []MultiMatcherTest{
+	{
+		name: "bcrypt",
+		hash: makeBcryptHash,
+	},
+	{
+		name: "argon2id",
+		hash: hashWithTestParams,
+	},
+	{
+		name: "scrypt",
+		hash: makeScryptHash,
+	},
+	{
+		name: "pbkdf2-sha256",
+		hash: func(t *testing.T, password string) string {
+			return makePBKDF2Hash("sha256", sha256.New, password)
+		},
+	},
+	{
+		name: "pbkdf2-sha512",
+		hash: func(t *testing.T, password string) string {
+			return makePBKDF2Hash("sha512", sha512.New, password)
+		},
+	},
+}
+
+func TestMultiMatcher_MatchesEachAlgorithm(t *testing.T) {
+	matcher := new(security.MultiMatcher)
+
+	for _, tc := range multiMatcherTests {
+		t.Run(tc.name, func(t *testing.T) {
+			hash := tc.hash(t, "supersecret")
+
+			matches, err := matcher.MatchPassword("supersecret", hash)
+			if err != nil || !matches {
+				t.Fatalf("Expected match; Got: %v, '%v'", matches, err)
+			}
+
+			matches, err = matcher.MatchPassword("wrongpass", hash)
+			if err != nil || matches {
+				t.Fatalf("Expected mismatch without error; Got: %v, '%v'", matches, err)
+			}
+		})
+	}
+}
+
+var unmatchableHashes = map[string]error{
+	"":                           security.ErrUnknownHashAlgorithm,
+	"supersecret":                security.ErrUnknownHashAlgorithm,
+	"$1$saltsalt$md5cryptedpass": security.ErrUnknownHashAlgorithm,
+	"$2a$04$tooshort":            bcrypt.ErrHashTooShort,
+	"$scrypt$ln=30,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$MDEyMzQ1Njc4OWFiY2RlZg":      security.ErrMalformedHash,
+	"$scrypt$ln=10,r=8$MDEyMzQ1Njc4OWFiY2RlZg$MDEyMzQ1Njc4OWFiY2RlZg":          security.ErrMalformedHash,
+	"$scrypt$ln=20,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$MDEyMzQ1Njc4OWFiY2RlZg":      security.ErrMalformedHash,
+	"$pbkdf2-sha256$i=99999999$MDEyMzQ1Njc4OWFiY2RlZg$MDEyMzQ1Njc4OWFiY2RlZg":  security.ErrMalformedHash,
+	"$pbkdf2-sha256$i=1000$c2FsdA$MDEyMzQ1Njc4OWFiY2RlZg":                      security.ErrMalformedHash,
+	"$pbkdf2-sha256$i=1000,l=16$MDEyMzQ1Njc4OWFiY2RlZg$MDEyMzQ1Njc4OWFiY2RlZg": security.ErrMalformedHash,
+	"$pbkdf2-sha1$i=1000$MDEyMzQ1Njc4OWFiY2RlZg$MDEyMzQ1Njc4OWFiY2RlZg":        security.ErrUnknownHashAlgorithm,
+}
+
+func TestMultiMatcher_RejectsUnmatchableHashes(t *testing.T) {
+	matcher := new(security.MultiMatcher)
+
+	for hash, expectedErr := range unmatchableHashes {
+		t.Run(hash, func(t *testing.T) {
+			matches, err := matcher.MatchPassword("supersecret", hash)
+			if err != expectedErr {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", expectedErr, err)
+			}
+			if matches {
+				t.Fatal("Expected no match")
+			}
+		})
+	}
+}
+
+func TestBcryptHasher_MismatchIsNotAnError(t *testing.T) {
+	hasher := security.BcryptHasher{Cost: bcrypt.MinCost}
+	hash, err := hasher.HashPassword("supersecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := hasher.MatchPassword("wrongpass", hash)
+	if err != nil || matches {
+		t.Fatalf("Expected mismatch without error; Got: %v, '%v'", matches, err)
+	}
+}
+
+type NeedsRehashTest struct {
+	name string
+
+	hasher   interfaces.PasswordRehasher
+	hash     func(t *testing.T) string
+	expected bool
+}
+
+var needsRehashTests = []NeedsRehashTest{
+	{
+		name: "bcrypt hash at current cost",
+
+		hasher:   security.BcryptHasher{Cost: bcrypt.MinCost},
+		hash:     func(t *testing.T) string { return makeBcryptHash(t, "pass") },
+		expected: false,
+	},
+	{
+		name: "bcrypt hash below current cost",
+
+		hasher:   security.BcryptHasher{Cost: bcrypt.MinCost + 1},
+		hash:     func(t *testing.T) string { return makeBcryptHash(t, "pass") },
+		expected: true,
+	},
+	{
+		name: "bcrypt hash below current cost but at minimum",
+
+		hasher: security.BcryptHasher{
+			Cost: bcrypt.MinCost + 2, MinCost: bcrypt.MinCost,
+		},
+		hash:     func(t *testing.T) string { return makeBcryptHash(t, "pass") },
+		expected: false,
+	},
+	{
+		name: "bcrypt hash below minimum cost",
+
+		hasher: security.BcryptHasher{
+			Cost: bcrypt.MinCost + 2, MinCost: bcrypt.MinCost + 1,
+		},
+		hash:     func(t *testing.T) string { return makeBcryptHash(t, "pass") },
+		expected: true,
+	},
+	{
+		name: "argon2id hash for bcrypt hasher",
+
+		hasher:   security.BcryptHasher{Cost: bcrypt.MinCost},
+		hash:     func(t *testing.T) string { return hashWithTestParams(t, "pass") },
+		expected: true,
+	},
+	{
+		name: "argon2id hash with current params",
+
+		hasher:   testArgon2Hasher,
+		hash:     func(t *testing.T) string { return hashWithTestParams(t, "pass") },
+		expected: false,
+	},
+	{
+		name: "argon2id hash with less memory",
+
+		hasher: security.Argon2idHasher{Params: security.Argon2idParams{
+			Memory: 128, Iterations: 1, Parallelism: 1,
+		}},
+		hash:     func(t *testing.T) string { return hashWithTestParams(t, "pass") },
+		expected: true,
+	},
+	{
+		name: "bcrypt hash for argon2id hasher",
+
+		hasher:   testArgon2Hasher,
+		hash:     func(t *testing.T) string { return makeBcryptHash(t, "pass") },
+		expected: true,
+	},
+	{
+		name: "scrypt hash for argon2id hasher",
+
+		hasher:   testArgon2Hasher,
+		hash:     func(t *testing.T) string { return makeScryptHash(t, "pass") },
+		expected: true,
+	},
+}
+
+func TestNeedsRehash(t *testing.T) {
+	for _, tc := range needsRehashTests {
+		t.Run(tc.name, func(t *testing.T) {
+			needsRehash := tc.hasher.NeedsRehash(tc.hash(t))
+			if needsRehash != tc.expected {
+				t.Fatalf("Expected NeedsRehash: %v; Got: %v", tc.expected, needsRehash)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+const oneTimeTokenBytes = 32
+
+// OneTimeTokens makes 256 bit random tokens and hashes them with SHA-256
+// for storage. Unlike a password hash this needn't be slow, since a random
+// token can't be guessed from a dictionary.
+type OneTimeTokens struct{}
+
+func (tokens OneTimeTokens) NewToken() (string, string, error) {
+	random := make([]byte, oneTimeTokenBytes)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+	return token, tokens.HashToken(token), nil
+}
+
+func (OneTimeTokens) HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,38 @@
+package security_test
+
+import (
+	"testing"
+
+	"github.com/steve-kaufman/go-auth-service/implementations/security"
+)
+
+func TestOneTimeTokens_HashMatchesToken(t *testing.T) {
+	tokens := new(security.OneTimeTokens)
+
+	token, hash, err := tokens.NewToken()
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	if hash == token {
+		t.Fatal("Expected hash to differ from token")
+	}
+	if tokens.HashToken(token) != hash {
+		t.Fatalf("Expected HashToken to give: '%s'; Got: '%s'", hash, tokens.HashToken(token))
+	}
+}
+
+func TestOneTimeTokens_AreUnique(t *testing.T) {
+	tokens := new(security.OneTimeTokens)
+	seen := map[string]bool{}
+
+	for i := 0; i < 100; i++ {
+		token, _, err := tokens.NewToken()
+		if err != nil {
+			t.Fatalf("Expected no error; Got: '%v'", err)
+		}
+		if seen[token] {
+			t.Fatalf("Expected unique tokens; Got '%s' twice", token)
+		}
+		seen[token] = true
+	}
+}
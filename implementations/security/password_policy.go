@@ -0,0 +1,126 @@
+package security
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BcryptMaxPasswordBytes is as much of a password as bcrypt uses. The rest
+// is silently ignored.
+const BcryptMaxPasswordBytes = 72
+
+type CharClass string
+
+const (
+	Lowercase CharClass = "lower"
+	Uppercase CharClass = "upper"
+	Digit     CharClass = "digit"
+	Symbol    CharClass = "symbol"
+)
+
+var charClassChecks = map[CharClass]func(r rune) bool{
+	Lowercase: unicode.IsLower,
+	Uppercase: unicode.IsUpper,
+	Digit:     unicode.IsDigit,
+	Symbol: func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSymbol(r)
+	},
+}
+
+var charClassNames = map[CharClass]string{
+	Lowercase: "a lowercase letter",
+	Uppercase: "an uppercase letter",
+	Digit:     "a digit",
+	Symbol:    "a symbol",
+}
+
+// IsCharClass reports whether class is one PasswordPolicy can require.
+func IsCharClass(class CharClass) bool {
+	_, ok := charClassChecks[class]
+	return ok
+}
+
+// PasswordPolicy implements interfaces.PasswordPolicy. Lengths are counted in
+// characters, so that a password of accented letters isn't held to a
+// stricter limit than one of ASCII, while MaxBytes bounds what the hasher
+// is given. Zero limits aren't enforced.
+type PasswordPolicy struct {
+	MinLength       int
+	MaxLength       int
+	MaxBytes        int
+	RequiredClasses []CharClass
+	Denylist        Denylist
+	// RejectUsername stops the password containing the username, ignoring
+	// case.
+	RejectUsername bool
+}
+
+func (policy PasswordPolicy) CheckPassword(username string, password string) []string {
+	if !utf8.ValidString(password) {
+		return []string{"must be valid UTF-8"}
+	}
+
+	var violations []string
+	length := utf8.RuneCountInString(password)
+	if length < policy.MinLength {
+		violations = append(violations, fmt.Sprintf(
+			"must be at least %d characters", policy.MinLength,
+		))
+	}
+	if policy.MaxLength > 0 && length > policy.MaxLength {
+		violations = append(violations, fmt.Sprintf(
+			"must be at most %d characters", policy.MaxLength,
+		))
+	}
+	if policy.MaxBytes > 0 && len(password) > policy.MaxBytes {
+		violations = append(violations, fmt.Sprintf(
+			"must be at most %d bytes", policy.MaxBytes,
+		))
+	}
+	for _, class := range policy.RequiredClasses {
+		if strings.IndexFunc(password, charClassChecks[class]) < 0 {
+			violations = append(violations, "must contain "+charClassNames[class])
+		}
+	}
+	if policy.Denylist.Contains(password) {
+		violations = append(violations, "is too common")
+	}
+	if policy.RejectUsername && username != "" &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		violations = append(violations, "must not contain the username")
+	}
+	return violations
+}
+
+// Denylist holds common passwords, lowercased so that changing the case of a
+// common password doesn't get it past the list.
+type Denylist map[string]bool
+
+// LoadDenylist reads one password per line, skipping blank lines and lines
+// starting with #.
+func LoadDenylist(path string) (Denylist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	denylist := Denylist{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		denylist[strings.ToLower(line)] = true
+	}
+	return denylist, scanner.Err()
+}
+
+func (denylist Denylist) Contains(password string) bool {
+	return denylist[strings.ToLower(password)]
+}
@@ -0,0 +1,164 @@
+package security_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/steve-kaufman/go-auth-service/implementations/security"
+)
+
+var testPolicy = security.PasswordPolicy{
+	MinLength: 8,
+	MaxLength: 16,
+	MaxBytes:  20,
+	RequiredClasses: []security.CharClass{
+		security.Lowercase, security.Digit,
+	},
+	Denylist:       security.Denylist{"password1": true},
+	RejectUsername: true,
+}
+
+type PasswordPolicyTest struct {
+	name string
+
+	policy   security.PasswordPolicy
+	username string
+	password string
+
+	expectedViolations []string
+}
+
+var passwordPolicyTests = []PasswordPolicyTest{
+	{
+		name: "Accepts password meeting every rule",
+
+		policy:   testPolicy,
+		username: "johndoe",
+		password: "correct horse 9",
+
+		expectedViolations: nil,
+	},
+	{
+		name: "Rejects empty password with every failed rule",
+
+		policy:   testPolicy,
+		username: "johndoe",
+		password: "",
+
+		expectedViolations: []string{
+			"must be at least 8 characters",
+			"must contain a lowercase letter",
+			"must contain a digit",
+		},
+	},
+	{
+		name: "Counts characters rather than bytes for minimum length",
+
+		policy:   security.PasswordPolicy{MinLength: 8},
+		username: "johndoe",
+		password: "ééééééé",
+
+		expectedViolations: []string{"must be at least 8 characters"},
+	},
+	{
+		name: "Counts characters for maximum length",
+
+		policy:   testPolicy,
+		username: "johndoe",
+		password: "aaaaaaaaaaaaaaa1x",
+
+		expectedViolations: []string{"must be at most 16 characters"},
+	},
+	{
+		name: "Counts bytes for maximum bytes",
+
+		policy:   testPolicy,
+		username: "johndoe",
+		password: "éééééééééé1",
+
+		expectedViolations: []string{"must be at most 20 bytes"},
+	},
+	{
+		name: "Counts non-ASCII letters towards character classes",
+
+		policy: security.PasswordPolicy{RequiredClasses: []security.CharClass{
+			security.Lowercase, security.Uppercase, security.Symbol,
+		}},
+		username: "johndoe",
+		password: "ÉCOLE_été",
+
+		expectedViolations: nil,
+	},
+	{
+		name: "Rejects denylisted password ignoring case",
+
+		policy:   testPolicy,
+		username: "johndoe",
+		password: "PassWord1",
+
+		expectedViolations: []string{"is too common"},
+	},
+	{
+		name: "Rejects password containing username ignoring case",
+
+		policy:   testPolicy,
+		username: "JohnDoe",
+		password: "johndoe1234",
+
+		expectedViolations: []string{"must not contain the username"},
+	},
+	{
+		name: "Allows username without RejectUsername",
+
+		policy:   security.PasswordPolicy{MinLength: 8},
+		username: "johndoe",
+		password: "johndoe1234",
+
+		expectedViolations: nil,
+	},
+	{
+		name: "Rejects invalid UTF-8",
+
+		policy:   testPolicy,
+		username: "johndoe",
+		password: "abcdefg1\xff",
+
+		expectedViolations: []string{"must be valid UTF-8"},
+	},
+}
+
+func TestPasswordPolicy_CheckPassword(t *testing.T) {
+	for _, tc := range passwordPolicyTests {
+		t.Run(tc.name, func(t *testing.T) {
+			violations := tc.policy.CheckPassword(tc.username, tc.password)
+
+			if diff := cmp.Diff(tc.expectedViolations, violations); diff != "" {
+				t.Fatalf("Expected violations to match: \n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadDenylist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	contents := "# common passwords\n123456\n\n  Qwerty  \npassword\n"
+	err := ioutil.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	denylist, err := security.LoadDenylist(path)
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	expected := security.Denylist{"123456": true, "qwerty": true, "password": true}
+	if diff := cmp.Diff(expected, denylist); diff != "" {
+		t.Fatalf("Expected denylist to match: \n%s", diff)
+	}
+	if !denylist.Contains("QWERTY") {
+		t.Fatal("Expected denylist to ignore case")
+	}
+}
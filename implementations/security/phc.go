@@ -0,0 +1,87 @@
+package security
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// Hashes with a shorter salt or key than this are treated as tampered with.
+const (
+	minPHCSaltLength = 8
+	minPHCKeyLength  = 16
+)
+
+// phcHash is a hash in the PHC string format:
+// $<id>[$v=<version>]$<param>=<value>[,...]$<salt>$<key>, with the salt and
+// key in unpadded standard base64.
+type phcHash struct {
+	id         string
+	version    int
+	hasVersion bool
+	params     map[string]int
+	salt       []byte
+	key        []byte
+}
+
+func parsePHCHash(hashedPass string) (phcHash, error) {
+	// The leading $ leaves an empty first field.
+	fields := strings.Split(hashedPass, "$")
+	if len(fields) == 6 && strings.HasPrefix(fields[2], "v=") {
+		version, err := strconv.Atoi(strings.TrimPrefix(fields[2], "v="))
+		if err != nil {
+			return phcHash{}, ErrMalformedHash
+		}
+		hash, err := parsePHCFields(append(fields[:2:2], fields[3:]...))
+		hash.version = version
+		hash.hasVersion = true
+		return hash, err
+	}
+	return parsePHCFields(fields)
+}
+
+func parsePHCFields(fields []string) (phcHash, error) {
+	if len(fields) != 5 || fields[0] != "" || fields[1] == "" {
+		return phcHash{}, ErrMalformedHash
+	}
+	hash := phcHash{id: fields[1], params: map[string]int{}}
+	for _, param := range strings.Split(fields[2], ",") {
+		parts := strings.SplitN(param, "=", 2)
+		if len(parts) != 2 {
+			return phcHash{}, ErrMalformedHash
+		}
+		value, err := strconv.Atoi(parts[1])
+		if err != nil || value <= 0 {
+			return phcHash{}, ErrMalformedHash
+		}
+		hash.params[parts[0]] = value
+	}
+
+	var err error
+	hash.salt, err = base64.RawStdEncoding.Strict().DecodeString(fields[3])
+	if err != nil || len(hash.salt) < minPHCSaltLength {
+		return phcHash{}, ErrMalformedHash
+	}
+	hash.key, err = base64.RawStdEncoding.Strict().DecodeString(fields[4])
+	if err != nil || len(hash.key) < minPHCKeyLength {
+		return phcHash{}, ErrMalformedHash
+	}
+	return hash, nil
+}
+
+// hasParams reports whether the hash has exactly the named parameters.
+func (hash phcHash) hasParams(names ...string) bool {
+	if len(hash.params) != len(names) {
+		return false
+	}
+	for _, name := range names {
+		if _, ok := hash.params[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func encodePHCBytes(value []byte) string {
+	return base64.RawStdEncoding.EncodeToString(value)
+}
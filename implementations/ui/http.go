@@ -2,10 +2,15 @@ package ui
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/steve-kaufman/go-auth-service/interfaces"
 	"github.com/steve-kaufman/go-auth-service/usecases"
@@ -13,7 +18,14 @@ import (
 
 var ErrInvalidJSON = fmt.Errorf("invalid JSON")
 var ErrNeedsUsername = fmt.Errorf("username is required")
+var ErrNeedsIdentifier = fmt.Errorf("username or email is required")
 var ErrNeedsPassword = fmt.Errorf("password is required")
+var ErrNeedsRefreshToken = fmt.Errorf("refresh token is required")
+var ErrNeedsAccessToken = fmt.Errorf("access token is required")
+var ErrNeedsCurrentPassword = fmt.Errorf("current password is required")
+var ErrNeedsNewPassword = fmt.Errorf("new password is required")
+var ErrNeedsResetToken = fmt.Errorf("reset token is required")
+var ErrNeedsVerificationToken = fmt.Errorf("verification token is required")
 
 type ErrorResponse struct {
 	statusCode int
@@ -29,68 +41,479 @@ var errorResponses = map[error]ErrorResponse{
 		statusCode: 404,
 		msg:        "User '%s' does not exist",
 	},
+	usecases.ErrDuplicate: {
+		statusCode: 409,
+		msg:        "User '%s' already exists",
+	},
 	usecases.ErrBadPassword: {
 		statusCode: 400,
 		msg:        "Incorrect password",
 	},
+	usecases.ErrBadCredentials: {
+		statusCode: 401,
+		msg:        "Incorrect username, email or password",
+	},
+	usecases.ErrInvalidToken: {
+		statusCode: 401,
+		msg:        "Invalid or expired token",
+	},
+	usecases.ErrTokenReused: {
+		statusCode: 401,
+		msg:        "Refresh token was already used; please log in again",
+	},
+	usecases.ErrInvalidEmail: {
+		statusCode: 400,
+		msg:        "Invalid email address",
+	},
+	usecases.ErrEmailRequired: {
+		statusCode: 400,
+		msg:        "Email is required",
+	},
+	usecases.ErrDuplicateEmail: {
+		statusCode: 409,
+		msg:        "Email is already in use",
+	},
+	usecases.ErrEmailNotVerified: {
+		statusCode: 403,
+		msg:        "Email address is not verified",
+	},
+	usecases.ErrResetUnavailable: {
+		statusCode: 404,
+		msg:        "Password reset is not available",
+	},
 	ErrNeedsUsername: {
 		statusCode: 400,
 		msg:        "Username is required",
 	},
+	ErrNeedsIdentifier: {
+		statusCode: 400,
+		msg:        "Username or email is required",
+	},
 	ErrNeedsPassword: {
 		statusCode: 400,
 		msg:        "Password is required",
 	},
+	ErrNeedsRefreshToken: {
+		statusCode: 400,
+		msg:        "Refresh token is required",
+	},
+	ErrNeedsAccessToken: {
+		statusCode: 401,
+		msg:        "Access token is required",
+	},
+	ErrNeedsCurrentPassword: {
+		statusCode: 400,
+		msg:        "Current password is required",
+	},
+	ErrNeedsNewPassword: {
+		statusCode: 400,
+		msg:        "New password is required",
+	},
+	ErrNeedsResetToken: {
+		statusCode: 400,
+		msg:        "Reset token is required",
+	},
+	ErrNeedsVerificationToken: {
+		statusCode: 400,
+		msg:        "Verification token is required",
+	},
 	ErrInvalidJSON: {
 		statusCode: 400,
 		msg:        "Invalid JSON",
 	},
 }
 
+// JWKSMaxAge is how long resource servers may cache the published keys.
+// It's kept short so that a newly rotated key is picked up quickly, and a
+// new key should be published for this long before it signs anything.
+const JWKSMaxAge = 5 * time.Minute
+
 type HTTP struct {
-	service interfaces.Service
+	service     interfaces.Service
+	keyProvider interfaces.PublicKeyProvider
 }
 
 func (server *HTTP) UseService(service interfaces.Service) {
 	server.service = service
 }
 
+// UseKeyProvider publishes the provider's keys at /.well-known/jwks.json.
+func (server *HTTP) UseKeyProvider(keyProvider interfaces.PublicKeyProvider) {
+	server.keyProvider = keyProvider
+}
+
+type route struct {
+	method  string
+	handler func(HTTP, http.ResponseWriter, *http.Request)
+}
+
+var routes = map[string]route{
+	"/login":                 {method: http.MethodPost, handler: httpLogin},
+	"/signup":                {method: http.MethodPost, handler: httpSignup},
+	"/refresh":               {method: http.MethodPost, handler: httpRefresh},
+	"/logout":                {method: http.MethodPost, handler: httpLogout},
+	"/logout/all":            {method: http.MethodPost, handler: httpLogoutEverywhere},
+	"/authenticate":          {method: http.MethodGet, handler: httpAuthenticate},
+	"/password":              {method: http.MethodPost, handler: httpChangePassword},
+	"/password/forgot":       {method: http.MethodPost, handler: httpForgotPassword},
+	"/password/reset":        {method: http.MethodPost, handler: httpResetPassword},
+	"/verify-email":          {method: http.MethodPost, handler: httpVerifyEmail},
+	"/verify-email/resend":   {method: http.MethodPost, handler: httpResendEmailVerification},
+	"/.well-known/jwks.json": {method: http.MethodGet, handler: httpJWKS},
+}
+
 func (server HTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path == "/login" || r.URL.Path == "/signup" {
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
-		httpLogin(server.service, w, r)
+	route, ok := routes[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != route.method {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	route.handler(server, w, r)
+}
+
+func httpLogin(server HTTP, w http.ResponseWriter, r *http.Request) {
+	identifier, password, err := getIdentifierAndPassword(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	tryLogin(w, server.service, identifier, password)
+}
+
+func httpSignup(server HTTP, w http.ResponseWriter, r *http.Request) {
+	username, password, email, err := getSignupFields(r)
+	if err != nil {
+		sendError(w, err)
 		return
 	}
-	w.WriteHeader(http.StatusNotFound)
+
+	trySignup(w, server.service, username, password, email)
 }
 
-func httpLogin(service interfaces.Service, w http.ResponseWriter, r *http.Request) {
-	username, password, err := getUsernameAndPassword(r)
+func httpRefresh(server HTTP, w http.ResponseWriter, r *http.Request) {
+	refreshToken, err := getRefreshToken(r)
 	if err != nil {
 		sendError(w, err)
 		return
 	}
 
-	tryLogin(w, service, username, password)
+	tokens, err := server.service.Refresh(refreshToken)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(tokens)
 }
 
-func getUsernameAndPassword(r *http.Request) (string, string, error) {
+func httpLogout(server HTTP, w http.ResponseWriter, r *http.Request) {
+	refreshToken, err := getRefreshToken(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	err = server.service.Logout(refreshToken)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func httpLogoutEverywhere(server HTTP, w http.ResponseWriter, r *http.Request) {
+	accessToken, err := getBearerToken(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	err = server.service.LogoutEverywhere(accessToken)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticateResponse is what a resource server is told about a live
+// access token.
+type authenticateResponse struct {
+	UserID    int                    `json:"user_id"`
+	Username  string                 `json:"username"`
+	Issuer    string                 `json:"iss,omitempty"`
+	Audience  []string               `json:"aud,omitempty"`
+	IssuedAt  float64                `json:"iat"`
+	ExpiresAt float64                `json:"exp"`
+	Custom    map[string]interface{} `json:"claims,omitempty"`
+}
+
+// httpAuthenticate lets resource servers check an access token against
+// logouts and password changes, which checking its signature alone can't
+// catch.
+func httpAuthenticate(server HTTP, w http.ResponseWriter, r *http.Request) {
+	accessToken, err := getBearerToken(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	claims, err := server.service.Authenticate(accessToken)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(authenticateResponse{
+		UserID:    claims.UserID,
+		Username:  claims.Username,
+		Issuer:    claims.Issuer,
+		Audience:  claims.Audience,
+		IssuedAt:  claims.IssuedAt,
+		ExpiresAt: claims.ExpiresAt,
+		Custom:    claims.Custom,
+	})
+}
+
+// httpChangePassword responds with new tokens, since every existing session,
+// including the caller's, is ended.
+func httpChangePassword(server HTTP, w http.ResponseWriter, r *http.Request) {
+	accessToken, err := getBearerToken(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	currentPassword, newPassword, err := getCurrentAndNewPassword(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	tokens, err := server.service.ChangePassword(accessToken, currentPassword, newPassword)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// httpForgotPassword answers 202 whether or not the user exists.
+func httpForgotPassword(server HTTP, w http.ResponseWriter, r *http.Request) {
+	username, err := getUsername(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	err = server.service.ForgotPassword(username)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func httpResetPassword(server HTTP, w http.ResponseWriter, r *http.Request) {
+	resetToken, newPassword, err := getResetTokenAndNewPassword(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	err = server.service.ResetPassword(resetToken, newPassword)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func httpVerifyEmail(server HTTP, w http.ResponseWriter, r *http.Request) {
+	verificationToken, err := getVerificationToken(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	err = server.service.VerifyEmail(verificationToken)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// httpResendEmailVerification answers 202 whether or not the user exists.
+func httpResendEmailVerification(server HTTP, w http.ResponseWriter, r *http.Request) {
+	username, err := getUsername(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	err = server.service.ResendEmailVerification(username)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// jwk is a JSON Web Key as laid out in RFC 7517.
+type jwk struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+func httpJWKS(server HTTP, w http.ResponseWriter, r *http.Request) {
+	if server.keyProvider == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	keys := []jwk{}
+	for _, publicKey := range server.keyProvider.GetPublicKeys() {
+		keys = append(keys, jwk{
+			KeyType:   publicKey.KeyType,
+			Use:       "sig",
+			ID:        publicKey.ID,
+			Algorithm: publicKey.Algorithm,
+			Curve:     publicKey.Curve,
+			N:         publicKey.N,
+			E:         publicKey.E,
+			X:         publicKey.X,
+			Y:         publicKey.Y,
+		})
+	}
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(JWKSMaxAge.Seconds())))
+	json.NewEncoder(w).Encode(map[string][]jwk{"keys": keys})
+}
+
+// getIdentifierAndPassword takes the username or email from identifier, or
+// from username for clients written before identifier was added.
+func getIdentifierAndPassword(r *http.Request) (string, string, error) {
 	body, err := getMapOfBody(r.Body)
 	if err != nil {
 		return "", "", err
 	}
+	identifier, isIdentifier := body["identifier"]
+	if !isIdentifier {
+		identifier, isIdentifier = body["username"]
+	}
+	if !isIdentifier {
+		return "", "", ErrNeedsIdentifier
+	}
+	password, isPassword := body["password"]
+	if !isPassword {
+		return "", "", ErrNeedsPassword
+	}
+	return identifier, password, nil
+}
+
+// getSignupFields treats email as optional.
+func getSignupFields(r *http.Request) (string, string, string, error) {
+	body, err := getMapOfBody(r.Body)
+	if err != nil {
+		return "", "", "", err
+	}
 	username, isUsername := body["username"]
 	if !isUsername {
-		return "", "", ErrNeedsUsername
+		return "", "", "", ErrNeedsUsername
 	}
 	password, isPassword := body["password"]
 	if !isPassword {
-		return "", "", ErrNeedsPassword
+		return "", "", "", ErrNeedsPassword
 	}
-	return username, password, nil
+	return username, password, body["email"], nil
+}
+
+func getUsername(r *http.Request) (string, error) {
+	body, err := getMapOfBody(r.Body)
+	if err != nil {
+		return "", err
+	}
+	username, isUsername := body["username"]
+	if !isUsername {
+		return "", ErrNeedsUsername
+	}
+	return username, nil
+}
+
+func getResetTokenAndNewPassword(r *http.Request) (string, string, error) {
+	body, err := getMapOfBody(r.Body)
+	if err != nil {
+		return "", "", err
+	}
+	resetToken, isResetToken := body["reset_token"]
+	if !isResetToken {
+		return "", "", ErrNeedsResetToken
+	}
+	newPassword, isNewPassword := body["new_password"]
+	if !isNewPassword {
+		return "", "", ErrNeedsNewPassword
+	}
+	return resetToken, newPassword, nil
+}
+
+func getVerificationToken(r *http.Request) (string, error) {
+	body, err := getMapOfBody(r.Body)
+	if err != nil {
+		return "", err
+	}
+	verificationToken, isVerificationToken := body["verification_token"]
+	if !isVerificationToken {
+		return "", ErrNeedsVerificationToken
+	}
+	return verificationToken, nil
+}
+
+func getCurrentAndNewPassword(r *http.Request) (string, string, error) {
+	body, err := getMapOfBody(r.Body)
+	if err != nil {
+		return "", "", err
+	}
+	currentPassword, isCurrentPassword := body["current_password"]
+	if !isCurrentPassword {
+		return "", "", ErrNeedsCurrentPassword
+	}
+	newPassword, isNewPassword := body["new_password"]
+	if !isNewPassword {
+		return "", "", ErrNeedsNewPassword
+	}
+	return currentPassword, newPassword, nil
+}
+
+func getRefreshToken(r *http.Request) (string, error) {
+	body, err := getMapOfBody(r.Body)
+	if err != nil {
+		return "", err
+	}
+	refreshToken, isRefreshToken := body["refresh_token"]
+	if !isRefreshToken {
+		return "", ErrNeedsRefreshToken
+	}
+	return refreshToken, nil
+}
+
+func getBearerToken(r *http.Request) (string, error) {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, prefix) || len(header) == len(prefix) {
+		return "", ErrNeedsAccessToken
+	}
+	return strings.TrimPrefix(header, prefix), nil
 }
 
 func getMapOfBody(body io.ReadCloser) (map[string]string, error) {
@@ -108,10 +531,22 @@ func getMapOfBody(body io.ReadCloser) (map[string]string, error) {
 
 func tryLogin(
 	w http.ResponseWriter,
-	service interfaces.Service, username string, password string,
+	service interfaces.Service, identifier string, password string,
+) {
+	tokens, err := service.Login(identifier, password)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func trySignup(
+	w http.ResponseWriter,
+	service interfaces.Service, username string, password string, email string,
 ) {
-	tokens, err := service.Login(username, password)
-	if err == usecases.ErrNotFound {
+	err := service.Signup(username, password, email)
+	if err == usecases.ErrDuplicate {
 		sendError(w, err, username)
 		return
 	}
@@ -119,10 +554,24 @@ func tryLogin(
 		sendError(w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(tokens)
+	w.WriteHeader(http.StatusCreated)
 }
 
 func sendError(w http.ResponseWriter, err error, a ...interface{}) {
+	var policyErr *usecases.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintf(w, "Password does not meet the password policy: %s",
+			strings.Join(policyErr.Violations, "; "))
+		return
+	}
+	var lockedErr *usecases.AccountLockedError
+	if errors.As(err, &lockedErr) {
+		w.Header().Set("Retry-After", retryAfterSeconds(lockedErr.RetryAfter))
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, "Too many failed logins; try again later")
+		return
+	}
 	response, ok := errorResponses[err]
 	if !ok {
 		w.WriteHeader(500)
@@ -132,3 +581,13 @@ func sendError(w http.ResponseWriter, err error, a ...interface{}) {
 	w.WriteHeader(response.statusCode)
 	fmt.Fprintf(w, response.msg, a...)
 }
+
+// retryAfterSeconds rounds up, so that a client retrying on time doesn't
+// arrive just before the lock ends.
+func retryAfterSeconds(retryAfter time.Duration) string {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
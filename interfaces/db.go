@@ -4,8 +4,91 @@ import "github.com/steve-kaufman/go-auth-service/entities"
 
 type UserGetter interface {
 	GetUserByUsername(username string) (entities.User, error)
+	// GetUserByEmail is given a normalized email.
+	GetUserByEmail(email string) (entities.User, error)
 }
 
+// UserCreator is the single source of truth for unique usernames and
+// emails. It must return usecases.ErrDuplicate when the username is already
+// taken, or usecases.ErrDuplicateEmail when the email is.
 type UserCreator interface {
 	CreateUser(entities.User) error
 }
+
+type EmailVerifier interface {
+	// MarkEmailVerified must only mark the user verified if their email is
+	// still email, and return usecases.ErrNotFound otherwise.
+	MarkEmailVerified(userID int, email string) error
+}
+
+// RefreshTokenStore remembers spent refresh tokens and revoked token
+// families.
+type RefreshTokenStore interface {
+	// UseRefreshToken must atomically record the token as spent, returning
+	// usecases.ErrTokenReused if it already was.
+	UseRefreshToken(entities.RefreshToken) error
+	// RevokeTokenFamily must keep the family revoked until at least
+	// expiresAt, keeping the later time if it's revoked again.
+	RevokeTokenFamily(family string, expiresAt float64) error
+	IsTokenFamilyRevoked(family string) (bool, error)
+}
+
+type ResetTokenStore interface {
+	// SaveResetToken must replace any reset token the user already has, so
+	// that only the latest one works.
+	SaveResetToken(entities.ResetToken) error
+	// GetResetToken must return usecases.ErrNotFound if there's no token
+	// with the given hash.
+	GetResetToken(hash string) (entities.ResetToken, error)
+	// UseResetToken must atomically delete the token, returning
+	// usecases.ErrNotFound if it already was.
+	UseResetToken(hash string) error
+}
+
+type EmailVerificationStore interface {
+	// SaveEmailVerificationToken must replace any verification token the
+	// user already has.
+	SaveEmailVerificationToken(entities.EmailVerificationToken) error
+	// GetEmailVerificationToken must return usecases.ErrNotFound if there's
+	// no token with the given hash.
+	GetEmailVerificationToken(hash string) (entities.EmailVerificationToken, error)
+	// UseEmailVerificationToken must atomically delete the token, returning
+	// usecases.ErrNotFound if it already was.
+	UseEmailVerificationToken(hash string) error
+}
+
+type LoginFailureStore interface {
+	// GetLoginFailures must return a zero LoginFailures, not an error, for an
+	// identifier with none.
+	GetLoginFailures(identifier string) (entities.LoginFailures, error)
+	// AddLoginFailure must atomically count a failure at the given time and
+	// return the new count. If the last failure was before forgetBefore, the
+	// count must start again from this one.
+	AddLoginFailure(identifier string, at float64, forgetBefore float64) (int, error)
+	// LockAccount must keep the later of until and any lock already set, so
+	// that concurrent failures can't shorten a lock.
+	LockAccount(identifier string, until float64) error
+	// ClearLoginFailures forgets the count and any lock.
+	ClearLoginFailures(identifier string) error
+}
+
+type PasswordUpdater interface {
+	// UpdatePassword must only replace the hash if it's still oldHash, and
+	// return usecases.ErrNotFound otherwise, so that a stale write can't undo
+	// a password change made in the meantime.
+	UpdatePassword(userID int, oldHash string, newHash string) error
+}
+
+type PasswordChanger interface {
+	// ChangePassword must, in one atomic step, replace the hash if it's still
+	// oldHash and increment the user's token version, ending their sessions.
+	// It returns usecases.ErrNotFound if the hash has changed or there's no
+	// user with the given ID.
+	ChangePassword(userID int, oldHash string, newHash string) error
+}
+
+type UserUpdater interface {
+	// IncrementTokenVersion must return usecases.ErrNotFound if there's no
+	// user with the given ID.
+	IncrementTokenVersion(userID int) error
+}
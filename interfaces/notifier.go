@@ -0,0 +1,14 @@
+package interfaces
+
+import "github.com/steve-kaufman/go-auth-service/entities"
+
+// ResetNotifier delivers a password reset token to the user it's for,
+// through a channel only they can read.
+type ResetNotifier interface {
+	SendPasswordReset(user entities.User, token string) error
+}
+
+// EmailVerificationNotifier sends a verification token to user.Email.
+type EmailVerificationNotifier interface {
+	SendEmailVerification(user entities.User, token string) error
+}
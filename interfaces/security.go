@@ -3,7 +3,12 @@ package interfaces
 import "github.com/steve-kaufman/go-auth-service/entities"
 
 type TokenGenerator interface {
-	GetTokens(userID int, username string) (entities.LoginTokens, error)
+	GetTokens(entities.TokenSubject) (entities.LoginTokens, error)
+}
+
+type TokenVerifier interface {
+	VerifyAccessToken(token string) (entities.TokenClaims, error)
+	VerifyRefreshToken(token string) (entities.TokenClaims, error)
 }
 
 type PasswordMatcher interface {
@@ -13,3 +18,32 @@ type PasswordMatcher interface {
 type PasswordHasher interface {
 	HashPassword(password string) (string, error)
 }
+
+// PasswordRehasher is the preferred PasswordHasher. NeedsRehash reports
+// whether a stored hash was made with another algorithm or weaker settings.
+type PasswordRehasher interface {
+	PasswordHasher
+	NeedsRehash(hashedPass string) bool
+}
+
+// PasswordPolicy returns a description of each rule a new password breaks,
+// or nothing if it's acceptable.
+type PasswordPolicy interface {
+	CheckPassword(username string, password string) []string
+}
+
+// BreachChecker reports whether a password is known from a data breach.
+type BreachChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// OneTimeTokenGenerator makes random tokens that are handed to a user and
+// only stored as a hash.
+type OneTimeTokenGenerator interface {
+	NewToken() (token string, hash string, err error)
+	HashToken(token string) string
+}
+
+type PublicKeyProvider interface {
+	GetPublicKeys() []entities.PublicKey
+}
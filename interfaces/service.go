@@ -3,6 +3,24 @@ package interfaces
 import "github.com/steve-kaufman/go-auth-service/entities"
 
 type Service interface {
-	Login(username string, password string) (entities.LoginTokens, error)
-	Signup(username string, password string) error
+	// Login's identifier is a username or an email.
+	Login(identifier string, password string) (entities.LoginTokens, error)
+	// Signup's email is optional, unless verified emails are required. It
+	// reports a taken username or email, so unlike Login it tells which
+	// exist.
+	Signup(username string, password string, email string) error
+	VerifyEmail(verificationToken string) error
+	ResendEmailVerification(username string) error
+	ChangePassword(
+		accessToken string, currentPassword string, newPassword string,
+	) (entities.LoginTokens, error)
+	ForgotPassword(username string) error
+	ResetPassword(resetToken string, newPassword string) error
+	Refresh(refreshToken string) (entities.LoginTokens, error)
+	Logout(refreshToken string) error
+	LogoutEverywhere(accessToken string) error
+	// Authenticate checks an access token against logouts as well as its
+	// signature and expiry, for resource servers that need to know a
+	// session is still live.
+	Authenticate(accessToken string) (entities.TokenClaims, error)
 }
@@ -0,0 +1,6 @@
+package interfaces
+
+// TimeGetter returns the current time in seconds since the Unix epoch.
+type TimeGetter interface {
+	GetTime() float64
+}
@@ -0,0 +1,80 @@
+package usecases
+
+import (
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+)
+
+type AuthenticateDependencies struct {
+	TokenVerifier     interfaces.TokenVerifier
+	RefreshTokenStore interfaces.RefreshTokenStore
+	UserGetter        interfaces.UserGetter
+}
+
+// Authenticate checks that an access token is genuine and that its session
+// hasn't been ended by a logout since it was issued.
+func Authenticate(
+	deps AuthenticateDependencies, accessToken string,
+) (entities.TokenClaims, error) {
+	claims, err := deps.TokenVerifier.VerifyAccessToken(accessToken)
+	if err != nil {
+		return entities.TokenClaims{}, ErrInvalidToken
+	}
+	_, err = checkSessionIsLive(deps.RefreshTokenStore, deps.UserGetter, claims)
+	if err != nil {
+		return entities.TokenClaims{}, err
+	}
+	return claims, nil
+}
+
+// checkSessionIsLive rejects tokens whose family has been logged out, or
+// that predate the user's last "logout everywhere".
+func checkSessionIsLive(
+	store interfaces.RefreshTokenStore,
+	userGetter interfaces.UserGetter,
+	claims entities.TokenClaims,
+) (entities.User, error) {
+	err := checkFamilyNotRevoked(store, claims.Family)
+	if err != nil {
+		return entities.User{}, err
+	}
+	user, err := getTokenOwner(userGetter, claims)
+	if err != nil {
+		return entities.User{}, err
+	}
+	if user.TokenVersion != claims.Version {
+		return entities.User{}, ErrInvalidToken
+	}
+	return user, nil
+}
+
+func checkFamilyNotRevoked(
+	store interfaces.RefreshTokenStore, family string,
+) error {
+	revoked, err := store.IsTokenFamilyRevoked(family)
+	if err != nil {
+		return ErrInternal
+	}
+	if revoked {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// getTokenOwner re-checks that the user a token was issued to still exists.
+// The ID must match too, in case the username was freed up and taken again.
+func getTokenOwner(
+	userGetter interfaces.UserGetter, claims entities.TokenClaims,
+) (entities.User, error) {
+	user, err := userGetter.GetUserByUsername(claims.Username)
+	if err == ErrNotFound {
+		return entities.User{}, ErrInvalidToken
+	}
+	if err != nil {
+		return entities.User{}, ErrInternal
+	}
+	if user.ID != claims.UserID {
+		return entities.User{}, ErrInvalidToken
+	}
+	return user, nil
+}
@@ -0,0 +1,108 @@
+package usecases
+
+import (
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+)
+
+// ChangePasswordDependencies.PassPolicy and BreachChecker are optional, as
+// for Signup.
+type ChangePasswordDependencies struct {
+	TokenVerifier     interfaces.TokenVerifier
+	RefreshTokenStore interfaces.RefreshTokenStore
+	UserGetter        interfaces.UserGetter
+	PassMatcher       interfaces.PasswordMatcher
+	PassPolicy        interfaces.PasswordPolicy
+	BreachChecker     interfaces.BreachChecker
+	PassHasher        interfaces.PasswordHasher
+	PassChanger       interfaces.PasswordChanger
+	TokenGenerator    interfaces.TokenGenerator
+	// LoginFailures and TimeGetter are only needed if Lockout is enabled. A
+	// wrong current password counts towards it under the username, as a
+	// failed login does.
+	LoginFailures interfaces.LoginFailureStore
+	TimeGetter    interfaces.TimeGetter
+	Lockout       LockoutPolicy
+}
+
+// ChangePassword needs the current password as well as an access token, so
+// that a stolen token can't be used to take over the account. Every session,
+// including the caller's, is ended; the caller carries on with the new
+// tokens returned.
+func ChangePassword(
+	deps ChangePasswordDependencies,
+	accessToken string, currentPassword string, newPassword string,
+) (entities.LoginTokens, error) {
+	claims, err := deps.TokenVerifier.VerifyAccessToken(accessToken)
+	if err != nil {
+		return entities.LoginTokens{}, ErrInvalidToken
+	}
+	user, err := checkSessionIsLive(deps.RefreshTokenStore, deps.UserGetter, claims)
+	if err != nil {
+		return entities.LoginTokens{}, err
+	}
+	err = checkCurrentPassword(deps, currentPassword, user)
+	if err != nil {
+		return entities.LoginTokens{}, err
+	}
+	err = checkNewPassword(deps.PassPolicy, deps.BreachChecker, user.Username, newPassword)
+	if err != nil {
+		return entities.LoginTokens{}, err
+	}
+	newHash, err := hashPassword(deps.PassHasher, newPassword)
+	if err != nil {
+		return entities.LoginTokens{}, err
+	}
+
+	err = changePassword(deps.PassChanger, user, newHash)
+	if err != nil {
+		return entities.LoginTokens{}, err
+	}
+	user.Password = newHash
+	user.TokenVersion++
+	return generateTokens(deps.TokenGenerator, user)
+}
+
+// checkCurrentPassword goes through lockout like Login, so that a stolen
+// access token can't be used to guess the password without limit.
+func checkCurrentPassword(
+	deps ChangePasswordDependencies, password string, user entities.User,
+) error {
+	if !deps.Lockout.isEnabled() {
+		return verifyPassword(deps.PassMatcher, password, user)
+	}
+	key := userLockoutKey(user)
+	failures, err := checkLockout(deps.LoginFailures, deps.TimeGetter, key)
+	if err != nil {
+		return err
+	}
+	err = verifyPassword(deps.PassMatcher, password, user)
+	if err == ErrBadPassword {
+		lockErr := recordLoginFailure(
+			deps.LoginFailures, deps.TimeGetter, deps.Lockout, key,
+		)
+		if lockErr != nil {
+			return lockErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	clearLoginFailures(deps.LoginFailures, key, failures)
+	return nil
+}
+
+// changePassword treats a hash that changed since it was read as a lost
+// race with another password change, which will have ended this session.
+func changePassword(
+	passChanger interfaces.PasswordChanger, user entities.User, newHash string,
+) error {
+	err := passChanger.ChangePassword(user.ID, user.Password, newHash)
+	if err == ErrNotFound {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return ErrInternal
+	}
+	return nil
+}
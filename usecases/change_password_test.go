@@ -0,0 +1,197 @@
+package usecases_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/implementations/db"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+	"github.com/steve-kaufman/go-auth-service/usecases"
+)
+
+type MockPasswordChanger struct {
+	err error
+
+	userID  int
+	oldHash string
+	newHash string
+}
+
+func (changer *MockPasswordChanger) ChangePassword(
+	userID int, oldHash string, newHash string,
+) error {
+	if changer.err != nil {
+		return changer.err
+	}
+	changer.userID = userID
+	changer.oldHash = oldHash
+	changer.newHash = newHash
+	return nil
+}
+
+type ChangePasswordTest struct {
+	name string
+
+	passChanger          *MockPasswordChanger
+	tokenGenerator       interfaces.TokenGenerator
+	inputToken           string
+	inputCurrentPassword string
+	inputNewPassword     string
+
+	expectedErr     error
+	expectedNewHash string
+}
+
+var changePasswordTests = []ChangePasswordTest{
+	{
+		name: "Returns ErrInvalidToken when TokenVerifier rejects token",
+
+		passChanger:          new(MockPasswordChanger),
+		tokenGenerator:       new(MockTokenGenerator),
+		inputToken:           "forged.token",
+		inputCurrentPassword: "pass1",
+		inputNewPassword:     "newpassword",
+
+		expectedErr: usecases.ErrInvalidToken,
+	},
+	{
+		name: "Returns ErrBadPassword with wrong current password",
+
+		passChanger:          new(MockPasswordChanger),
+		tokenGenerator:       new(MockTokenGenerator),
+		inputToken:           "valid.user1",
+		inputCurrentPassword: "pass2",
+		inputNewPassword:     "newpassword",
+
+		expectedErr: usecases.ErrBadPassword,
+	},
+	{
+		name: "Returns ErrInvalidToken when password changed in the meantime",
+
+		passChanger:          &MockPasswordChanger{err: usecases.ErrNotFound},
+		tokenGenerator:       new(MockTokenGenerator),
+		inputToken:           "valid.user1",
+		inputCurrentPassword: "pass1",
+		inputNewPassword:     "newpassword",
+
+		expectedErr: usecases.ErrInvalidToken,
+	},
+	{
+		name: "Returns ErrInternal with bad PasswordChanger",
+
+		passChanger:          &MockPasswordChanger{err: errors.New("foo")},
+		tokenGenerator:       new(MockTokenGenerator),
+		inputToken:           "valid.user1",
+		inputCurrentPassword: "pass1",
+		inputNewPassword:     "newpassword",
+
+		expectedErr: usecases.ErrInternal,
+	},
+	{
+		name: "Returns ErrInternal with bad TokenGenerator",
+
+		passChanger:          new(MockPasswordChanger),
+		tokenGenerator:       new(BadTokenGenerator),
+		inputToken:           "valid.user1",
+		inputCurrentPassword: "pass1",
+		inputNewPassword:     "newpassword",
+
+		expectedErr:     usecases.ErrInternal,
+		expectedNewHash: mockHash("newpassword"),
+	},
+	{
+		name: "Changes password",
+
+		passChanger:          new(MockPasswordChanger),
+		tokenGenerator:       new(MockTokenGenerator),
+		inputToken:           "valid.user1",
+		inputCurrentPassword: "pass1",
+		inputNewPassword:     "newpassword",
+
+		expectedErr:     nil,
+		expectedNewHash: mockHash("newpassword"),
+	},
+}
+
+func TestChangePassword(t *testing.T) {
+	for _, tc := range changePasswordTests {
+		t.Run(tc.name, func(t *testing.T) {
+			deps := usecases.ChangePasswordDependencies{
+				TokenVerifier:     new(MockTokenVerifier),
+				RefreshTokenStore: db.NewMemory(),
+				UserGetter:        new(MockUserGetter),
+				PassMatcher:       new(MockPasswordMatcher),
+				PassHasher:        new(MockPasswordHasher),
+				PassChanger:       tc.passChanger,
+				TokenGenerator:    tc.tokenGenerator,
+			}
+
+			_, err := usecases.ChangePassword(
+				deps, tc.inputToken, tc.inputCurrentPassword, tc.inputNewPassword,
+			)
+
+			if err != tc.expectedErr {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+			if tc.passChanger.newHash != tc.expectedNewHash {
+				t.Fatalf("Expected new hash: '%s'; Got: '%s'",
+					tc.expectedNewHash, tc.passChanger.newHash)
+			}
+			if tc.expectedNewHash == "" {
+				return
+			}
+			if tc.passChanger.userID != 1 || tc.passChanger.oldHash != mockHash("pass1") {
+				t.Fatalf("Expected change of user 1 from '%s'; Got: user %d from '%s'",
+					mockHash("pass1"), tc.passChanger.userID, tc.passChanger.oldHash)
+			}
+		})
+	}
+}
+
+func TestChangePassword_IssuesTokensForNewVersion(t *testing.T) {
+	tokenGenerator := new(MockTokenGenerator)
+	deps := usecases.ChangePasswordDependencies{
+		TokenVerifier:     new(MockTokenVerifier),
+		RefreshTokenStore: db.NewMemory(),
+		UserGetter:        new(MockUserGetter),
+		PassMatcher:       new(MockPasswordMatcher),
+		PassHasher:        new(MockPasswordHasher),
+		PassChanger:       new(MockPasswordChanger),
+		TokenGenerator:    tokenGenerator,
+	}
+
+	_, err := usecases.ChangePassword(deps, "valid.user1", "pass1", "newpassword")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	expectedSubject := entities.TokenSubject{UserID: 1, Username: "user1", Version: 1}
+	if tokenGenerator.subject != expectedSubject {
+		t.Fatalf("Expected tokens for: %+v; Got: %+v", expectedSubject, tokenGenerator.subject)
+	}
+}
+
+func TestChangePassword_AppliesPasswordPolicy(t *testing.T) {
+	passChanger := new(MockPasswordChanger)
+	deps := usecases.ChangePasswordDependencies{
+		TokenVerifier:     new(MockTokenVerifier),
+		RefreshTokenStore: db.NewMemory(),
+		UserGetter:        new(MockUserGetter),
+		PassMatcher:       new(MockPasswordMatcher),
+		PassPolicy:        new(MockPasswordPolicy),
+		BreachChecker:     new(MockBreachChecker),
+		PassHasher:        new(MockPasswordHasher),
+		PassChanger:       passChanger,
+		TokenGenerator:    new(MockTokenGenerator),
+	}
+
+	_, err := usecases.ChangePassword(deps, "valid.user1", "pass1", "user1")
+
+	if _, ok := err.(*usecases.PasswordPolicyError); !ok {
+		t.Fatalf("Expected a PasswordPolicyError; Got: '%v'", err)
+	}
+	if passChanger.newHash != "" {
+		t.Fatalf("Expected password not to change; Got: '%s'", passChanger.newHash)
+	}
+}
@@ -1,8 +1,40 @@
 package usecases
 
-import "errors"
+import (
+	"errors"
+	"strings"
+	"time"
+)
 
 var ErrInternal = errors.New("internal error")
 var ErrNotFound = errors.New("user not found")
 var ErrBadPassword = errors.New("incorrect password")
+var ErrBadCredentials = errors.New("incorrect identifier or password")
 var ErrDuplicate = errors.New("duplicate username")
+var ErrInvalidToken = errors.New("invalid or expired token")
+var ErrTokenReused = errors.New("refresh token was already used")
+var ErrInvalidEmail = errors.New("invalid email address")
+var ErrEmailRequired = errors.New("email is required")
+var ErrDuplicateEmail = errors.New("duplicate email")
+var ErrEmailNotVerified = errors.New("email address is not verified")
+var ErrResetUnavailable = errors.New("password reset is not available")
+
+// PasswordPolicyError lists every rule a new password broke, so that the
+// user can fix them all at once.
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (err *PasswordPolicyError) Error() string {
+	return "password does not meet policy: " + strings.Join(err.Violations, "; ")
+}
+
+// AccountLockedError is returned by Login for an account locked by too many
+// failed logins, whatever the password.
+type AccountLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (err *AccountLockedError) Error() string {
+	return "account is locked after too many failed logins"
+}
@@ -0,0 +1,130 @@
+package usecases
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+)
+
+const DefaultLockout = time.Minute
+const DefaultMaxLockout = time.Hour
+const DefaultLockoutResetAfter = 24 * time.Hour
+
+// LockoutPolicy locks an account once it has MaxFailures failed logins in a
+// row, whichever identifier they used. An identifier no one has is locked
+// the same way, so that a lock doesn't give away whether anyone has it. The first lock lasts Lockout, and each further failure after it ends
+// doubles the lock, up to MaxLockout. Failures are forgotten ResetAfter after
+// the last one. A zero MaxFailures disables lockout, and zero durations
+// default to DefaultLockout, DefaultMaxLockout and DefaultLockoutResetAfter.
+type LockoutPolicy struct {
+	MaxFailures int
+	Lockout     time.Duration
+	MaxLockout  time.Duration
+	ResetAfter  time.Duration
+}
+
+func (policy LockoutPolicy) isEnabled() bool {
+	return policy.MaxFailures > 0
+}
+
+// lockDuration is how long an account with failures failed logins in a row
+// is locked for, or zero if it isn't.
+func (policy LockoutPolicy) lockDuration(failures int) time.Duration {
+	if failures < policy.MaxFailures {
+		return 0
+	}
+	lockout := orDefault(policy.Lockout, DefaultLockout)
+	maxLockout := orDefault(policy.MaxLockout, DefaultMaxLockout)
+	for i := policy.MaxFailures; i < failures && lockout < maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > maxLockout {
+		return maxLockout
+	}
+	return lockout
+}
+
+func orDefault(duration time.Duration, defaultDuration time.Duration) time.Duration {
+	if duration == 0 {
+		return defaultDuration
+	}
+	return duration
+}
+
+// userLockoutKey is what a user's failures are counted under, so that their
+// username and email share one budget.
+func userLockoutKey(user entities.User) string {
+	return "user:" + strconv.Itoa(user.ID)
+}
+
+// unknownLockoutKey is what failures for an identifier no one has are
+// counted under: the identifier, with an email normalized as it is for
+// lookup, so that case and spacing don't give extra guesses. The prefixes
+// keep the two kinds of key apart.
+func unknownLockoutKey(identifier string) string {
+	email, err := normalizeEmail(identifier)
+	if err == nil && email != "" {
+		return "identifier:" + email
+	}
+	return "identifier:" + identifier
+}
+
+// checkLockout returns an AccountLockedError if the key is locked out, and
+// otherwise its failures so far.
+func checkLockout(
+	store interfaces.LoginFailureStore, timeGetter interfaces.TimeGetter, key string,
+) (entities.LoginFailures, error) {
+	failures, err := store.GetLoginFailures(key)
+	if err != nil {
+		return entities.LoginFailures{}, ErrInternal
+	}
+	now := timeGetter.GetTime()
+	if failures.LockedUntil > now {
+		return entities.LoginFailures{}, &AccountLockedError{
+			RetryAfter: secondsToDuration(failures.LockedUntil - now),
+		}
+	}
+	return failures, nil
+}
+
+// recordLoginFailure counts a failed login, locking the key if it's had too
+// many. A failure that can't be counted fails the login with
+// ErrInternal rather than letting guesses through uncounted.
+func recordLoginFailure(
+	store interfaces.LoginFailureStore, timeGetter interfaces.TimeGetter,
+	policy LockoutPolicy, key string,
+) error {
+	now := timeGetter.GetTime()
+	resetAfter := orDefault(policy.ResetAfter, DefaultLockoutResetAfter)
+	count, err := store.AddLoginFailure(key, now, now-resetAfter.Seconds())
+	if err != nil {
+		return ErrInternal
+	}
+	lockout := policy.lockDuration(count)
+	if lockout == 0 {
+		return nil
+	}
+	err = store.LockAccount(key, now+lockout.Seconds())
+	if err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+// clearLoginFailures is best effort, like upgradePasswordHash: the user has
+// already proven who they are, and at worst keeps old failures until
+// ResetAfter.
+func clearLoginFailures(
+	store interfaces.LoginFailureStore, key string, failures entities.LoginFailures,
+) {
+	if failures.Count == 0 && failures.LockedUntil == 0 {
+		return
+	}
+	store.ClearLoginFailures(key)
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,241 @@
+package usecases_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/steve-kaufman/go-auth-service/implementations/db"
+	"github.com/steve-kaufman/go-auth-service/usecases"
+)
+
+// MockClock is a TimeGetter that tests can move forward.
+type MockClock struct {
+	now float64
+}
+
+func (clock *MockClock) GetTime() float64 {
+	return clock.now
+}
+
+func (clock *MockClock) Advance(duration time.Duration) {
+	clock.now += duration.Seconds()
+}
+
+var testLockoutPolicy = usecases.LockoutPolicy{
+	MaxFailures: 3,
+	Lockout:     time.Minute,
+	MaxLockout:  4 * time.Minute,
+	ResetAfter:  time.Hour,
+}
+
+func setupLockout() (usecases.LoginDependencies, *MockClock) {
+	clock := &MockClock{now: 1000}
+	return usecases.LoginDependencies{
+		UserGetter:     new(MockUserGetter),
+		PassMatcher:    new(MockPasswordMatcher),
+		TokenGenerator: new(MockTokenGenerator),
+		LoginFailures:  db.NewMemory(),
+		TimeGetter:     clock,
+		Lockout:        testLockoutPolicy,
+	}, clock
+}
+
+func failLogins(t *testing.T, deps usecases.LoginDependencies, times int) {
+	t.Helper()
+	for i := 0; i < times; i++ {
+		_, err := usecases.Login(deps, "user1", "wrongpassword")
+		if err != usecases.ErrBadCredentials {
+			t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrBadCredentials, err)
+		}
+	}
+}
+
+func expectLocked(
+	t *testing.T, deps usecases.LoginDependencies, expectedRetryAfter time.Duration,
+) {
+	t.Helper()
+	_, err := usecases.Login(deps, "user1", "pass1")
+	var lockedErr *usecases.AccountLockedError
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("Expected an AccountLockedError; Got: '%v'", err)
+	}
+	if lockedErr.RetryAfter != expectedRetryAfter {
+		t.Fatalf("Expected retry after: %v; Got: %v",
+			expectedRetryAfter, lockedErr.RetryAfter)
+	}
+}
+
+func TestLogin_LocksAccountWithBackoff(t *testing.T) {
+	deps, clock := setupLockout()
+
+	failLogins(t, deps, 3)
+	expectLocked(t, deps, time.Minute)
+
+	clock.Advance(20 * time.Second)
+	expectLocked(t, deps, 40*time.Second)
+
+	for _, lockout := range []time.Duration{
+		2 * time.Minute, 4 * time.Minute, 4 * time.Minute,
+	} {
+		clock.Advance(4 * time.Minute)
+		failLogins(t, deps, 1)
+		expectLocked(t, deps, lockout)
+	}
+}
+
+func TestLogin_SuccessResetsFailures(t *testing.T) {
+	deps, _ := setupLockout()
+
+	failLogins(t, deps, 2)
+	_, err := usecases.Login(deps, "user1", "pass1")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	failLogins(t, deps, 2)
+
+	_, err = usecases.Login(deps, "user1", "pass1")
+	if err != nil {
+		t.Fatalf("Expected no lock; Got: '%v'", err)
+	}
+}
+
+func TestLogin_ForgetsOldFailures(t *testing.T) {
+	deps, clock := setupLockout()
+
+	failLogins(t, deps, 2)
+	clock.Advance(time.Hour + time.Second)
+	failLogins(t, deps, 2)
+
+	_, err := usecases.Login(deps, "user1", "pass1")
+	if err != nil {
+		t.Fatalf("Expected no lock; Got: '%v'", err)
+	}
+}
+
+func TestLogin_LockIsPerAccount(t *testing.T) {
+	deps, _ := setupLockout()
+
+	failLogins(t, deps, 3)
+
+	_, err := usecases.Login(deps, "user2", "pass2")
+	if err != nil {
+		t.Fatalf("Expected other account not to be locked; Got: '%v'", err)
+	}
+}
+
+func TestLogin_UsernameAndEmailShareFailures(t *testing.T) {
+	deps, _ := setupLockout()
+
+	for _, identifier := range []string{"user3", "User3@Example.com", "user3"} {
+		_, err := usecases.Login(deps, identifier, "wrongpassword")
+		if err != usecases.ErrBadCredentials {
+			t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrBadCredentials, err)
+		}
+	}
+
+	for _, identifier := range []string{"user3", "user3@example.com"} {
+		_, err := usecases.Login(deps, identifier, "pass3")
+		var lockedErr *usecases.AccountLockedError
+		if !errors.As(err, &lockedErr) {
+			t.Fatalf("Expected '%s' to be locked; Got: '%v'", identifier, err)
+		}
+	}
+}
+
+func TestLogin_LocksUnknownIdentifiersAlike(t *testing.T) {
+	deps, _ := setupLockout()
+
+	for i := 0; i < 3; i++ {
+		_, err := usecases.Login(deps, " Nobody@Example.com ", "wrongpassword")
+		if err != usecases.ErrBadCredentials {
+			t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrBadCredentials, err)
+		}
+	}
+
+	_, err := usecases.Login(deps, "nobody@example.com", "pass1")
+	var lockedErr *usecases.AccountLockedError
+	if !errors.As(err, &lockedErr) || lockedErr.RetryAfter != time.Minute {
+		t.Fatalf("Expected a one minute lock; Got: '%v'", err)
+	}
+}
+
+func TestLogin_DoesNotLockWhenDisabled(t *testing.T) {
+	deps, _ := setupLockout()
+	deps.Lockout.MaxFailures = 0
+
+	failLogins(t, deps, 10)
+
+	_, err := usecases.Login(deps, "user1", "pass1")
+	if err != nil {
+		t.Fatalf("Expected no lock; Got: '%v'", err)
+	}
+}
+
+type BadLoginFailureStore struct {
+	*db.Memory
+}
+
+func (BadLoginFailureStore) AddLoginFailure(
+	identifier string, at float64, forgetBefore float64,
+) (int, error) {
+	return 0, errors.New("foo")
+}
+
+func TestLogin_ReturnsErrInternalWhenFailureIsNotCounted(t *testing.T) {
+	deps, _ := setupLockout()
+	deps.LoginFailures = BadLoginFailureStore{db.NewMemory()}
+
+	_, err := usecases.Login(deps, "user1", "wrongpassword")
+
+	if err != usecases.ErrInternal {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrInternal, err)
+	}
+}
+
+func TestChangePassword_CountsTowardsLockout(t *testing.T) {
+	loginDeps, _ := setupLockout()
+	deps := usecases.ChangePasswordDependencies{
+		TokenVerifier:     new(MockTokenVerifier),
+		RefreshTokenStore: db.NewMemory(),
+		UserGetter:        new(MockUserGetter),
+		PassMatcher:       new(MockPasswordMatcher),
+		PassHasher:        new(MockPasswordHasher),
+		PassChanger:       new(MockPasswordChanger),
+		TokenGenerator:    new(MockTokenGenerator),
+		LoginFailures:     loginDeps.LoginFailures,
+		TimeGetter:        loginDeps.TimeGetter,
+		Lockout:           loginDeps.Lockout,
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := usecases.ChangePassword(deps, "valid.user1", "wrongpassword", "newpassword")
+		if err != usecases.ErrBadPassword {
+			t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrBadPassword, err)
+		}
+	}
+
+	_, err := usecases.ChangePassword(deps, "valid.user1", "pass1", "newpassword")
+	var lockedErr *usecases.AccountLockedError
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("Expected an AccountLockedError; Got: '%v'", err)
+	}
+	expectLocked(t, loginDeps, time.Minute)
+}
+
+func TestResetPassword_ClearsLoginFailures(t *testing.T) {
+	loginDeps, _ := setupLockout()
+	failLogins(t, loginDeps, 3)
+	deps, _ := setupResetPassword(t, 200)
+	deps.LoginFailures = loginDeps.LoginFailures
+
+	err := usecases.ResetPassword(deps, "reset-token", "newpassword")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	_, err = usecases.Login(loginDeps, "user1", "pass1")
+	if err != nil {
+		t.Fatalf("Expected no lock; Got: '%v'", err)
+	}
+}
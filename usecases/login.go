@@ -5,26 +5,92 @@ import (
 	"github.com/steve-kaufman/go-auth-service/interfaces"
 )
 
+// LoginDependencies.PassRehasher and PassUpdater are optional. Without them
+// stored hashes are never upgraded.
 type LoginDependencies struct {
 	UserGetter     interfaces.UserGetter
 	PassMatcher    interfaces.PasswordMatcher
+	PassRehasher   interfaces.PasswordRehasher
+	PassUpdater    interfaces.PasswordUpdater
 	TokenGenerator interfaces.TokenGenerator
+	// RequireVerifiedEmail refuses users whose email isn't verified,
+	// including users without one.
+	RequireVerifiedEmail bool
+	// DummyHash is matched against when there's no such user, so that the
+	// login takes as long as one with a wrong password. It should come from
+	// the same hasher as users' hashes. If it's empty, nothing is matched.
+	DummyHash string
+	// LoginFailures and TimeGetter are only needed if Lockout is enabled.
+	LoginFailures interfaces.LoginFailureStore
+	TimeGetter    interfaces.TimeGetter
+	Lockout       LockoutPolicy
 }
 
+// Login takes a username or an email as identifier. An unknown identifier
+// and a wrong password both return ErrBadCredentials, so that logins can't
+// be used to find out which identifiers exist. Signup still can: it returns
+// ErrDuplicate or ErrDuplicateEmail for an identifier that's taken, so that
+// the person signing up knows to pick another, and rate limiting it is left
+// to the deployment.
 func Login(
-	deps LoginDependencies, username string, password string,
+	deps LoginDependencies, identifier string, password string,
 ) (entities.LoginTokens, error) {
-	user, err := getUser(deps.UserGetter, username)
-	if err != nil {
+	user, err := getUserByIdentifier(deps.UserGetter, identifier)
+	if err != nil && err != ErrNotFound {
 		return entities.LoginTokens{}, err
 	}
+	exists := err == nil
+	key := unknownLockoutKey(identifier)
+	if exists {
+		key = userLockoutKey(user)
+	}
+	var failures entities.LoginFailures
+	if deps.Lockout.isEnabled() {
+		failures, err = checkLockout(deps.LoginFailures, deps.TimeGetter, key)
+		if err != nil {
+			return entities.LoginTokens{}, err
+		}
+	}
+	if !exists {
+		matchDummyHash(deps, password)
+		return entities.LoginTokens{}, loginFailed(deps, key)
+	}
 	err = verifyPassword(deps.PassMatcher, password, user)
+	if err == ErrBadPassword {
+		return entities.LoginTokens{}, loginFailed(deps, key)
+	}
 	if err != nil {
 		return entities.LoginTokens{}, err
 	}
+	if deps.Lockout.isEnabled() {
+		clearLoginFailures(deps.LoginFailures, key, failures)
+	}
+	if deps.RequireVerifiedEmail && !user.EmailVerified {
+		return entities.LoginTokens{}, ErrEmailNotVerified
+	}
+	upgradePasswordHash(deps, password, user)
 	return generateTokens(deps.TokenGenerator, user)
 }
 
+// getUserByIdentifier looks an identifier shaped like an email up by email
+// first. It falls back to the username, since nothing stops a username
+// having an @ in it too.
+func getUserByIdentifier(
+	userGetter interfaces.UserGetter, identifier string,
+) (entities.User, error) {
+	email, err := normalizeEmail(identifier)
+	if err == nil && email != "" {
+		user, err := userGetter.GetUserByEmail(email)
+		if err == nil {
+			return user, nil
+		}
+		if err != ErrNotFound {
+			return entities.User{}, ErrInternal
+		}
+	}
+	return getUser(userGetter, identifier)
+}
+
 func getUser(
 	userGetter interfaces.UserGetter, username string,
 ) (entities.User, error) {
@@ -38,6 +104,29 @@ func getUser(
 	return user, nil
 }
 
+// loginFailed counts an unknown identifier or a wrong password towards
+// lockout. The login that locks the identifier still returns
+// ErrBadCredentials; the next one finds the lock.
+func loginFailed(deps LoginDependencies, key string) error {
+	if !deps.Lockout.isEnabled() {
+		return ErrBadCredentials
+	}
+	err := recordLoginFailure(deps.LoginFailures, deps.TimeGetter, deps.Lockout, key)
+	if err != nil {
+		return err
+	}
+	return ErrBadCredentials
+}
+
+// matchDummyHash spends the time a password check would have taken. The
+// result doesn't matter.
+func matchDummyHash(deps LoginDependencies, password string) {
+	if deps.DummyHash == "" {
+		return
+	}
+	deps.PassMatcher.MatchPassword(password, deps.DummyHash)
+}
+
 func verifyPassword(
 	passMatcher interfaces.PasswordMatcher, password string, user entities.User,
 ) error {
@@ -51,10 +140,40 @@ func verifyPassword(
 	return nil
 }
 
+// upgradePasswordHash replaces a hash made with an old algorithm or weaker
+// settings, now that the plain password is at hand. It's best effort: the old
+// hash still works, so a failure here mustn't fail the login.
+func upgradePasswordHash(
+	deps LoginDependencies, password string, user entities.User,
+) {
+	if deps.PassRehasher == nil || deps.PassUpdater == nil {
+		return
+	}
+	if !deps.PassRehasher.NeedsRehash(user.Password) {
+		return
+	}
+	newHash, err := deps.PassRehasher.HashPassword(password)
+	if err != nil {
+		return
+	}
+	deps.PassUpdater.UpdatePassword(user.ID, user.Password, newHash)
+}
+
 func generateTokens(
 	tokenGenerator interfaces.TokenGenerator, user entities.User,
 ) (entities.LoginTokens, error) {
-	tokens, err := tokenGenerator.GetTokens(user.ID, user.Username)
+	return generateTokensInFamily(tokenGenerator, user, "")
+}
+
+func generateTokensInFamily(
+	tokenGenerator interfaces.TokenGenerator, user entities.User, family string,
+) (entities.LoginTokens, error) {
+	tokens, err := tokenGenerator.GetTokens(entities.TokenSubject{
+		UserID:   user.ID,
+		Username: user.Username,
+		Family:   family,
+		Version:  user.TokenVersion,
+	})
 	if err != nil {
 		return entities.LoginTokens{}, ErrInternal
 	}
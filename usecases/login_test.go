@@ -25,9 +25,11 @@ var exampleUsers = []entities.User{
 		Password: mockHash("pass2"),
 	},
 	{
-		ID:       3,
-		Username: "user3",
-		Password: mockHash("pass3"),
+		ID:            3,
+		Username:      "user3",
+		Password:      mockHash("pass3"),
+		Email:         "user3@example.com",
+		EmailVerified: true,
 	},
 }
 
@@ -42,12 +44,25 @@ func (MockUserGetter) GetUserByUsername(username string) (entities.User, error)
 	return entities.User{}, usecases.ErrNotFound
 }
 
+func (MockUserGetter) GetUserByEmail(email string) (entities.User, error) {
+	for _, user := range exampleUsers {
+		if user.Email != "" && user.Email == email {
+			return user, nil
+		}
+	}
+	return entities.User{}, usecases.ErrNotFound
+}
+
 type BadUserGetter struct{}
 
 func (BadUserGetter) GetUserByUsername(username string) (entities.User, error) {
 	return entities.User{}, errors.New("foo")
 }
 
+func (BadUserGetter) GetUserByEmail(email string) (entities.User, error) {
+	return entities.User{}, errors.New("foo")
+}
+
 type MockPasswordMatcher struct{}
 
 func (MockPasswordMatcher) MatchPassword(
@@ -67,11 +82,14 @@ func (BadPasswordMatcher) MatchPassword(
 	return false, errors.New("bar")
 }
 
-type MockTokenGenerator struct{}
+type MockTokenGenerator struct {
+	subject entities.TokenSubject
+}
 
-func (MockTokenGenerator) GetTokens(
-	userID int, username string,
+func (generator *MockTokenGenerator) GetTokens(
+	subject entities.TokenSubject,
 ) (entities.LoginTokens, error) {
+	generator.subject = subject
 	return entities.LoginTokens{
 		AccessToken:  "access.token.foo",
 		RefreshToken: "refresh.token.bar",
@@ -81,7 +99,7 @@ func (MockTokenGenerator) GetTokens(
 type BadTokenGenerator struct{}
 
 func (BadTokenGenerator) GetTokens(
-	userID int, username string,
+	subject entities.TokenSubject,
 ) (entities.LoginTokens, error) {
 	return entities.LoginTokens{}, errors.New("foobar")
 }
@@ -137,7 +155,7 @@ var loginTests = []LoginTest{
 		expectedTokens: entities.LoginTokens{},
 	},
 	{
-		name: "Returns ErrNotFound when UserGetter returns ErrNotFound",
+		name: "Returns ErrBadCredentials when UserGetter returns ErrNotFound",
 
 		userGetter:     new(MockUserGetter),
 		passMatcher:    new(MockPasswordMatcher),
@@ -145,11 +163,11 @@ var loginTests = []LoginTest{
 		inputUsername:  "non.existant.user",
 		inputPassword:  "supersecret",
 
-		expectedErr:    usecases.ErrNotFound,
+		expectedErr:    usecases.ErrBadCredentials,
 		expectedTokens: entities.LoginTokens{},
 	},
 	{
-		name: "Returns ErrBadPassword when PasswordMatcher returns false",
+		name: "Returns ErrBadCredentials when PasswordMatcher returns false",
 
 		userGetter:     new(MockUserGetter),
 		passMatcher:    new(MockPasswordMatcher),
@@ -157,7 +175,7 @@ var loginTests = []LoginTest{
 		inputUsername:  "user2",
 		inputPassword:  "wrongpassword",
 
-		expectedErr:    usecases.ErrBadPassword,
+		expectedErr:    usecases.ErrBadCredentials,
 		expectedTokens: entities.LoginTokens{},
 	},
 	{
@@ -203,3 +221,277 @@ func TestLogin(t *testing.T) {
 		})
 	}
 }
+
+type LoginIdentifierTest struct {
+	name string
+
+	inputIdentifier string
+
+	expectedErr      error
+	expectedUsername string
+}
+
+var loginIdentifierTests = []LoginIdentifierTest{
+	{
+		name: "Logs in with username",
+
+		inputIdentifier: "user3",
+
+		expectedErr:      nil,
+		expectedUsername: "user3",
+	},
+	{
+		name: "Logs in with email",
+
+		inputIdentifier: "user3@example.com",
+
+		expectedErr:      nil,
+		expectedUsername: "user3",
+	},
+	{
+		name: "Logs in with email in any case",
+
+		inputIdentifier: " USER3@example.com ",
+
+		expectedErr:      nil,
+		expectedUsername: "user3",
+	},
+	{
+		name: "Returns ErrBadCredentials with unknown email",
+
+		inputIdentifier: "nobody@example.com",
+
+		expectedErr: usecases.ErrBadCredentials,
+	},
+}
+
+func TestLogin_ByIdentifier(t *testing.T) {
+	for _, tc := range loginIdentifierTests {
+		t.Run(tc.name, func(t *testing.T) {
+			tokenGenerator := new(MockTokenGenerator)
+			deps := usecases.LoginDependencies{
+				UserGetter:     new(MockUserGetter),
+				PassMatcher:    new(MockPasswordMatcher),
+				TokenGenerator: tokenGenerator,
+			}
+
+			_, err := usecases.Login(deps, tc.inputIdentifier, "pass3")
+
+			if err != tc.expectedErr {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+			if tokenGenerator.subject.Username != tc.expectedUsername {
+				t.Fatalf("Expected tokens for: '%s'; Got: '%s'",
+					tc.expectedUsername, tokenGenerator.subject.Username)
+			}
+		})
+	}
+}
+
+// RecordingPasswordMatcher records the hashes it's given.
+type RecordingPasswordMatcher struct {
+	hashes []string
+}
+
+func (matcher *RecordingPasswordMatcher) MatchPassword(
+	plainPass string, hashedPass string,
+) (bool, error) {
+	matcher.hashes = append(matcher.hashes, hashedPass)
+	return false, nil
+}
+
+func TestLogin_MatchesDummyHashForUnknownUser(t *testing.T) {
+	passMatcher := new(RecordingPasswordMatcher)
+	deps := usecases.LoginDependencies{
+		UserGetter:     new(MockUserGetter),
+		PassMatcher:    passMatcher,
+		TokenGenerator: new(MockTokenGenerator),
+		DummyHash:      "dummy-hash",
+	}
+
+	_, err := usecases.Login(deps, "nobody", "supersecret")
+
+	if err != usecases.ErrBadCredentials {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrBadCredentials, err)
+	}
+	if len(passMatcher.hashes) != 1 || passMatcher.hashes[0] != "dummy-hash" {
+		t.Fatalf("Expected dummy hash to be matched; Got: %v", passMatcher.hashes)
+	}
+}
+
+type LoginVerifiedEmailTest struct {
+	name string
+
+	inputUsername string
+	inputPassword string
+
+	expectedErr error
+}
+
+var loginVerifiedEmailTests = []LoginVerifiedEmailTest{
+	{
+		name: "Returns ErrBadCredentials before checking email",
+
+		inputUsername: "user1",
+		inputPassword: "wrongpass",
+
+		expectedErr: usecases.ErrBadCredentials,
+	},
+	{
+		name: "Returns ErrEmailNotVerified without verified email",
+
+		inputUsername: "user1",
+		inputPassword: "pass1",
+
+		expectedErr: usecases.ErrEmailNotVerified,
+	},
+	{
+		name: "Logs in with verified email",
+
+		inputUsername: "user3",
+		inputPassword: "pass3",
+
+		expectedErr: nil,
+	},
+}
+
+func TestLogin_RequiresVerifiedEmail(t *testing.T) {
+	for _, tc := range loginVerifiedEmailTests {
+		t.Run(tc.name, func(t *testing.T) {
+			deps := usecases.LoginDependencies{
+				UserGetter:           new(MockUserGetter),
+				PassMatcher:          new(MockPasswordMatcher),
+				TokenGenerator:       new(MockTokenGenerator),
+				RequireVerifiedEmail: true,
+			}
+
+			_, err := usecases.Login(deps, tc.inputUsername, tc.inputPassword)
+
+			if err != tc.expectedErr {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+type MockPasswordRehasher struct {
+	needsRehash bool
+	err         error
+}
+
+func (rehasher MockPasswordRehasher) HashPassword(password string) (string, error) {
+	return "new:" + password, rehasher.err
+}
+
+func (rehasher MockPasswordRehasher) NeedsRehash(hashedPass string) bool {
+	return rehasher.needsRehash
+}
+
+type MockPasswordUpdater struct {
+	err error
+
+	userID  int
+	oldHash string
+	newHash string
+}
+
+func (updater *MockPasswordUpdater) UpdatePassword(
+	userID int, oldHash string, newHash string,
+) error {
+	updater.userID = userID
+	updater.oldHash = oldHash
+	updater.newHash = newHash
+	return updater.err
+}
+
+type LoginRehashTest struct {
+	name string
+
+	rehasher      MockPasswordRehasher
+	updaterErr    error
+	inputPassword string
+
+	expectedErr     error
+	expectedNewHash string
+}
+
+var loginRehashTests = []LoginRehashTest{
+	{
+		name: "Rehashes outdated hash after successful login",
+
+		rehasher:      MockPasswordRehasher{needsRehash: true},
+		inputPassword: "pass1",
+
+		expectedErr:     nil,
+		expectedNewHash: "new:pass1",
+	},
+	{
+		name: "Doesn't rehash current hash",
+
+		rehasher:      MockPasswordRehasher{needsRehash: false},
+		inputPassword: "pass1",
+
+		expectedErr:     nil,
+		expectedNewHash: "",
+	},
+	{
+		name: "Doesn't rehash after failed login",
+
+		rehasher:      MockPasswordRehasher{needsRehash: true},
+		inputPassword: "wrongpassword",
+
+		expectedErr:     usecases.ErrBadCredentials,
+		expectedNewHash: "",
+	},
+	{
+		name: "Still logs in when rehashing fails",
+
+		rehasher:      MockPasswordRehasher{needsRehash: true, err: errors.New("foo")},
+		inputPassword: "pass1",
+
+		expectedErr:     nil,
+		expectedNewHash: "",
+	},
+	{
+		name: "Still logs in when saving the new hash fails",
+
+		rehasher:      MockPasswordRehasher{needsRehash: true},
+		updaterErr:    errors.New("foo"),
+		inputPassword: "pass1",
+
+		expectedErr:     nil,
+		expectedNewHash: "new:pass1",
+	},
+}
+
+func TestLogin_RehashesPassword(t *testing.T) {
+	for _, tc := range loginRehashTests {
+		t.Run(tc.name, func(t *testing.T) {
+			passUpdater := &MockPasswordUpdater{err: tc.updaterErr}
+			deps := usecases.LoginDependencies{
+				UserGetter:     new(MockUserGetter),
+				PassMatcher:    new(MockPasswordMatcher),
+				PassRehasher:   tc.rehasher,
+				PassUpdater:    passUpdater,
+				TokenGenerator: new(MockTokenGenerator),
+			}
+
+			_, err := usecases.Login(deps, "user1", tc.inputPassword)
+
+			if err != tc.expectedErr {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+			if passUpdater.newHash != tc.expectedNewHash {
+				t.Fatalf("Expected new hash: '%s'; Got: '%s'",
+					tc.expectedNewHash, passUpdater.newHash)
+			}
+			if tc.expectedNewHash == "" {
+				return
+			}
+			if passUpdater.userID != 1 || passUpdater.oldHash != mockHash("pass1") {
+				t.Fatalf("Expected update of user 1 from '%s'; Got: user %d from '%s'",
+					mockHash("pass1"), passUpdater.userID, passUpdater.oldHash)
+			}
+		})
+	}
+}
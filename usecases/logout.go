@@ -0,0 +1,63 @@
+package usecases
+
+import (
+	"time"
+
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+)
+
+// LogoutDependencies.MaxTokenLifetime is as for Refresh.
+type LogoutDependencies struct {
+	TokenVerifier     interfaces.TokenVerifier
+	RefreshTokenStore interfaces.RefreshTokenStore
+	TimeGetter        interfaces.TimeGetter
+	MaxTokenLifetime  time.Duration
+}
+
+// Logout ends the session a refresh token belongs to by revoking its family,
+// which also invalidates the access tokens issued alongside it.
+func Logout(deps LogoutDependencies, refreshToken string) error {
+	claims, err := verifyRefreshToken(deps.TokenVerifier, refreshToken)
+	if err != nil {
+		return err
+	}
+	err = deps.RefreshTokenStore.RevokeTokenFamily(
+		claims.Family, familyExpiry(deps.TimeGetter, deps.MaxTokenLifetime, claims),
+	)
+	if err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+type LogoutEverywhereDependencies struct {
+	TokenVerifier     interfaces.TokenVerifier
+	RefreshTokenStore interfaces.RefreshTokenStore
+	UserGetter        interfaces.UserGetter
+	UserUpdater       interfaces.UserUpdater
+}
+
+// LogoutEverywhere ends every session of the access token's owner by bumping
+// their token version.
+func LogoutEverywhere(deps LogoutEverywhereDependencies, accessToken string) error {
+	claims, err := Authenticate(AuthenticateDependencies{
+		TokenVerifier:     deps.TokenVerifier,
+		RefreshTokenStore: deps.RefreshTokenStore,
+		UserGetter:        deps.UserGetter,
+	}, accessToken)
+	if err != nil {
+		return err
+	}
+	return incrementTokenVersion(deps.UserUpdater, claims.UserID)
+}
+
+func incrementTokenVersion(userUpdater interfaces.UserUpdater, userID int) error {
+	err := userUpdater.IncrementTokenVersion(userID)
+	if err == ErrNotFound {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return ErrInternal
+	}
+	return nil
+}
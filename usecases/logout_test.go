@@ -0,0 +1,207 @@
+package usecases_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/steve-kaufman/go-auth-service/implementations/db"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+	"github.com/steve-kaufman/go-auth-service/usecases"
+)
+
+type MockUserUpdater struct {
+	incrementedUserID int
+}
+
+func (updater *MockUserUpdater) IncrementTokenVersion(userID int) error {
+	for _, user := range exampleUsers {
+		if user.ID == userID {
+			updater.incrementedUserID = userID
+			return nil
+		}
+	}
+	return usecases.ErrNotFound
+}
+
+type BadUserUpdater struct{}
+
+func (BadUserUpdater) IncrementTokenVersion(userID int) error {
+	return errors.New("foo")
+}
+
+type LogoutTest struct {
+	name string
+
+	tokenVerifier     interfaces.TokenVerifier
+	refreshTokenStore interfaces.RefreshTokenStore
+	inputToken        string
+
+	expectedErr         error
+	expectFamilyRevoked bool
+}
+
+var logoutTests = []LogoutTest{
+	{
+		name: "Returns ErrInvalidToken when TokenVerifier rejects token",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: db.NewMemory(),
+		inputToken:        "forged.token",
+
+		expectedErr: usecases.ErrInvalidToken,
+	},
+	{
+		name: "Returns ErrInternal with bad RefreshTokenStore",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: new(BadRefreshTokenStore),
+		inputToken:        "valid.user1",
+
+		expectedErr: usecases.ErrInternal,
+	},
+	{
+		name: "Revokes the token's family",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: db.NewMemory(),
+		inputToken:        "valid.user1",
+
+		expectedErr:         nil,
+		expectFamilyRevoked: true,
+	},
+}
+
+func TestLogout(t *testing.T) {
+	for _, tc := range logoutTests {
+		t.Run(tc.name, func(t *testing.T) {
+			deps := usecases.LogoutDependencies{
+				TokenVerifier:     tc.tokenVerifier,
+				RefreshTokenStore: tc.refreshTokenStore,
+				TimeGetter:        new(MockTimeGetter),
+			}
+
+			err := usecases.Logout(deps, tc.inputToken)
+			if err != tc.expectedErr {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+
+			if store, ok := tc.refreshTokenStore.(*db.Memory); ok {
+				revoked, _ := store.IsTokenFamilyRevoked("family-1")
+				if revoked != tc.expectFamilyRevoked {
+					t.Fatalf("Expected family revoked: %v; Got: %v",
+						tc.expectFamilyRevoked, revoked)
+				}
+			}
+		})
+	}
+}
+
+func TestLogout_KeepsFamilyRevokedForATokenLifetime(t *testing.T) {
+	store := db.NewMemory()
+	deps := usecases.LogoutDependencies{
+		TokenVerifier:     new(MockTokenVerifier),
+		RefreshTokenStore: store,
+		TimeGetter:        new(MockTimeGetter),
+		MaxTokenLifetime:  time.Hour,
+	}
+
+	err := usecases.Logout(deps, "valid.user1")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	// A token issued in the family just before logging out lasts this long.
+	expiresAt := new(MockTimeGetter).GetTime() + time.Hour.Seconds()
+	store.DeleteExpiredRevokedFamilies(expiresAt - 1)
+	if revoked, _ := store.IsTokenFamilyRevoked("family-1"); !revoked {
+		t.Fatal("Expected family to stay revoked")
+	}
+	store.DeleteExpiredRevokedFamilies(expiresAt)
+	if revoked, _ := store.IsTokenFamilyRevoked("family-1"); revoked {
+		t.Fatal("Expected family to be forgotten once its tokens expire")
+	}
+}
+
+type LogoutEverywhereTest struct {
+	name string
+
+	tokenVerifier     interfaces.TokenVerifier
+	refreshTokenStore interfaces.RefreshTokenStore
+	userUpdater       interfaces.UserUpdater
+	inputToken        string
+
+	expectedErr               error
+	expectedIncrementedUserID int
+}
+
+var logoutEverywhereTests = []LogoutEverywhereTest{
+	{
+		name: "Returns ErrInvalidToken when TokenVerifier rejects token",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: db.NewMemory(),
+		userUpdater:       new(MockUserUpdater),
+		inputToken:        "forged.token",
+
+		expectedErr: usecases.ErrInvalidToken,
+	},
+	{
+		name: "Returns ErrInvalidToken when session was logged out",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: storeWithRevokedFamily("family-1"),
+		userUpdater:       new(MockUserUpdater),
+		inputToken:        "valid.user1",
+
+		expectedErr: usecases.ErrInvalidToken,
+	},
+	{
+		name: "Returns ErrInternal with bad UserUpdater",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: db.NewMemory(),
+		userUpdater:       new(BadUserUpdater),
+		inputToken:        "valid.user1",
+
+		expectedErr: usecases.ErrInternal,
+	},
+	{
+		name: "Increments the token owner's token version",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: db.NewMemory(),
+		userUpdater:       new(MockUserUpdater),
+		inputToken:        "valid.user2",
+
+		expectedErr:               nil,
+		expectedIncrementedUserID: 2,
+	},
+}
+
+func TestLogoutEverywhere(t *testing.T) {
+	for _, tc := range logoutEverywhereTests {
+		t.Run(tc.name, func(t *testing.T) {
+			deps := usecases.LogoutEverywhereDependencies{
+				TokenVerifier:     tc.tokenVerifier,
+				RefreshTokenStore: tc.refreshTokenStore,
+				UserGetter:        new(MockUserGetter),
+				UserUpdater:       tc.userUpdater,
+			}
+
+			err := usecases.LogoutEverywhere(deps, tc.inputToken)
+			if err != tc.expectedErr {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+
+			updater, ok := tc.userUpdater.(*MockUserUpdater)
+			if !ok {
+				return
+			}
+			if updater.incrementedUserID != tc.expectedIncrementedUserID {
+				t.Fatalf("Expected token version bump for user: %d; Got: %d",
+					tc.expectedIncrementedUserID, updater.incrementedUserID)
+			}
+		})
+	}
+}
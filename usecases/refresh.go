@@ -0,0 +1,89 @@
+package usecases
+
+import (
+	"math"
+	"time"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+)
+
+// RefreshDependencies.MaxTokenLifetime is the longer of the access and
+// refresh token lifetimes, for how long a revoked family must be kept.
+type RefreshDependencies struct {
+	TokenVerifier     interfaces.TokenVerifier
+	RefreshTokenStore interfaces.RefreshTokenStore
+	UserGetter        interfaces.UserGetter
+	TokenGenerator    interfaces.TokenGenerator
+	TimeGetter        interfaces.TimeGetter
+	MaxTokenLifetime  time.Duration
+}
+
+// Refresh spends a refresh token in exchange for a new pair in the same
+// family. Presenting a spent token means it has leaked, so the whole family
+// is revoked and the user has to log in again.
+func Refresh(
+	deps RefreshDependencies, refreshToken string,
+) (entities.LoginTokens, error) {
+	claims, err := verifyRefreshToken(deps.TokenVerifier, refreshToken)
+	if err != nil {
+		return entities.LoginTokens{}, err
+	}
+	user, err := checkSessionIsLive(deps.RefreshTokenStore, deps.UserGetter, claims)
+	if err != nil {
+		return entities.LoginTokens{}, err
+	}
+	err = spendRefreshToken(deps, claims)
+	if err != nil {
+		return entities.LoginTokens{}, err
+	}
+	return generateTokensInFamily(deps.TokenGenerator, user, claims.Family)
+}
+
+func verifyRefreshToken(
+	tokenVerifier interfaces.TokenVerifier, refreshToken string,
+) (entities.TokenClaims, error) {
+	claims, err := tokenVerifier.VerifyRefreshToken(refreshToken)
+	if err != nil {
+		return entities.TokenClaims{}, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func spendRefreshToken(deps RefreshDependencies, claims entities.TokenClaims) error {
+	err := deps.RefreshTokenStore.UseRefreshToken(entities.RefreshToken{
+		ID:        claims.ID,
+		Family:    claims.Family,
+		UserID:    claims.UserID,
+		ExpiresAt: claims.ExpiresAt,
+	})
+	if err == ErrTokenReused {
+		return revokeReusedFamily(deps, claims)
+	}
+	if err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+func revokeReusedFamily(deps RefreshDependencies, claims entities.TokenClaims) error {
+	err := deps.RefreshTokenStore.RevokeTokenFamily(
+		claims.Family,
+		familyExpiry(deps.TimeGetter, deps.MaxTokenLifetime, claims),
+	)
+	if err != nil {
+		return ErrInternal
+	}
+	return ErrTokenReused
+}
+
+// familyExpiry is when every token in claims' family will have expired, so
+// that its revocation can be forgotten. The newest token in the family may
+// have been issued just now, so that's up to a token lifetime away, and no
+// sooner than the token at hand expires.
+func familyExpiry(
+	timeGetter interfaces.TimeGetter, maxTokenLifetime time.Duration,
+	claims entities.TokenClaims,
+) float64 {
+	return math.Max(claims.ExpiresAt, timeGetter.GetTime()+maxTokenLifetime.Seconds())
+}
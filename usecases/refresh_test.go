@@ -0,0 +1,271 @@
+package usecases_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/implementations/db"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+	"github.com/steve-kaufman/go-auth-service/usecases"
+)
+
+// MockTokenVerifier accepts "valid.<username>" tokens. Their ID is the token
+// itself, they belong to "family-1" and they were issued to that user in
+// exampleUsers, or to user ID 99 if there's no such user.
+type MockTokenVerifier struct{}
+
+func (MockTokenVerifier) VerifyAccessToken(token string) (entities.TokenClaims, error) {
+	return mockVerify(token)
+}
+
+func (MockTokenVerifier) VerifyRefreshToken(token string) (entities.TokenClaims, error) {
+	return mockVerify(token)
+}
+
+func mockVerify(token string) (entities.TokenClaims, error) {
+	if !strings.HasPrefix(token, "valid.") {
+		return entities.TokenClaims{}, errors.New("bad token")
+	}
+	username := strings.TrimPrefix(token, "valid.")
+	claims := entities.TokenClaims{
+		ID:        token,
+		Family:    "family-1",
+		UserID:    99,
+		Username:  username,
+		ExpiresAt: 1000,
+	}
+	for _, user := range exampleUsers {
+		if user.Username == username {
+			claims.UserID = user.ID
+		}
+	}
+	return claims, nil
+}
+
+// StaleTokenVerifier claims every token was issued to user1 under a
+// different user ID, as if the account was deleted and recreated.
+type StaleTokenVerifier struct{}
+
+func (StaleTokenVerifier) VerifyAccessToken(token string) (entities.TokenClaims, error) {
+	return entities.TokenClaims{UserID: 42, Username: "user1"}, nil
+}
+
+func (StaleTokenVerifier) VerifyRefreshToken(token string) (entities.TokenClaims, error) {
+	return entities.TokenClaims{UserID: 42, Username: "user1"}, nil
+}
+
+type BadRefreshTokenStore struct{}
+
+func (BadRefreshTokenStore) UseRefreshToken(entities.RefreshToken) error {
+	return errors.New("foo")
+}
+
+func (BadRefreshTokenStore) RevokeTokenFamily(family string, expiresAt float64) error {
+	return errors.New("foo")
+}
+
+func (BadRefreshTokenStore) IsTokenFamilyRevoked(family string) (bool, error) {
+	return false, errors.New("foo")
+}
+
+func storeWithUsedToken(token string) *db.Memory {
+	store := db.NewMemory()
+	store.UseRefreshToken(entities.RefreshToken{ID: token, Family: "family-1"})
+	return store
+}
+
+func storeWithRevokedFamily(family string) *db.Memory {
+	store := db.NewMemory()
+	store.RevokeTokenFamily(family, 1000)
+	return store
+}
+
+type RefreshTest struct {
+	name string
+
+	tokenVerifier     interfaces.TokenVerifier
+	refreshTokenStore interfaces.RefreshTokenStore
+	userGetter        interfaces.UserGetter
+	tokenGenerator    interfaces.TokenGenerator
+	inputToken        string
+
+	expectedErr           error
+	expectedTokens        entities.LoginTokens
+	expectFamilyRevoked   bool
+	expectedSubjectFamily string
+}
+
+var refreshTests = []RefreshTest{
+	{
+		name: "Returns ErrInvalidToken when TokenVerifier rejects token",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: db.NewMemory(),
+		userGetter:        new(MockUserGetter),
+		tokenGenerator:    new(MockTokenGenerator),
+		inputToken:        "forged.token",
+
+		expectedErr: usecases.ErrInvalidToken,
+	},
+	{
+		name: "Returns ErrInvalidToken when family was revoked",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: storeWithRevokedFamily("family-1"),
+		userGetter:        new(MockUserGetter),
+		tokenGenerator:    new(MockTokenGenerator),
+		inputToken:        "valid.user1",
+
+		expectedErr:         usecases.ErrInvalidToken,
+		expectFamilyRevoked: true,
+	},
+	{
+		name: "Returns ErrTokenReused and revokes family when token was already used",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: storeWithUsedToken("valid.user1"),
+		userGetter:        new(MockUserGetter),
+		tokenGenerator:    new(MockTokenGenerator),
+		inputToken:        "valid.user1",
+
+		expectedErr:         usecases.ErrTokenReused,
+		expectFamilyRevoked: true,
+	},
+	{
+		name: "Returns ErrInternal with bad RefreshTokenStore",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: new(BadRefreshTokenStore),
+		userGetter:        new(MockUserGetter),
+		tokenGenerator:    new(MockTokenGenerator),
+		inputToken:        "valid.user1",
+
+		expectedErr: usecases.ErrInternal,
+	},
+	{
+		name: "Returns ErrInvalidToken when user no longer exists",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: db.NewMemory(),
+		userGetter:        new(MockUserGetter),
+		tokenGenerator:    new(MockTokenGenerator),
+		inputToken:        "valid.deleted.user",
+
+		expectedErr: usecases.ErrInvalidToken,
+	},
+	{
+		name: "Returns ErrInvalidToken when user ID doesn't match",
+
+		tokenVerifier:     new(StaleTokenVerifier),
+		refreshTokenStore: db.NewMemory(),
+		userGetter:        new(MockUserGetter),
+		tokenGenerator:    new(MockTokenGenerator),
+		inputToken:        "valid.user1",
+
+		expectedErr: usecases.ErrInvalidToken,
+	},
+	{
+		name: "Returns ErrInternal with bad UserGetter",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: db.NewMemory(),
+		userGetter:        new(BadUserGetter),
+		tokenGenerator:    new(MockTokenGenerator),
+		inputToken:        "valid.user1",
+
+		expectedErr: usecases.ErrInternal,
+	},
+	{
+		name: "Returns ErrInternal with bad TokenGenerator",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: db.NewMemory(),
+		userGetter:        new(MockUserGetter),
+		tokenGenerator:    new(BadTokenGenerator),
+		inputToken:        "valid.user1",
+
+		expectedErr: usecases.ErrInternal,
+	},
+	{
+		name: "Returns new tokens in the same family",
+
+		tokenVerifier:     new(MockTokenVerifier),
+		refreshTokenStore: db.NewMemory(),
+		userGetter:        new(MockUserGetter),
+		tokenGenerator:    new(MockTokenGenerator),
+		inputToken:        "valid.user1",
+
+		expectedErr: nil,
+		expectedTokens: entities.LoginTokens{
+			AccessToken:  "access.token.foo",
+			RefreshToken: "refresh.token.bar",
+		},
+		expectedSubjectFamily: "family-1",
+	},
+}
+
+func TestRefresh(t *testing.T) {
+	for _, tc := range refreshTests {
+		t.Run(tc.name, func(t *testing.T) {
+			deps := usecases.RefreshDependencies{
+				TokenVerifier:     tc.tokenVerifier,
+				RefreshTokenStore: tc.refreshTokenStore,
+				UserGetter:        tc.userGetter,
+				TokenGenerator:    tc.tokenGenerator,
+				TimeGetter:        new(MockTimeGetter),
+			}
+
+			tokens, err := usecases.Refresh(deps, tc.inputToken)
+
+			if err != tc.expectedErr {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+			if tokens != tc.expectedTokens {
+				t.Fatalf("Expected tokens: '%v'; Got: '%v'", tc.expectedTokens, tokens)
+			}
+
+			if store, ok := tc.refreshTokenStore.(*db.Memory); ok {
+				revoked, _ := store.IsTokenFamilyRevoked("family-1")
+				if revoked != tc.expectFamilyRevoked {
+					t.Fatalf("Expected family revoked: %v; Got: %v",
+						tc.expectFamilyRevoked, revoked)
+				}
+			}
+			if generator, ok := tc.tokenGenerator.(*MockTokenGenerator); ok {
+				if generator.subject.Family != tc.expectedSubjectFamily {
+					t.Fatalf("Expected tokens in family: '%s'; Got: '%s'",
+						tc.expectedSubjectFamily, generator.subject.Family)
+				}
+			}
+		})
+	}
+}
+
+func TestRefresh_RejectsRotatedToken(t *testing.T) {
+	store := db.NewMemory()
+	deps := usecases.RefreshDependencies{
+		TokenVerifier:     new(MockTokenVerifier),
+		RefreshTokenStore: store,
+		UserGetter:        new(MockUserGetter),
+		TokenGenerator:    new(MockTokenGenerator),
+		TimeGetter:        new(MockTimeGetter),
+	}
+
+	_, err := usecases.Refresh(deps, "valid.user1")
+	if err != nil {
+		t.Fatalf("Expected first refresh to succeed; Got: '%v'", err)
+	}
+
+	_, err = usecases.Refresh(deps, "valid.user1")
+	if err != usecases.ErrTokenReused {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrTokenReused, err)
+	}
+
+	// The rest of the family is now unusable too.
+	_, err = usecases.Refresh(deps, "valid.user2")
+	if err != usecases.ErrInvalidToken {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrInvalidToken, err)
+	}
+}
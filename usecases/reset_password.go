@@ -0,0 +1,186 @@
+package usecases
+
+import (
+	"time"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+)
+
+const DefaultResetTokenTTL = 30 * time.Minute
+
+// ForgotPasswordDependencies.ResetTokenTTL defaults to DefaultResetTokenTTL.
+// Without ResetNotifier there's nowhere safe to send tokens, so
+// ForgotPassword returns ErrResetUnavailable.
+type ForgotPasswordDependencies struct {
+	UserGetter      interfaces.UserGetter
+	TokenGenerator  interfaces.OneTimeTokenGenerator
+	ResetTokenStore interfaces.ResetTokenStore
+	ResetNotifier   interfaces.ResetNotifier
+	TimeGetter      interfaces.TimeGetter
+	ResetTokenTTL   time.Duration
+}
+
+// ForgotPassword sends the user a reset token. It succeeds whether or not
+// the user exists, so that it can't be used to find out which usernames are
+// taken. An unknown username still gets a token made and saved, under a
+// user ID no one has, so that it takes about as long; that token is never
+// sent, and each one replaces the last. For the same reason a failure to
+// send isn't returned, since only real users get that far: ResetNotifier
+// should deliver in the background and report its own failures, as
+// notify.Queue does.
+func ForgotPassword(deps ForgotPasswordDependencies, username string) error {
+	if deps.ResetNotifier == nil {
+		return ErrResetUnavailable
+	}
+	user, err := deps.UserGetter.GetUserByUsername(username)
+	if err != nil && err != ErrNotFound {
+		return ErrInternal
+	}
+	exists := err == nil
+
+	token, hash, err := deps.TokenGenerator.NewToken()
+	if err != nil {
+		return ErrInternal
+	}
+	err = deps.ResetTokenStore.SaveResetToken(entities.ResetToken{
+		Hash:      hash,
+		UserID:    user.ID,
+		Username:  user.Username,
+		ExpiresAt: deps.TimeGetter.GetTime() + resetTokenTTL(deps).Seconds(),
+	})
+	if err != nil {
+		return ErrInternal
+	}
+	if !exists {
+		return nil
+	}
+	deps.ResetNotifier.SendPasswordReset(user, token)
+	return nil
+}
+
+func resetTokenTTL(deps ForgotPasswordDependencies) time.Duration {
+	if deps.ResetTokenTTL == 0 {
+		return DefaultResetTokenTTL
+	}
+	return deps.ResetTokenTTL
+}
+
+// ResetPasswordDependencies.PassPolicy and BreachChecker are optional, as
+// for Signup. So is LoginFailures: if it's set, the user's failed logins
+// are forgotten once the password is reset, so that they can log in again
+// straight away.
+type ResetPasswordDependencies struct {
+	TokenGenerator  interfaces.OneTimeTokenGenerator
+	ResetTokenStore interfaces.ResetTokenStore
+	TimeGetter      interfaces.TimeGetter
+	UserGetter      interfaces.UserGetter
+	PassPolicy      interfaces.PasswordPolicy
+	BreachChecker   interfaces.BreachChecker
+	PassHasher      interfaces.PasswordHasher
+	PassChanger     interfaces.PasswordChanger
+	LoginFailures   interfaces.LoginFailureStore
+}
+
+// ResetPassword sets a new password with a reset token, ending every
+// session. The token is only used up once the new password has been
+// accepted, so a password that breaks the policy can be retried, and it's
+// used up before the password changes, so that it can't be used twice.
+func ResetPassword(
+	deps ResetPasswordDependencies, resetToken string, newPassword string,
+) error {
+	hash := deps.TokenGenerator.HashToken(resetToken)
+	token, err := getResetToken(deps, hash)
+	if err != nil {
+		return err
+	}
+	user, err := getResetTokenOwner(deps.UserGetter, token)
+	if err != nil {
+		return err
+	}
+	err = checkNewPassword(deps.PassPolicy, deps.BreachChecker, user.Username, newPassword)
+	if err != nil {
+		return err
+	}
+	newHash, err := hashPassword(deps.PassHasher, newPassword)
+	if err != nil {
+		return err
+	}
+
+	err = useResetToken(deps.ResetTokenStore, hash)
+	if err != nil {
+		return err
+	}
+	err = changeResetPassword(deps, token, user, newHash)
+	if err != nil {
+		return err
+	}
+	if deps.LoginFailures != nil {
+		// Best effort, like clearLoginFailures.
+		deps.LoginFailures.ClearLoginFailures(userLockoutKey(user))
+	}
+	return nil
+}
+
+// resetPasswordAttempts bounds how often ResetPassword retries a password
+// change that lost a race.
+const resetPasswordAttempts = 3
+
+// changeResetPassword re-reads the user and tries again if their hash
+// changed since it was read, for example by a login upgrading it, so that
+// a spent token isn't wasted on a lost race.
+func changeResetPassword(
+	deps ResetPasswordDependencies, token entities.ResetToken,
+	user entities.User, newHash string,
+) error {
+	for attempt := 1; ; attempt++ {
+		err := changePassword(deps.PassChanger, user, newHash)
+		if err != ErrInvalidToken || attempt == resetPasswordAttempts {
+			return err
+		}
+		user, err = getResetTokenOwner(deps.UserGetter, token)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func getResetToken(
+	deps ResetPasswordDependencies, hash string,
+) (entities.ResetToken, error) {
+	token, err := deps.ResetTokenStore.GetResetToken(hash)
+	if err == ErrNotFound {
+		return entities.ResetToken{}, ErrInvalidToken
+	}
+	if err != nil {
+		return entities.ResetToken{}, ErrInternal
+	}
+	if token.ExpiresAt <= deps.TimeGetter.GetTime() {
+		return entities.ResetToken{}, ErrInvalidToken
+	}
+	return token, nil
+}
+
+// getResetTokenOwner checks the ID as well as the username, as
+// getTokenOwner does.
+func getResetTokenOwner(
+	userGetter interfaces.UserGetter, token entities.ResetToken,
+) (entities.User, error) {
+	return getTokenOwner(userGetter, entities.TokenClaims{
+		UserID:   token.UserID,
+		Username: token.Username,
+	})
+}
+
+// useResetToken stops two concurrent resets with the same token from both
+// succeeding.
+func useResetToken(store interfaces.ResetTokenStore, hash string) error {
+	err := store.UseResetToken(hash)
+	if err == ErrNotFound {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return ErrInternal
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+package usecases_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/implementations/db"
+	"github.com/steve-kaufman/go-auth-service/usecases"
+)
+
+type MockOneTimeTokens struct {
+	err error
+}
+
+func (tokens MockOneTimeTokens) NewToken() (string, string, error) {
+	return "reset-token", "hash:reset-token", tokens.err
+}
+
+func (MockOneTimeTokens) HashToken(token string) string {
+	return "hash:" + token
+}
+
+type MockResetNotifier struct {
+	err error
+
+	user  entities.User
+	token string
+}
+
+func (notifier *MockResetNotifier) SendPasswordReset(
+	user entities.User, token string,
+) error {
+	notifier.user = user
+	notifier.token = token
+	return notifier.err
+}
+
+type ForgotPasswordTest struct {
+	name string
+
+	tokenGenerator MockOneTimeTokens
+	notifier       *MockResetNotifier
+	inputUsername  string
+
+	expectedErr   error
+	expectedToken string
+}
+
+var forgotPasswordTests = []ForgotPasswordTest{
+	{
+		name: "Returns nil without notifying for unknown user",
+
+		notifier:      new(MockResetNotifier),
+		inputUsername: "nobody",
+
+		expectedErr: nil,
+	},
+	{
+		name: "Returns ErrInternal with bad OneTimeTokenGenerator",
+
+		tokenGenerator: MockOneTimeTokens{err: errors.New("foo")},
+		notifier:       new(MockResetNotifier),
+		inputUsername:  "user1",
+
+		expectedErr: usecases.ErrInternal,
+	},
+	{
+		name: "Returns nil with bad ResetNotifier",
+
+		notifier:      &MockResetNotifier{err: errors.New("foo")},
+		inputUsername: "user1",
+
+		expectedErr:   nil,
+		expectedToken: "reset-token",
+	},
+	{
+		name: "Sends reset token",
+
+		notifier:      new(MockResetNotifier),
+		inputUsername: "user1",
+
+		expectedErr:   nil,
+		expectedToken: "reset-token",
+	},
+}
+
+func TestForgotPassword(t *testing.T) {
+	for _, tc := range forgotPasswordTests {
+		t.Run(tc.name, func(t *testing.T) {
+			store := db.NewMemory()
+			deps := usecases.ForgotPasswordDependencies{
+				UserGetter:      new(MockUserGetter),
+				TokenGenerator:  tc.tokenGenerator,
+				ResetTokenStore: store,
+				ResetNotifier:   tc.notifier,
+				TimeGetter:      new(MockTimeGetter),
+			}
+
+			err := usecases.ForgotPassword(deps, tc.inputUsername)
+
+			if err != tc.expectedErr {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+			if tc.notifier.token != tc.expectedToken {
+				t.Fatalf("Expected token sent: '%s'; Got: '%s'",
+					tc.expectedToken, tc.notifier.token)
+			}
+		})
+	}
+}
+
+func TestForgotPassword_FailingNotifierDoesNotRevealUser(t *testing.T) {
+	for _, username := range []string{"user1", "nobody"} {
+		deps := usecases.ForgotPasswordDependencies{
+			UserGetter:      new(MockUserGetter),
+			TokenGenerator:  MockOneTimeTokens{},
+			ResetTokenStore: db.NewMemory(),
+			ResetNotifier:   &MockResetNotifier{err: errors.New("foo")},
+			TimeGetter:      new(MockTimeGetter),
+		}
+
+		err := usecases.ForgotPassword(deps, username)
+
+		if err != nil {
+			t.Fatalf("Expected no error for '%s'; Got: '%v'", username, err)
+		}
+	}
+}
+
+func TestForgotPassword_IsUnavailableWithoutNotifier(t *testing.T) {
+	deps := usecases.ForgotPasswordDependencies{
+		UserGetter:      new(MockUserGetter),
+		TokenGenerator:  MockOneTimeTokens{},
+		ResetTokenStore: db.NewMemory(),
+		TimeGetter:      new(MockTimeGetter),
+	}
+
+	err := usecases.ForgotPassword(deps, "user1")
+
+	if err != usecases.ErrResetUnavailable {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrResetUnavailable, err)
+	}
+}
+
+func TestForgotPassword_SavesAnUnsentTokenForUnknownUser(t *testing.T) {
+	store := db.NewMemory()
+	notifier := new(MockResetNotifier)
+	deps := usecases.ForgotPasswordDependencies{
+		UserGetter:      new(MockUserGetter),
+		TokenGenerator:  MockOneTimeTokens{},
+		ResetTokenStore: store,
+		ResetNotifier:   notifier,
+		TimeGetter:      new(MockTimeGetter),
+	}
+
+	err := usecases.ForgotPassword(deps, "nobody")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	token, err := store.GetResetToken("hash:reset-token")
+	if err != nil {
+		t.Fatalf("Expected a token saved; Got: '%v'", err)
+	}
+	if token.UserID != 0 || token.Username != "" {
+		t.Fatalf("Expected token for no user; Got: %+v", token)
+	}
+	if notifier.token != "" {
+		t.Fatalf("Expected no token sent; Got: '%s'", notifier.token)
+	}
+}
+
+func TestForgotPassword_StoresOnlyTheHash(t *testing.T) {
+	store := db.NewMemory()
+	deps := usecases.ForgotPasswordDependencies{
+		UserGetter:      new(MockUserGetter),
+		TokenGenerator:  MockOneTimeTokens{},
+		ResetTokenStore: store,
+		ResetNotifier:   new(MockResetNotifier),
+		TimeGetter:      new(MockTimeGetter),
+		ResetTokenTTL:   time.Minute,
+	}
+
+	err := usecases.ForgotPassword(deps, "user1")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	token, err := store.GetResetToken("hash:reset-token")
+	if err != nil {
+		t.Fatalf("Expected token saved by hash; Got: '%v'", err)
+	}
+	expected := entities.ResetToken{
+		Hash: "hash:reset-token", UserID: 1, Username: "user1", ExpiresAt: 160,
+	}
+	if token != expected {
+		t.Fatalf("Expected token: %+v; Got: %+v", expected, token)
+	}
+	_, err = store.GetResetToken("reset-token")
+	if err != usecases.ErrNotFound {
+		t.Fatalf("Expected plain token not to be stored; Got: '%v'", err)
+	}
+}
+
+func setupResetPassword(t *testing.T, expiresAt float64) (
+	usecases.ResetPasswordDependencies, *MockPasswordChanger,
+) {
+	store := db.NewMemory()
+	err := store.SaveResetToken(entities.ResetToken{
+		Hash: "hash:reset-token", UserID: 1, Username: "user1", ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	passChanger := new(MockPasswordChanger)
+	return usecases.ResetPasswordDependencies{
+		TokenGenerator:  MockOneTimeTokens{},
+		ResetTokenStore: store,
+		TimeGetter:      new(MockTimeGetter),
+		UserGetter:      new(MockUserGetter),
+		PassPolicy:      new(MockPasswordPolicy),
+		PassHasher:      new(MockPasswordHasher),
+		PassChanger:     passChanger,
+	}, passChanger
+}
+
+type ResetPasswordTest struct {
+	name string
+
+	expiresAt        float64
+	inputToken       string
+	inputNewPassword string
+
+	expectedErr     error
+	expectedNewHash string
+}
+
+var resetPasswordTests = []ResetPasswordTest{
+	{
+		name: "Returns ErrInvalidToken with unknown token",
+
+		expiresAt:        200,
+		inputToken:       "forged-token",
+		inputNewPassword: "newpassword",
+
+		expectedErr: usecases.ErrInvalidToken,
+	},
+	{
+		name: "Returns ErrInvalidToken with expired token",
+
+		expiresAt:        100,
+		inputToken:       "reset-token",
+		inputNewPassword: "newpassword",
+
+		expectedErr: usecases.ErrInvalidToken,
+	},
+	{
+		name: "Resets password",
+
+		expiresAt:        200,
+		inputToken:       "reset-token",
+		inputNewPassword: "newpassword",
+
+		expectedErr:     nil,
+		expectedNewHash: mockHash("newpassword"),
+	},
+}
+
+func TestResetPassword(t *testing.T) {
+	for _, tc := range resetPasswordTests {
+		t.Run(tc.name, func(t *testing.T) {
+			deps, passChanger := setupResetPassword(t, tc.expiresAt)
+
+			err := usecases.ResetPassword(deps, tc.inputToken, tc.inputNewPassword)
+
+			if err != tc.expectedErr {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+			if passChanger.newHash != tc.expectedNewHash {
+				t.Fatalf("Expected new hash: '%s'; Got: '%s'",
+					tc.expectedNewHash, passChanger.newHash)
+			}
+		})
+	}
+}
+
+func TestResetPassword_TokenWorksOnce(t *testing.T) {
+	deps, _ := setupResetPassword(t, 200)
+
+	err := usecases.ResetPassword(deps, "reset-token", "newpassword")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	err = usecases.ResetPassword(deps, "reset-token", "otherpassword")
+	if err != usecases.ErrInvalidToken {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrInvalidToken, err)
+	}
+}
+
+func TestResetPassword_RejectedPasswordDoesNotUseToken(t *testing.T) {
+	deps, passChanger := setupResetPassword(t, 200)
+
+	err := usecases.ResetPassword(deps, "reset-token", "short")
+	if _, ok := err.(*usecases.PasswordPolicyError); !ok {
+		t.Fatalf("Expected a PasswordPolicyError; Got: '%v'", err)
+	}
+
+	err = usecases.ResetPassword(deps, "reset-token", "newpassword")
+	if err != nil {
+		t.Fatalf("Expected token to still work; Got: '%v'", err)
+	}
+	if passChanger.newHash != mockHash("newpassword") {
+		t.Fatalf("Expected new hash: '%s'; Got: '%s'",
+			mockHash("newpassword"), passChanger.newHash)
+	}
+}
+
+// RacedPasswordChanger fails its first change as if the hash had changed
+// since it was read.
+type RacedPasswordChanger struct {
+	MockPasswordChanger
+	raced bool
+}
+
+func (changer *RacedPasswordChanger) ChangePassword(
+	userID int, oldHash string, newHash string,
+) error {
+	if !changer.raced {
+		changer.raced = true
+		return usecases.ErrNotFound
+	}
+	return changer.MockPasswordChanger.ChangePassword(userID, oldHash, newHash)
+}
+
+func TestResetPassword_RetriesALostRace(t *testing.T) {
+	deps, _ := setupResetPassword(t, 200)
+	passChanger := new(RacedPasswordChanger)
+	deps.PassChanger = passChanger
+
+	err := usecases.ResetPassword(deps, "reset-token", "newpassword")
+
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+	if passChanger.newHash != mockHash("newpassword") {
+		t.Fatalf("Expected new hash: '%s'; Got: '%s'",
+			mockHash("newpassword"), passChanger.newHash)
+	}
+}
@@ -0,0 +1,208 @@
+package usecases
+
+import (
+	"time"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+)
+
+type ServiceDependencies struct {
+	UserGetter        interfaces.UserGetter
+	UserCreator       interfaces.UserCreator
+	UserUpdater       interfaces.UserUpdater
+	PassPolicy        interfaces.PasswordPolicy
+	BreachChecker     interfaces.BreachChecker
+	PassHasher        interfaces.PasswordHasher
+	PassMatcher       interfaces.PasswordMatcher
+	PassRehasher      interfaces.PasswordRehasher
+	PassUpdater       interfaces.PasswordUpdater
+	PassChanger       interfaces.PasswordChanger
+	TokenGenerator    interfaces.TokenGenerator
+	TokenVerifier     interfaces.TokenVerifier
+	RefreshTokenStore interfaces.RefreshTokenStore
+	OneTimeTokens     interfaces.OneTimeTokenGenerator
+	ResetTokenStore   interfaces.ResetTokenStore
+	ResetNotifier     interfaces.ResetNotifier
+	TimeGetter        interfaces.TimeGetter
+	// MaxTokenLifetime is the longer of the access and refresh token
+	// lifetimes.
+	MaxTokenLifetime time.Duration
+	ResetTokenTTL    time.Duration
+	// RequireVerifiedEmail makes email required at signup, and refuses
+	// logins until it's verified.
+	RequireVerifiedEmail bool
+	EmailVerifier        interfaces.EmailVerifier
+	VerificationStore    interfaces.EmailVerificationStore
+	VerificationNotifier interfaces.EmailVerificationNotifier
+	VerificationTokenTTL time.Duration
+	// LoginFailures is only needed if Lockout is enabled.
+	LoginFailures interfaces.LoginFailureStore
+	Lockout       LockoutPolicy
+}
+
+// Service implements interfaces.Service by handing each call to the
+// matching usecase with the dependencies it needs.
+type Service struct {
+	deps      ServiceDependencies
+	dummyHash string
+}
+
+func NewService(deps ServiceDependencies) *Service {
+	service := new(Service)
+	service.deps = deps
+	service.dummyHash = makeDummyHash(deps.PassHasher)
+	return service
+}
+
+// makeDummyHash makes a hash for Login to match against unknown
+// identifiers. It never logs anyone in, so the password doesn't matter. If
+// hashing fails, Login goes without.
+func makeDummyHash(passHasher interfaces.PasswordHasher) string {
+	if passHasher == nil {
+		return ""
+	}
+	hash, err := passHasher.HashPassword("dummy password")
+	if err != nil {
+		return ""
+	}
+	return hash
+}
+
+func (service Service) Login(
+	identifier string, password string,
+) (entities.LoginTokens, error) {
+	return Login(LoginDependencies{
+		UserGetter:     service.deps.UserGetter,
+		PassMatcher:    service.deps.PassMatcher,
+		PassRehasher:   service.deps.PassRehasher,
+		PassUpdater:    service.deps.PassUpdater,
+		TokenGenerator: service.deps.TokenGenerator,
+
+		RequireVerifiedEmail: service.deps.RequireVerifiedEmail,
+		DummyHash:            service.dummyHash,
+
+		LoginFailures: service.deps.LoginFailures,
+		TimeGetter:    service.deps.TimeGetter,
+		Lockout:       service.deps.Lockout,
+	}, identifier, password)
+}
+
+func (service Service) Signup(username string, password string, email string) error {
+	return Signup(SignupDependencies{
+		PassPolicy:           service.deps.PassPolicy,
+		BreachChecker:        service.deps.BreachChecker,
+		PassHasher:           service.deps.PassHasher,
+		UserCreator:          service.deps.UserCreator,
+		RequireEmail:         service.deps.RequireVerifiedEmail,
+		UserGetter:           service.deps.UserGetter,
+		TokenGenerator:       service.deps.OneTimeTokens,
+		VerificationStore:    service.deps.VerificationStore,
+		VerificationNotifier: service.deps.VerificationNotifier,
+		TimeGetter:           service.deps.TimeGetter,
+		VerificationTokenTTL: service.deps.VerificationTokenTTL,
+	}, username, password, email)
+}
+
+func (service Service) VerifyEmail(verificationToken string) error {
+	return VerifyEmail(VerifyEmailDependencies{
+		TokenGenerator:    service.deps.OneTimeTokens,
+		VerificationStore: service.deps.VerificationStore,
+		TimeGetter:        service.deps.TimeGetter,
+		EmailVerifier:     service.deps.EmailVerifier,
+	}, verificationToken)
+}
+
+func (service Service) ResendEmailVerification(username string) error {
+	return ResendEmailVerification(ResendEmailVerificationDependencies{
+		UserGetter:           service.deps.UserGetter,
+		TokenGenerator:       service.deps.OneTimeTokens,
+		VerificationStore:    service.deps.VerificationStore,
+		VerificationNotifier: service.deps.VerificationNotifier,
+		TimeGetter:           service.deps.TimeGetter,
+		VerificationTokenTTL: service.deps.VerificationTokenTTL,
+	}, username)
+}
+
+func (service Service) ChangePassword(
+	accessToken string, currentPassword string, newPassword string,
+) (entities.LoginTokens, error) {
+	return ChangePassword(ChangePasswordDependencies{
+		TokenVerifier:     service.deps.TokenVerifier,
+		RefreshTokenStore: service.deps.RefreshTokenStore,
+		UserGetter:        service.deps.UserGetter,
+		PassMatcher:       service.deps.PassMatcher,
+		PassPolicy:        service.deps.PassPolicy,
+		BreachChecker:     service.deps.BreachChecker,
+		PassHasher:        service.deps.PassHasher,
+		PassChanger:       service.deps.PassChanger,
+		TokenGenerator:    service.deps.TokenGenerator,
+		LoginFailures:     service.deps.LoginFailures,
+		TimeGetter:        service.deps.TimeGetter,
+		Lockout:           service.deps.Lockout,
+	}, accessToken, currentPassword, newPassword)
+}
+
+func (service Service) ForgotPassword(username string) error {
+	return ForgotPassword(ForgotPasswordDependencies{
+		UserGetter:      service.deps.UserGetter,
+		TokenGenerator:  service.deps.OneTimeTokens,
+		ResetTokenStore: service.deps.ResetTokenStore,
+		ResetNotifier:   service.deps.ResetNotifier,
+		TimeGetter:      service.deps.TimeGetter,
+		ResetTokenTTL:   service.deps.ResetTokenTTL,
+	}, username)
+}
+
+func (service Service) ResetPassword(resetToken string, newPassword string) error {
+	return ResetPassword(ResetPasswordDependencies{
+		TokenGenerator:  service.deps.OneTimeTokens,
+		ResetTokenStore: service.deps.ResetTokenStore,
+		TimeGetter:      service.deps.TimeGetter,
+		UserGetter:      service.deps.UserGetter,
+		PassPolicy:      service.deps.PassPolicy,
+		BreachChecker:   service.deps.BreachChecker,
+		PassHasher:      service.deps.PassHasher,
+		PassChanger:     service.deps.PassChanger,
+		LoginFailures:   service.deps.LoginFailures,
+	}, resetToken, newPassword)
+}
+
+func (service Service) Refresh(refreshToken string) (entities.LoginTokens, error) {
+	return Refresh(RefreshDependencies{
+		TokenVerifier:     service.deps.TokenVerifier,
+		RefreshTokenStore: service.deps.RefreshTokenStore,
+		UserGetter:        service.deps.UserGetter,
+		TokenGenerator:    service.deps.TokenGenerator,
+		TimeGetter:        service.deps.TimeGetter,
+		MaxTokenLifetime:  service.deps.MaxTokenLifetime,
+	}, refreshToken)
+}
+
+func (service Service) Logout(refreshToken string) error {
+	return Logout(LogoutDependencies{
+		TokenVerifier:     service.deps.TokenVerifier,
+		RefreshTokenStore: service.deps.RefreshTokenStore,
+		TimeGetter:        service.deps.TimeGetter,
+		MaxTokenLifetime:  service.deps.MaxTokenLifetime,
+	}, refreshToken)
+}
+
+func (service Service) LogoutEverywhere(accessToken string) error {
+	return LogoutEverywhere(LogoutEverywhereDependencies{
+		TokenVerifier:     service.deps.TokenVerifier,
+		RefreshTokenStore: service.deps.RefreshTokenStore,
+		UserGetter:        service.deps.UserGetter,
+		UserUpdater:       service.deps.UserUpdater,
+	}, accessToken)
+}
+
+// Authenticate checks access tokens against logouts, which the stateless
+// TokenVerifier can't.
+func (service Service) Authenticate(accessToken string) (entities.TokenClaims, error) {
+	return Authenticate(AuthenticateDependencies{
+		TokenVerifier:     service.deps.TokenVerifier,
+		RefreshTokenStore: service.deps.RefreshTokenStore,
+		UserGetter:        service.deps.UserGetter,
+	}, accessToken)
+}
@@ -0,0 +1,234 @@
+package usecases_test
+
+import (
+	"testing"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/implementations/db"
+	"github.com/steve-kaufman/go-auth-service/implementations/security/jwtgen"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+	"github.com/steve-kaufman/go-auth-service/usecases"
+)
+
+var _ interfaces.Service = new(usecases.Service)
+
+type MockTimeGetter struct{}
+
+func (MockTimeGetter) GetTime() float64 {
+	return 100
+}
+
+// setupService wires the usecases to real tokens and an in-memory store.
+func setupService() *usecases.Service {
+	return usecases.NewService(serviceDependencies())
+}
+
+func serviceDependencies() usecases.ServiceDependencies {
+	store := db.NewMemory()
+	config := jwtgen.Config{Secrets: jwtgen.Secrets{
+		Access:  "fake_access_secret",
+		Refresh: "fake_refresh_secret",
+	}}
+	timeGetter := new(MockTimeGetter)
+	return usecases.ServiceDependencies{
+		UserGetter:  store,
+		UserCreator: store,
+		UserUpdater: store,
+		PassHasher:  new(MockPasswordHasher),
+		PassMatcher: new(MockPasswordMatcher),
+		PassChanger: store,
+		TokenGenerator: jwtgen.NewGenerator(
+			config, timeGetter, new(jwtgen.RandomIDGetter),
+		),
+		TokenVerifier:     jwtgen.NewVerifier(config, timeGetter),
+		RefreshTokenStore: store,
+		OneTimeTokens:     MockOneTimeTokens{},
+		ResetTokenStore:   store,
+		ResetNotifier:     new(MockResetNotifier),
+		TimeGetter:        timeGetter,
+
+		EmailVerifier:        store,
+		VerificationStore:    store,
+		VerificationNotifier: new(MockVerificationNotifier),
+	}
+}
+
+func setupLoggedIn(t *testing.T) (*usecases.Service, entities.LoginTokens) {
+	service := setupService()
+
+	err := service.Signup("newuser", "pass", "")
+	if err != nil {
+		t.Fatalf("Expected no error on signup; Got: '%v'", err)
+	}
+
+	tokens, err := service.Login("newuser", "pass")
+	if err != nil {
+		t.Fatalf("Expected no error on login; Got: '%v'", err)
+	}
+	return service, tokens
+}
+
+func expectErr(t *testing.T, expectedErr error, err error) {
+	t.Helper()
+	if err != expectedErr {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", expectedErr, err)
+	}
+}
+
+func TestService_SignupThenLogin(t *testing.T) {
+	service, tokens := setupLoggedIn(t)
+
+	claims, err := service.Authenticate(tokens.AccessToken)
+	expectErr(t, nil, err)
+	if claims.Username != "newuser" {
+		t.Fatalf("Expected token for: 'newuser'; Got: '%s'", claims.Username)
+	}
+}
+
+func TestService_SignupTwice_ReturnsErrDuplicate(t *testing.T) {
+	service, _ := setupLoggedIn(t)
+
+	err := service.Signup("newuser", "otherpass", "")
+	expectErr(t, usecases.ErrDuplicate, err)
+}
+
+func TestService_Login_WithBadPassword(t *testing.T) {
+	service, _ := setupLoggedIn(t)
+
+	_, err := service.Login("newuser", "wrongpass")
+	expectErr(t, usecases.ErrBadCredentials, err)
+}
+
+func TestService_Login_WithEmail(t *testing.T) {
+	service := setupService()
+
+	err := service.Signup("newuser", "pass", "new@example.com")
+	expectErr(t, nil, err)
+
+	tokens, err := service.Login("New@Example.com", "pass")
+	expectErr(t, nil, err)
+	claims, err := service.Authenticate(tokens.AccessToken)
+	expectErr(t, nil, err)
+	if claims.Username != "newuser" {
+		t.Fatalf("Expected token for: 'newuser'; Got: '%s'", claims.Username)
+	}
+
+	_, err = service.Login("other@example.com", "pass")
+	expectErr(t, usecases.ErrBadCredentials, err)
+}
+
+func TestService_Refresh_RotatesRefreshToken(t *testing.T) {
+	service, tokens := setupLoggedIn(t)
+
+	newTokens, err := service.Refresh(tokens.RefreshToken)
+	expectErr(t, nil, err)
+
+	_, err = service.Refresh(tokens.RefreshToken)
+	expectErr(t, usecases.ErrTokenReused, err)
+
+	// Reuse revoked the whole family, including the new tokens.
+	_, err = service.Refresh(newTokens.RefreshToken)
+	expectErr(t, usecases.ErrInvalidToken, err)
+	_, err = service.Authenticate(newTokens.AccessToken)
+	expectErr(t, usecases.ErrInvalidToken, err)
+}
+
+func TestService_Logout_EndsOnlyThatSession(t *testing.T) {
+	service, tokens := setupLoggedIn(t)
+	otherTokens, err := service.Login("newuser", "pass")
+	expectErr(t, nil, err)
+
+	err = service.Logout(tokens.RefreshToken)
+	expectErr(t, nil, err)
+
+	_, err = service.Refresh(tokens.RefreshToken)
+	expectErr(t, usecases.ErrInvalidToken, err)
+	_, err = service.Authenticate(tokens.AccessToken)
+	expectErr(t, usecases.ErrInvalidToken, err)
+
+	_, err = service.Authenticate(otherTokens.AccessToken)
+	expectErr(t, nil, err)
+}
+
+func TestService_LogoutEverywhere_EndsEverySession(t *testing.T) {
+	service, tokens := setupLoggedIn(t)
+	otherTokens, err := service.Login("newuser", "pass")
+	expectErr(t, nil, err)
+
+	err = service.LogoutEverywhere(tokens.AccessToken)
+	expectErr(t, nil, err)
+
+	for _, oldTokens := range []entities.LoginTokens{tokens, otherTokens} {
+		_, err = service.Authenticate(oldTokens.AccessToken)
+		expectErr(t, usecases.ErrInvalidToken, err)
+		_, err = service.Refresh(oldTokens.RefreshToken)
+		expectErr(t, usecases.ErrInvalidToken, err)
+	}
+
+	newTokens, err := service.Login("newuser", "pass")
+	expectErr(t, nil, err)
+	_, err = service.Authenticate(newTokens.AccessToken)
+	expectErr(t, nil, err)
+}
+
+func TestService_ChangePassword_EndsOtherSessions(t *testing.T) {
+	service, tokens := setupLoggedIn(t)
+	otherTokens, err := service.Login("newuser", "pass")
+	expectErr(t, nil, err)
+
+	newTokens, err := service.ChangePassword(tokens.AccessToken, "pass", "newpass")
+	expectErr(t, nil, err)
+
+	for _, oldTokens := range []entities.LoginTokens{tokens, otherTokens} {
+		_, err = service.Authenticate(oldTokens.AccessToken)
+		expectErr(t, usecases.ErrInvalidToken, err)
+		_, err = service.Refresh(oldTokens.RefreshToken)
+		expectErr(t, usecases.ErrInvalidToken, err)
+	}
+	_, err = service.Authenticate(newTokens.AccessToken)
+	expectErr(t, nil, err)
+
+	_, err = service.Login("newuser", "pass")
+	expectErr(t, usecases.ErrBadCredentials, err)
+	_, err = service.Login("newuser", "newpass")
+	expectErr(t, nil, err)
+}
+
+func TestService_ResetPassword_EndsEverySession(t *testing.T) {
+	service, tokens := setupLoggedIn(t)
+
+	err := service.ForgotPassword("newuser")
+	expectErr(t, nil, err)
+	err = service.ResetPassword("reset-token", "newpass")
+	expectErr(t, nil, err)
+
+	_, err = service.Authenticate(tokens.AccessToken)
+	expectErr(t, usecases.ErrInvalidToken, err)
+	_, err = service.Refresh(tokens.RefreshToken)
+	expectErr(t, usecases.ErrInvalidToken, err)
+
+	_, err = service.Login("newuser", "pass")
+	expectErr(t, usecases.ErrBadCredentials, err)
+	_, err = service.Login("newuser", "newpass")
+	expectErr(t, nil, err)
+}
+
+func TestService_VerifyEmail(t *testing.T) {
+	deps := serviceDependencies()
+	deps.OneTimeTokens = MockVerificationTokens{}
+	service := usecases.NewService(deps)
+
+	err := service.Signup("newuser", "pass", "New@Example.com")
+	expectErr(t, nil, err)
+
+	err = service.VerifyEmail("verification-token")
+	expectErr(t, nil, err)
+	err = service.VerifyEmail("verification-token")
+	expectErr(t, usecases.ErrInvalidToken, err)
+
+	// Already verified, so there's nothing to resend.
+	err = service.ResendEmailVerification("newuser")
+	expectErr(t, nil, err)
+	err = service.VerifyEmail("verification-token")
+	expectErr(t, usecases.ErrInvalidToken, err)
+}
@@ -1,20 +1,51 @@
 package usecases
 
 import (
+	"net/mail"
+	"strings"
+	"time"
+
 	"github.com/steve-kaufman/go-auth-service/entities"
 	"github.com/steve-kaufman/go-auth-service/interfaces"
 )
 
+// SignupDependencies.PassPolicy and BreachChecker are optional. Without them
+// any password is accepted. Without VerificationNotifier no verification
+// email is sent, and the rest of the verification fields are unused.
 type SignupDependencies struct {
-	UserGetter  interfaces.UserGetter
-	PassHasher  interfaces.PasswordHasher
-	UserCreator interfaces.UserCreator
+	PassPolicy    interfaces.PasswordPolicy
+	BreachChecker interfaces.BreachChecker
+	PassHasher    interfaces.PasswordHasher
+	UserCreator   interfaces.UserCreator
+	// RequireEmail refuses signups without an email, for when logins need a
+	// verified one.
+	RequireEmail         bool
+	UserGetter           interfaces.UserGetter
+	TokenGenerator       interfaces.OneTimeTokenGenerator
+	VerificationStore    interfaces.EmailVerificationStore
+	VerificationNotifier interfaces.EmailVerificationNotifier
+	TimeGetter           interfaces.TimeGetter
+	VerificationTokenTTL time.Duration
 }
 
+// Signup relies on UserCreator to enforce unique usernames and emails.
+// Checking for an existing user first would leave a window where two
+// concurrent signups for the same username both pass the check.
+//
+// email is optional. If it's given, a verification token is sent to it once
+// the user is created. A failure to send isn't returned, as for
+// ResendEmailVerification, which can send the token again.
 func Signup(
-	deps SignupDependencies, username string, password string,
+	deps SignupDependencies, username string, password string, email string,
 ) error {
-	err := checkUsernameIsUnique(deps.UserGetter, username)
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	if email == "" && deps.RequireEmail {
+		return ErrEmailRequired
+	}
+	err = checkNewPassword(deps.PassPolicy, deps.BreachChecker, username, password)
 	if err != nil {
 		return err
 	}
@@ -24,18 +55,53 @@ func Signup(
 		return err
 	}
 
-	return attemptCreateUser(deps.UserCreator, username, hashedPass)
+	err = attemptCreateUser(deps.UserCreator, entities.User{
+		Username: username,
+		Password: hashedPass,
+		Email:    email,
+	})
+	if err != nil || email == "" || deps.VerificationNotifier == nil {
+		return err
+	}
+	return sendSignupVerification(deps, username)
+}
+
+// normalizeEmail accepts a bare address, and lowercases it so that the same
+// address can't be registered twice in different cases. An empty email is
+// left empty.
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", nil
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(email), nil
 }
 
-func checkUsernameIsUnique(
-	userGetter interfaces.UserGetter, username string,
+// checkNewPassword fails closed: a password that can't be checked against
+// the breach corpus isn't accepted.
+func checkNewPassword(
+	passPolicy interfaces.PasswordPolicy, breachChecker interfaces.BreachChecker,
+	username string, password string,
 ) error {
-	_, err := userGetter.GetUserByUsername(username)
-	if err == nil {
-		return ErrDuplicate
+	var violations []string
+	if passPolicy != nil {
+		violations = passPolicy.CheckPassword(username, password)
 	}
-	if err != ErrNotFound {
-		return ErrInternal
+	if breachChecker != nil {
+		breached, err := breachChecker.IsBreached(password)
+		if err != nil {
+			return ErrInternal
+		}
+		if breached {
+			violations = append(violations, "has appeared in a data breach")
+		}
+	}
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
 	}
 	return nil
 }
@@ -50,15 +116,29 @@ func hashPassword(
 	return hashedPass, nil
 }
 
-func attemptCreateUser(
-	userCreator interfaces.UserCreator, username string, hashedPass string,
-) error {
-	err := userCreator.CreateUser(entities.User{
-		Username: username,
-		Password: hashedPass,
-	})
+func attemptCreateUser(userCreator interfaces.UserCreator, user entities.User) error {
+	err := userCreator.CreateUser(user)
+	if err == ErrDuplicate || err == ErrDuplicateEmail {
+		return err
+	}
 	if err != nil {
 		return ErrInternal
 	}
 	return nil
 }
+
+// sendSignupVerification reads the new user back for their ID.
+func sendSignupVerification(deps SignupDependencies, username string) error {
+	user, err := deps.UserGetter.GetUserByUsername(username)
+	if err != nil {
+		return ErrInternal
+	}
+	return sendEmailVerification(ResendEmailVerificationDependencies{
+		UserGetter:           deps.UserGetter,
+		TokenGenerator:       deps.TokenGenerator,
+		VerificationStore:    deps.VerificationStore,
+		VerificationNotifier: deps.VerificationNotifier,
+		TimeGetter:           deps.TimeGetter,
+		VerificationTokenTTL: deps.VerificationTokenTTL,
+	}, user)
+}
@@ -1,11 +1,14 @@
 package usecases_test
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/implementations/db"
 	"github.com/steve-kaufman/go-auth-service/interfaces"
 	"github.com/steve-kaufman/go-auth-service/usecases"
 )
@@ -27,6 +30,11 @@ type MockUserCreator struct {
 }
 
 func (uc *MockUserCreator) CreateUser(user entities.User) error {
+	for _, existingUser := range exampleUsers {
+		if existingUser.Username == user.Username {
+			return usecases.ErrDuplicate
+		}
+	}
 	user.ID = 7
 	uc.createdUser = user
 	return nil
@@ -41,7 +49,6 @@ func (BadUserCreator) CreateUser(user entities.User) error {
 type SignupTest struct {
 	name string
 
-	userGetter  interfaces.UserGetter
 	passHasher  interfaces.PasswordHasher
 	userCreator interfaces.UserCreator
 
@@ -53,22 +60,9 @@ type SignupTest struct {
 }
 
 var signupTests = []SignupTest{
-	{
-		name: "Returs ErrInternal with bad UserGetter",
-
-		userGetter:  new(BadUserGetter),
-		passHasher:  new(MockPasswordHasher),
-		userCreator: new(MockUserCreator),
-
-		inputUsername: "newuser",
-		inputPassword: "supersecret",
-
-		expectedErr: usecases.ErrInternal,
-	},
 	{
 		name: "Returs ErrDuplicate with already existing username",
 
-		userGetter:  new(MockUserGetter),
 		passHasher:  new(MockPasswordHasher),
 		userCreator: new(MockUserCreator),
 
@@ -78,9 +72,8 @@ var signupTests = []SignupTest{
 		expectedErr: usecases.ErrDuplicate,
 	},
 	{
-		name: "Succeeds with good UserGetter and new username",
+		name: "Succeeds with new username",
 
-		userGetter:  new(MockUserGetter),
 		passHasher:  new(MockPasswordHasher),
 		userCreator: new(MockUserCreator),
 
@@ -97,7 +90,6 @@ var signupTests = []SignupTest{
 	{
 		name: "Returns ErrInternal with bad PasswordHasher",
 
-		userGetter:  new(MockUserGetter),
 		passHasher:  new(BadPasswordHasher),
 		userCreator: new(MockUserCreator),
 
@@ -109,7 +101,6 @@ var signupTests = []SignupTest{
 	{
 		name: "Returns ErrInternal with bad UserCreator",
 
-		userGetter:  new(MockUserGetter),
 		passHasher:  new(MockPasswordHasher),
 		userCreator: new(BadUserCreator),
 
@@ -124,12 +115,11 @@ func TestSignup(t *testing.T) {
 	for _, tc := range signupTests {
 		t.Run(tc.name, func(t *testing.T) {
 			deps := usecases.SignupDependencies{
-				UserGetter:  tc.userGetter,
 				PassHasher:  tc.passHasher,
 				UserCreator: tc.userCreator,
 			}
 
-			err := usecases.Signup(deps, tc.inputUsername, tc.inputPassword)
+			err := usecases.Signup(deps, tc.inputUsername, tc.inputPassword, "")
 			if err != tc.expectedErr {
 				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
 			}
@@ -144,3 +134,241 @@ func TestSignup(t *testing.T) {
 		})
 	}
 }
+
+func TestSignup_OnlyOneConcurrentSignupWins(t *testing.T) {
+	const attempts = 50
+
+	deps := usecases.SignupDependencies{
+		PassHasher:  new(MockPasswordHasher),
+		UserCreator: db.NewMemory(),
+	}
+
+	errs := make(chan error, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- usecases.Signup(deps, "newuser", "supersecret", "")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	successes := 0
+	for err := range errs {
+		if err == nil {
+			successes++
+			continue
+		}
+		if err != usecases.ErrDuplicate {
+			t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrDuplicate, err)
+		}
+	}
+	if successes != 1 {
+		t.Fatalf("Expected exactly 1 successful signup; Got: %d", successes)
+	}
+}
+
+// MockPasswordPolicy rejects passwords shorter than 8 bytes and any that
+// equal the username.
+type MockPasswordPolicy struct{}
+
+func (MockPasswordPolicy) CheckPassword(username string, password string) []string {
+	var violations []string
+	if len(password) < 8 {
+		violations = append(violations, "too short")
+	}
+	if password == username {
+		violations = append(violations, "same as username")
+	}
+	return violations
+}
+
+func TestSignup_RejectsPasswordAgainstPolicy(t *testing.T) {
+	userCreator := new(MockUserCreator)
+	deps := usecases.SignupDependencies{
+		PassPolicy:  new(MockPasswordPolicy),
+		PassHasher:  new(MockPasswordHasher),
+		UserCreator: userCreator,
+	}
+
+	err := usecases.Signup(deps, "bob", "bob", "")
+
+	policyErr, ok := err.(*usecases.PasswordPolicyError)
+	if !ok {
+		t.Fatalf("Expected a PasswordPolicyError; Got: '%v'", err)
+	}
+	expectedViolations := []string{"too short", "same as username"}
+	if diff := cmp.Diff(expectedViolations, policyErr.Violations); diff != "" {
+		t.Fatalf("Expected every violation: \n%s", diff)
+	}
+	if userCreator.createdUser != (entities.User{}) {
+		t.Fatalf("Expected no user to be created; Got: %+v", userCreator.createdUser)
+	}
+}
+
+func TestSignup_AcceptsPasswordThatMeetsPolicy(t *testing.T) {
+	deps := usecases.SignupDependencies{
+		PassPolicy:  new(MockPasswordPolicy),
+		PassHasher:  new(MockPasswordHasher),
+		UserCreator: new(MockUserCreator),
+	}
+
+	err := usecases.Signup(deps, "newuser", "supersecret", "")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+}
+
+type MockBreachChecker struct {
+	err error
+}
+
+func (checker MockBreachChecker) IsBreached(password string) (bool, error) {
+	return password == "password1", checker.err
+}
+
+func TestSignup_RejectsBreachedPassword(t *testing.T) {
+	userCreator := new(MockUserCreator)
+	deps := usecases.SignupDependencies{
+		PassPolicy:    new(MockPasswordPolicy),
+		BreachChecker: new(MockBreachChecker),
+		PassHasher:    new(MockPasswordHasher),
+		UserCreator:   userCreator,
+	}
+
+	err := usecases.Signup(deps, "password1", "password1", "")
+
+	policyErr, ok := err.(*usecases.PasswordPolicyError)
+	if !ok {
+		t.Fatalf("Expected a PasswordPolicyError; Got: '%v'", err)
+	}
+	expectedViolations := []string{"same as username", "has appeared in a data breach"}
+	if diff := cmp.Diff(expectedViolations, policyErr.Violations); diff != "" {
+		t.Fatalf("Expected every violation: \n%s", diff)
+	}
+	if userCreator.createdUser != (entities.User{}) {
+		t.Fatalf("Expected no user to be created; Got: %+v", userCreator.createdUser)
+	}
+}
+
+func TestSignup_ReturnsErrInternalWhenBreachCheckFails(t *testing.T) {
+	deps := usecases.SignupDependencies{
+		BreachChecker: MockBreachChecker{err: errors.New("foo")},
+		PassHasher:    new(MockPasswordHasher),
+		UserCreator:   new(MockUserCreator),
+	}
+
+	err := usecases.Signup(deps, "newuser", "supersecret", "")
+	if err != usecases.ErrInternal {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrInternal, err)
+	}
+}
+
+type SignupEmailTest struct {
+	name string
+
+	requireEmail bool
+	inputEmail   string
+
+	expectedErr   error
+	expectedEmail string
+	expectedToken string
+}
+
+var signupEmailTests = []SignupEmailTest{
+	{
+		name: "Signs up without email when not required",
+
+		inputEmail: "",
+
+		expectedErr: nil,
+	},
+	{
+		name: "Returns ErrEmailRequired without email when required",
+
+		requireEmail: true,
+		inputEmail:   "  ",
+
+		expectedErr: usecases.ErrEmailRequired,
+	},
+	{
+		name: "Returns ErrInvalidEmail with malformed email",
+
+		inputEmail: "not an email",
+
+		expectedErr: usecases.ErrInvalidEmail,
+	},
+	{
+		name: "Returns ErrInvalidEmail with display name",
+
+		inputEmail: "New User <new@example.com>",
+
+		expectedErr: usecases.ErrInvalidEmail,
+	},
+	{
+		name: "Returns ErrDuplicateEmail with taken email in another case",
+
+		inputEmail: "Taken@Example.com",
+
+		expectedErr: usecases.ErrDuplicateEmail,
+	},
+	{
+		name: "Normalizes email and sends verification token",
+
+		requireEmail: true,
+		inputEmail:   " New@Example.COM ",
+
+		expectedErr:   nil,
+		expectedEmail: "new@example.com",
+		expectedToken: "verification-token",
+	},
+}
+
+func TestSignup_WithEmail(t *testing.T) {
+	for _, tc := range signupEmailTests {
+		t.Run(tc.name, func(t *testing.T) {
+			store := db.NewMemory()
+			store.CreateUser(entities.User{
+				Username: "olduser", Password: "foo", Email: "taken@example.com",
+			})
+			notifier := new(MockVerificationNotifier)
+			deps := usecases.SignupDependencies{
+				PassHasher:           new(MockPasswordHasher),
+				UserCreator:          store,
+				RequireEmail:         tc.requireEmail,
+				UserGetter:           store,
+				TokenGenerator:       MockVerificationTokens{},
+				VerificationStore:    store,
+				VerificationNotifier: notifier,
+				TimeGetter:           new(MockTimeGetter),
+			}
+
+			err := usecases.Signup(deps, "newuser", "supersecret", tc.inputEmail)
+
+			if err != tc.expectedErr {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+			if notifier.token != tc.expectedToken {
+				t.Fatalf("Expected token sent: '%s'; Got: '%s'",
+					tc.expectedToken, notifier.token)
+			}
+			if tc.expectedErr != nil {
+				return
+			}
+			user, err := store.GetUserByUsername("newuser")
+			if err != nil {
+				t.Fatalf("Expected user to be created; Got: '%v'", err)
+			}
+			if user.Email != tc.expectedEmail || user.EmailVerified {
+				t.Fatalf("Expected unverified email: '%s'; Got: '%s', verified: %v",
+					tc.expectedEmail, user.Email, user.EmailVerified)
+			}
+			if tc.expectedToken != "" && notifier.user.Email != tc.expectedEmail {
+				t.Fatalf("Expected token sent to: '%s'; Got: '%s'",
+					tc.expectedEmail, notifier.user.Email)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package usecases
+
+import (
+	"time"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/interfaces"
+)
+
+const DefaultEmailVerificationTTL = 24 * time.Hour
+
+// ResendEmailVerificationDependencies.VerificationTokenTTL defaults to
+// DefaultEmailVerificationTTL. Without VerificationNotifier nothing is sent,
+// as for Signup.
+type ResendEmailVerificationDependencies struct {
+	UserGetter           interfaces.UserGetter
+	TokenGenerator       interfaces.OneTimeTokenGenerator
+	VerificationStore    interfaces.EmailVerificationStore
+	VerificationNotifier interfaces.EmailVerificationNotifier
+	TimeGetter           interfaces.TimeGetter
+	VerificationTokenTTL time.Duration
+}
+
+// ResendEmailVerification sends the user a new verification token, replacing
+// the old one. Like ForgotPassword it succeeds whether or not there's anyone
+// to send to, and makes and saves a token that's never sent when there
+// isn't, so that it can't be used to find out which usernames are taken.
+// Nor does it return a failure to send.
+func ResendEmailVerification(
+	deps ResendEmailVerificationDependencies, username string,
+) error {
+	if deps.VerificationNotifier == nil {
+		return nil
+	}
+	user, err := deps.UserGetter.GetUserByUsername(username)
+	if err != nil && err != ErrNotFound {
+		return ErrInternal
+	}
+	if err == ErrNotFound || user.Email == "" || user.EmailVerified {
+		_, err = saveEmailVerificationToken(deps, entities.User{})
+		return err
+	}
+	return sendEmailVerification(deps, user)
+}
+
+// sendEmailVerification leaves reporting a failure to send to the
+// notifier, as ForgotPassword does.
+func sendEmailVerification(
+	deps ResendEmailVerificationDependencies, user entities.User,
+) error {
+	token, err := saveEmailVerificationToken(deps, user)
+	if err != nil {
+		return err
+	}
+	deps.VerificationNotifier.SendEmailVerification(user, token)
+	return nil
+}
+
+func saveEmailVerificationToken(
+	deps ResendEmailVerificationDependencies, user entities.User,
+) (string, error) {
+	token, hash, err := deps.TokenGenerator.NewToken()
+	if err != nil {
+		return "", ErrInternal
+	}
+	err = deps.VerificationStore.SaveEmailVerificationToken(entities.EmailVerificationToken{
+		Hash:      hash,
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: deps.TimeGetter.GetTime() + verificationTokenTTL(deps).Seconds(),
+	})
+	if err != nil {
+		return "", ErrInternal
+	}
+	return token, nil
+}
+
+func verificationTokenTTL(deps ResendEmailVerificationDependencies) time.Duration {
+	if deps.VerificationTokenTTL == 0 {
+		return DefaultEmailVerificationTTL
+	}
+	return deps.VerificationTokenTTL
+}
+
+type VerifyEmailDependencies struct {
+	TokenGenerator    interfaces.OneTimeTokenGenerator
+	VerificationStore interfaces.EmailVerificationStore
+	TimeGetter        interfaces.TimeGetter
+	EmailVerifier     interfaces.EmailVerifier
+}
+
+// VerifyEmail marks the address a verification token was sent to as
+// verified, as long as it's still the user's address.
+func VerifyEmail(deps VerifyEmailDependencies, verificationToken string) error {
+	hash := deps.TokenGenerator.HashToken(verificationToken)
+	token, err := getEmailVerificationToken(deps, hash)
+	if err != nil {
+		return err
+	}
+
+	err = deps.VerificationStore.UseEmailVerificationToken(hash)
+	if err == ErrNotFound {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return ErrInternal
+	}
+
+	err = deps.EmailVerifier.MarkEmailVerified(token.UserID, token.Email)
+	if err == ErrNotFound {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+func getEmailVerificationToken(
+	deps VerifyEmailDependencies, hash string,
+) (entities.EmailVerificationToken, error) {
+	token, err := deps.VerificationStore.GetEmailVerificationToken(hash)
+	if err == ErrNotFound {
+		return entities.EmailVerificationToken{}, ErrInvalidToken
+	}
+	if err != nil {
+		return entities.EmailVerificationToken{}, ErrInternal
+	}
+	if token.ExpiresAt <= deps.TimeGetter.GetTime() {
+		return entities.EmailVerificationToken{}, ErrInvalidToken
+	}
+	return token, nil
+}
@@ -0,0 +1,265 @@
+package usecases_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/steve-kaufman/go-auth-service/entities"
+	"github.com/steve-kaufman/go-auth-service/implementations/db"
+	"github.com/steve-kaufman/go-auth-service/usecases"
+)
+
+type MockVerificationNotifier struct {
+	err error
+
+	user  entities.User
+	token string
+}
+
+func (notifier *MockVerificationNotifier) SendEmailVerification(
+	user entities.User, token string,
+) error {
+	notifier.user = user
+	notifier.token = token
+	return notifier.err
+}
+
+// MockVerificationTokens gives verification tokens their own value, apart
+// from MockOneTimeTokens' reset tokens.
+type MockVerificationTokens struct {
+	MockOneTimeTokens
+}
+
+func (tokens MockVerificationTokens) NewToken() (string, string, error) {
+	return "verification-token", "hash:verification-token", tokens.err
+}
+
+type ResendEmailVerificationTest struct {
+	name string
+
+	notifier      *MockVerificationNotifier
+	inputUsername string
+
+	expectedErr   error
+	expectedToken string
+}
+
+var resendEmailVerificationTests = []ResendEmailVerificationTest{
+	{
+		name: "Returns nil without sending for unknown user",
+
+		notifier:      new(MockVerificationNotifier),
+		inputUsername: "nobody",
+
+		expectedErr: nil,
+	},
+	{
+		name: "Returns nil without sending for user without email",
+
+		notifier:      new(MockVerificationNotifier),
+		inputUsername: "user1",
+
+		expectedErr: nil,
+	},
+	{
+		name: "Returns nil without sending for already verified user",
+
+		notifier:      new(MockVerificationNotifier),
+		inputUsername: "user3",
+
+		expectedErr: nil,
+	},
+	{
+		name: "Returns nil with bad EmailVerificationNotifier",
+
+		notifier:      &MockVerificationNotifier{err: errors.New("foo")},
+		inputUsername: "user4",
+
+		expectedErr:   nil,
+		expectedToken: "verification-token",
+	},
+	{
+		name: "Sends verification token",
+
+		notifier:      new(MockVerificationNotifier),
+		inputUsername: "user4",
+
+		expectedErr:   nil,
+		expectedToken: "verification-token",
+	},
+}
+
+// MockEmailUserGetter adds an unverified user with an email to
+// exampleUsers.
+type MockEmailUserGetter struct{}
+
+func (MockEmailUserGetter) GetUserByUsername(username string) (entities.User, error) {
+	if username == "user4" {
+		return entities.User{
+			ID:       4,
+			Username: "user4",
+			Password: mockHash("pass4"),
+			Email:    "user4@example.com",
+		}, nil
+	}
+	return new(MockUserGetter).GetUserByUsername(username)
+}
+
+func (getter MockEmailUserGetter) GetUserByEmail(email string) (entities.User, error) {
+	if email == "user4@example.com" {
+		return getter.GetUserByUsername("user4")
+	}
+	return new(MockUserGetter).GetUserByEmail(email)
+}
+
+func TestResendEmailVerification(t *testing.T) {
+	for _, tc := range resendEmailVerificationTests {
+		t.Run(tc.name, func(t *testing.T) {
+			store := db.NewMemory()
+			deps := usecases.ResendEmailVerificationDependencies{
+				UserGetter:           new(MockEmailUserGetter),
+				TokenGenerator:       MockVerificationTokens{},
+				VerificationStore:    store,
+				VerificationNotifier: tc.notifier,
+				TimeGetter:           new(MockTimeGetter),
+			}
+
+			err := usecases.ResendEmailVerification(deps, tc.inputUsername)
+
+			if err != tc.expectedErr {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+			if tc.notifier.token != tc.expectedToken {
+				t.Fatalf("Expected token sent: '%s'; Got: '%s'",
+					tc.expectedToken, tc.notifier.token)
+			}
+			token, err := store.GetEmailVerificationToken("hash:verification-token")
+			if err != nil {
+				t.Fatalf("Expected token saved by hash; Got: '%v'", err)
+			}
+			expected := entities.EmailVerificationToken{
+				Hash:      "hash:verification-token",
+				ExpiresAt: 100 + usecases.DefaultEmailVerificationTTL.Seconds(),
+			}
+			// A token that isn't sent is still saved, for no one.
+			if tc.expectedToken != "" {
+				expected.UserID = 4
+				expected.Email = "user4@example.com"
+			}
+			if token != expected {
+				t.Fatalf("Expected token: %+v; Got: %+v", expected, token)
+			}
+		})
+	}
+}
+
+func setupVerifyEmail(t *testing.T, token entities.EmailVerificationToken) (
+	usecases.VerifyEmailDependencies, *db.Memory,
+) {
+	store := db.NewMemory()
+	err := store.CreateUser(entities.User{
+		Username: "newuser", Password: "foo", Email: "new@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SaveEmailVerificationToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return usecases.VerifyEmailDependencies{
+		TokenGenerator:    MockVerificationTokens{},
+		VerificationStore: store,
+		TimeGetter:        new(MockTimeGetter),
+		EmailVerifier:     store,
+	}, store
+}
+
+type VerifyEmailTest struct {
+	name string
+
+	storedToken entities.EmailVerificationToken
+	inputToken  string
+
+	expectedErr      error
+	expectedVerified bool
+}
+
+var verifyEmailTests = []VerifyEmailTest{
+	{
+		name: "Returns ErrInvalidToken with unknown token",
+
+		storedToken: entities.EmailVerificationToken{
+			Hash: "hash:verification-token", UserID: 1, Email: "new@example.com", ExpiresAt: 200,
+		},
+		inputToken: "forged-token",
+
+		expectedErr: usecases.ErrInvalidToken,
+	},
+	{
+		name: "Returns ErrInvalidToken with expired token",
+
+		storedToken: entities.EmailVerificationToken{
+			Hash: "hash:verification-token", UserID: 1, Email: "new@example.com", ExpiresAt: 100,
+		},
+		inputToken: "verification-token",
+
+		expectedErr: usecases.ErrInvalidToken,
+	},
+	{
+		name: "Returns ErrInvalidToken when sent to a different email",
+
+		storedToken: entities.EmailVerificationToken{
+			Hash: "hash:verification-token", UserID: 1, Email: "old@example.com", ExpiresAt: 200,
+		},
+		inputToken: "verification-token",
+
+		expectedErr: usecases.ErrInvalidToken,
+	},
+	{
+		name: "Verifies email",
+
+		storedToken: entities.EmailVerificationToken{
+			Hash: "hash:verification-token", UserID: 1, Email: "new@example.com", ExpiresAt: 200,
+		},
+		inputToken: "verification-token",
+
+		expectedErr:      nil,
+		expectedVerified: true,
+	},
+}
+
+func TestVerifyEmail(t *testing.T) {
+	for _, tc := range verifyEmailTests {
+		t.Run(tc.name, func(t *testing.T) {
+			deps, store := setupVerifyEmail(t, tc.storedToken)
+
+			err := usecases.VerifyEmail(deps, tc.inputToken)
+
+			if err != tc.expectedErr {
+				t.Fatalf("Expected err: '%v'; Got: '%v'", tc.expectedErr, err)
+			}
+			user, _ := store.GetUserByUsername("newuser")
+			if user.EmailVerified != tc.expectedVerified {
+				t.Fatalf("Expected verified: %v; Got: %v",
+					tc.expectedVerified, user.EmailVerified)
+			}
+		})
+	}
+}
+
+func TestVerifyEmail_TokenWorksOnce(t *testing.T) {
+	deps, _ := setupVerifyEmail(t, entities.EmailVerificationToken{
+		Hash: "hash:verification-token", UserID: 1, Email: "new@example.com", ExpiresAt: 200,
+	})
+
+	err := usecases.VerifyEmail(deps, "verification-token")
+	if err != nil {
+		t.Fatalf("Expected no error; Got: '%v'", err)
+	}
+
+	err = usecases.VerifyEmail(deps, "verification-token")
+	if err != usecases.ErrInvalidToken {
+		t.Fatalf("Expected err: '%v'; Got: '%v'", usecases.ErrInvalidToken, err)
+	}
+}